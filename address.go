@@ -100,6 +100,9 @@ func ParseAddress(bs []byte) (Address, error) {
 }
 
 func parseAddress(toks []token) (Address, error) {
+	if len(toks) == 0 {
+		return nil, errors.New("empty address")
+	}
 	// If this is a group, it must end in a ";" token.
 	ltok := toks[len(toks)-1]
 	if len(ltok) == 1 && ltok[0] == ';' {
@@ -149,6 +152,10 @@ func parseMailboxAddr(ts []token) (ma MailboxAddr, err error) {
 	// characters up to "<" constitute the name. Otherwise, there is no
 	// name.
 	ma = MailboxAddr{}
+	if len(ts) == 0 {
+		err = errors.New("empty mailbox")
+		return
+	}
 	ltok := ts[len(ts)-1]
 	if len(ltok) == 1 && ltok[0] == '>' {
 		var nts, ats []token
@@ -169,6 +176,9 @@ func parseMailboxAddr(ts []token) (ma MailboxAddr, err error) {
 
 func parseSimpleAddr(ts []token) (l, d string, e error) {
 	// The second token must be '@' - all further tokens are stuck in the domain.
+	if len(ts) < 3 {
+		return "", "", errors.New("invalid simpleAddr")
+	}
 	l = string(ts[0])
 	if !(len(ts[1]) == 1 && ts[1][0] == '@') {
 		return "", "", errors.New("invalid simpleAddr")
@@ -0,0 +1,317 @@
+// Parsing and validation of ARC sets (RFC 8617).
+
+package eml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxARCInstance is the highest ARC instance number allowed.
+const maxARCInstance = 50
+
+// ARCSeal is a parsed ARC-Seal header field.
+type ARCSeal struct {
+	Instance  int
+	Algorithm string // a=
+	Signature []byte // b=
+	Domain    string // d=
+	Selector  string // s=
+	// ChainValidation is the status of the chain as seen by the sealer:
+	// AuthNone for the first instance, AuthPass or AuthFail otherwise.
+	ChainValidation AuthStatus
+	Timestamp       time.Time
+	raw             []byte
+}
+
+// ARCSet holds the three header fields added by one intermediary.
+type ARCSet struct {
+	Instance int
+	// AuthenticationResults is the value of the ARC-Authentication-Results
+	// header field, without the instance tag.
+	AuthenticationResults string
+	// MessageSignature is the parsed ARC-Message-Signature. Its i= tag
+	// is taken as the instance, so Identity keeps its default of "@"
+	// followed by the domain.
+	MessageSignature *DKIMSignature
+	Seal             *ARCSeal
+
+	aar []byte // the raw ARC-Authentication-Results header field
+}
+
+// ARCResult is the outcome of validating the ARC chain of a message.
+type ARCResult struct {
+	// Status is AuthNone for messages without ARC sets, AuthPass for a
+	// valid chain and AuthFail otherwise. Temporary DNS failures give
+	// AuthTempError.
+	Status AuthStatus
+	Sets   []ARCSet
+	// OldestPass is the lowest instance whose ARC-Message-Signature still
+	// validates, counting down from the newest, or 0 if the newest does
+	// not validate.
+	OldestPass int
+	// Err explains why the chain did not pass.
+	Err error
+}
+
+var arcInstanceR = regexp.MustCompile(`^[ \t\r\n]*i[ \t\r\n]*=[ \t\r\n]*([0-9]+)[ \t\r\n]*(;|$)`)
+
+// ParseARCSets collects the ARC sets of a message, ordered by instance. It
+// fails if a header field cannot be parsed or a set is incomplete or
+// duplicated.
+func ParseARCSets(r RawMessage) ([]ARCSet, error) {
+	sets := map[int]*ARCSet{}
+	set := func(i int) *ARCSet {
+		if sets[i] == nil {
+			sets[i] = &ARCSet{Instance: i}
+		}
+		return sets[i]
+	}
+	for _, h := range r.RawHeaders {
+		key := strings.ToLower(string(h.Key))
+		switch key {
+		case "arc-authentication-results", "arc-message-signature", "arc-seal":
+		default:
+			continue
+		}
+		i, err := arcInstance(key, h.Value)
+		if err != nil {
+			return nil, fmt.Errorf("arc: %s: %v", h.Key, err)
+		}
+		s := set(i)
+		dup := false
+		switch key {
+		case "arc-authentication-results":
+			dup = s.aar != nil
+			s.aar = h.Raw
+			m := arcInstanceR.FindIndex(h.Value)
+			s.AuthenticationResults = strings.TrimSpace(string(h.Value[m[1]:]))
+		case "arc-message-signature":
+			dup = s.MessageSignature != nil
+			s.MessageSignature, err = parseARCMessageSignature(string(h.Value))
+			if err != nil {
+				return nil, err
+			}
+			s.MessageSignature.raw = h.Raw
+		case "arc-seal":
+			dup = s.Seal != nil
+			s.Seal, err = ParseARCSeal(string(h.Value))
+			if err != nil {
+				return nil, err
+			}
+			s.Seal.raw = h.Raw
+		}
+		if dup {
+			return nil, fmt.Errorf("arc: duplicate %s for instance %d", h.Key, i)
+		}
+	}
+
+	var result []ARCSet
+	for _, s := range sets {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Instance < result[j].Instance })
+	for i, s := range result {
+		if s.Instance != i+1 {
+			return nil, fmt.Errorf("arc: instance %d is missing", i+1)
+		}
+		if s.aar == nil || s.MessageSignature == nil || s.Seal == nil {
+			return nil, fmt.Errorf("arc: set %d is incomplete", s.Instance)
+		}
+	}
+	return result, nil
+}
+
+// arcInstance returns the instance of an ARC header field. It is the
+// leading i= tag of ARC-Authentication-Results and may be anywhere in the
+// tag list of ARC-Message-Signature and ARC-Seal.
+func arcInstance(key string, value []byte) (int, error) {
+	var i string
+	if key == "arc-authentication-results" {
+		m := arcInstanceR.FindSubmatch(value)
+		if m == nil {
+			return 0, errors.New("missing instance")
+		}
+		i = string(m[1])
+	} else {
+		tags, err := parseTagList(string(value))
+		if err != nil {
+			return 0, err
+		}
+		var ok bool
+		if i, ok = tags["i"]; !ok {
+			return 0, errors.New("missing instance")
+		}
+	}
+	n, err := strconv.Atoi(i)
+	if err != nil || n < 1 || n > maxARCInstance {
+		return 0, fmt.Errorf("invalid instance %q", i)
+	}
+	return n, nil
+}
+
+// parseARCMessageSignature parses the value of an ARC-Message-Signature,
+// which has the tags of a DKIM-Signature, except that i= is the instance
+// and there is no v=.
+func parseARCMessageSignature(value string) (*DKIMSignature, error) {
+	tags, err := parseTagList(value)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tags["v"]; ok {
+		return nil, errors.New("arc: v= in ARC-Message-Signature")
+	}
+	delete(tags, "i")
+	tags["v"] = "1"
+	sig, err := dkimSignatureFromTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	sig.Version = 0
+	return sig, nil
+}
+
+// ParseARCSeal parses the value of an ARC-Seal header field.
+func ParseARCSeal(value string) (*ARCSeal, error) {
+	tags, err := parseTagList(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"i", "a", "b", "d", "s", "cv"} {
+		if _, ok := tags[k]; !ok {
+			return nil, fmt.Errorf("arc: missing %s= tag in ARC-Seal", k)
+		}
+	}
+	if _, ok := tags["h"]; ok {
+		return nil, errors.New("arc: h= in ARC-Seal")
+	}
+	seal := &ARCSeal{
+		Algorithm: strings.ToLower(tags["a"]),
+		Domain:    strings.ToLower(strings.TrimSuffix(tags["d"], ".")),
+		Selector:  tags["s"],
+	}
+	if seal.Instance, err = strconv.Atoi(tags["i"]); err != nil {
+		return nil, fmt.Errorf("arc: invalid instance %q", tags["i"])
+	}
+	if seal.Signature, err = decodeTagBase64(tags["b"]); err != nil {
+		return nil, fmt.Errorf("arc: b= %v", err)
+	}
+	switch cv := AuthStatus(strings.ToLower(tags["cv"])); cv {
+	case AuthNone, AuthPass, AuthFail:
+		seal.ChainValidation = cv
+	default:
+		return nil, fmt.Errorf("arc: invalid cv= %q", tags["cv"])
+	}
+	if t, ok := tags["t"]; ok {
+		n, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("arc: invalid t= %q", t)
+		}
+		seal.Timestamp = time.Unix(n, 0)
+	}
+	return seal, nil
+}
+
+// arcSealInput returns the data covered by the seal of the n-th set: the
+// sets up to n, each as ARC-Authentication-Results, ARC-Message-Signature
+// and ARC-Seal in relaxed canonicalization, where the b= tag of the last
+// seal is empty and its final CRLF is left out.
+func arcSealInput(sets []ARCSet, n int) []byte {
+	var b bytes.Buffer
+	for _, s := range sets[:n] {
+		b.WriteString(canonicalHeader(s.aar, "relaxed"))
+		b.WriteString(canonicalHeader(s.MessageSignature.raw, "relaxed"))
+		if s.Instance < n {
+			b.WriteString(canonicalHeader(s.Seal.raw, "relaxed"))
+		}
+	}
+	name, value, _ := strings.Cut(string(sets[n-1].Seal.raw), ":")
+	value = signatureTagR.ReplaceAllString(value, "$1$2")
+	b.WriteString(strings.TrimSuffix(canonicalHeader([]byte(name+":"+value), "relaxed"), "\r\n"))
+	return b.Bytes()
+}
+
+// VerifyARC validates the ARC chain of a message as described in RFC 8617
+// section 5.2: the sets must be complete and numbered without gaps, the
+// cv= of the newest seal must not be "fail", the newest
+// ARC-Message-Signature must validate, and so must all seals. Keys are
+// looked up through the Resolver of the verifier. Older message signatures
+// are checked as well, to report the oldest one that still validates.
+func (v DKIMVerifier) VerifyARC(ctx context.Context, r RawMessage) ARCResult {
+	sets, err := ParseARCSets(r)
+	if err != nil {
+		return ARCResult{Status: AuthFail, Err: err}
+	}
+	res := ARCResult{Status: AuthNone, Sets: sets}
+	if len(sets) == 0 {
+		return res
+	}
+	fail := func(status AuthStatus, err error) ARCResult {
+		res.Status, res.Err = status, err
+		return res
+	}
+
+	n := len(sets)
+	if sets[n-1].Seal.ChainValidation == AuthFail {
+		return fail(AuthFail, fmt.Errorf("arc: chain failed at instance %d", n))
+	}
+	for _, s := range sets {
+		want := AuthPass
+		if s.Instance == 1 {
+			want = AuthNone
+		}
+		if s.Seal.ChainValidation != want {
+			return fail(AuthFail, fmt.Errorf("arc: cv=%s in instance %d", s.Seal.ChainValidation, s.Instance))
+		}
+		if s.Seal.Instance != s.Instance {
+			return fail(AuthFail, fmt.Errorf("arc: seal instance mismatch in set %d", s.Instance))
+		}
+	}
+
+	newest := v.verify(ctx, r, sets[n-1].MessageSignature)
+	if newest.Status != AuthPass {
+		status := AuthFail
+		if newest.Status == AuthTempError {
+			status = AuthTempError
+		}
+		return fail(status, fmt.Errorf("arc: message signature %d: %w", n, newest.Err))
+	}
+	res.OldestPass = n
+	for i := n - 2; i >= 0; i-- {
+		if v.verify(ctx, r, sets[i].MessageSignature).Status != AuthPass {
+			break
+		}
+		res.OldestPass = i + 1
+	}
+
+	for i := n; i >= 1; i-- {
+		seal := sets[i-1].Seal
+		if seal.Algorithm != "rsa-sha256" && seal.Algorithm != "ed25519-sha256" {
+			return fail(AuthFail, fmt.Errorf("arc: unsupported algorithm %q in seal %d", seal.Algorithm, i))
+		}
+		sig := &DKIMSignature{
+			Algorithm:    seal.Algorithm,
+			Signature:    seal.Signature,
+			Domain:       seal.Domain,
+			Selector:     seal.Selector,
+			Identity:     "@" + seal.Domain,
+			QueryMethods: []string{"dns/txt"},
+		}
+		_, status, err := v.checkSignature(ctx, sig, arcSealInput(sets, i))
+		if status != AuthPass {
+			if status != AuthTempError {
+				status = AuthFail
+			}
+			return fail(status, fmt.Errorf("arc: seal %d: %w", i, err))
+		}
+	}
+	res.Status = AuthPass
+	return res
+}
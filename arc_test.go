@@ -0,0 +1,110 @@
+package eml
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// addARCSet adds an ARC set with the given instance and cv= to msg, signed
+// by the ed25519 key with selector "arc" of example.org.
+func addARCSet(t *testing.T, msg string, key ed25519.PrivateKey, instance int, cv string) string {
+	return addARCSetTags(t, msg, key, instance, fmt.Sprintf("i=%d; a=ed25519-sha256", instance), cv)
+}
+
+// addARCSetTags is addARCSet with the given first tags of the
+// ARC-Message-Signature and ARC-Seal, which must include i= and a=.
+func addARCSetTags(t *testing.T, msg string, key ed25519.PrivateKey, instance int, head, cv string) string {
+	r, err := ParseRaw([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bh := sha256.Sum256(canonicalBody(r.Body, "relaxed"))
+	aar := fmt.Sprintf("ARC-Authentication-Results: i=%d; mx.example.org; dkim=pass header.d=football.example.com", instance)
+	ams := fmt.Sprintf("ARC-Message-Signature: %s; c=relaxed/relaxed; d=example.org; s=arc;\r\n\th=From:Subject; bh=%s; b=", head, base64.StdEncoding.EncodeToString(bh[:]))
+	hash := sha256.Sum256(dkimSigningInput(r.RawHeaders, []string{"From", "Subject"}, []byte(ams), "relaxed"))
+	sig, _ := key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	ams += base64.StdEncoding.EncodeToString(sig)
+
+	seal := fmt.Sprintf("ARC-Seal: %s; cv=%s; d=example.org; s=arc; t=1100000000; b=", head, cv)
+	unsealed := seal + "\r\n" + ams + "\r\n" + aar + "\r\n" + msg
+	r, err = ParseRaw([]byte(unsealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets, err := ParseARCSets(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash = sha256.Sum256(arcSealInput(sets, instance))
+	sig, _ = key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	return seal + base64.StdEncoding.EncodeToString(sig) + "\r\n" + ams + "\r\n" + aar + "\r\n" + msg
+}
+
+func TestVerifyARC(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	v := DKIMVerifier{Resolver: dkimKeyStore{
+		"arc._domainkey.example.org": "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub),
+	}}
+	verify := func(msg string) ARCResult {
+		r, err := ParseRaw([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.VerifyARC(context.Background(), r)
+	}
+
+	if res := verify(dkimTestMessage); res.Status != AuthNone {
+		t.Errorf("message without ARC: got %s", res.Status)
+	}
+
+	one := addARCSet(t, dkimTestMessage, key, 1, "none")
+	res := verify(one)
+	if res.Status != AuthPass || res.OldestPass != 1 || len(res.Sets) != 1 {
+		t.Errorf("one set: got %s, oldest pass %d (%v)", res.Status, res.OldestPass, res.Err)
+	}
+	if aar := res.Sets[0].AuthenticationResults; aar != "mx.example.org; dkim=pass header.d=football.example.com" {
+		t.Errorf("unexpected authentication results %q", aar)
+	}
+
+	// A mailing list changes the subject and adds its own set.
+	listed := strings.Replace(one, "Subject: ", "Subject: [list] ", 1)
+	two := addARCSet(t, listed, key, 2, "pass")
+	if res := verify(two); res.Status != AuthPass || res.OldestPass != 2 {
+		t.Errorf("two sets: got %s, oldest pass %d (%v)", res.Status, res.OldestPass, res.Err)
+	}
+	if res := verify(addARCSet(t, one, key, 2, "pass")); res.Status != AuthPass || res.OldestPass != 1 {
+		t.Errorf("two sets without changes: got %s, oldest pass %d (%v)", res.Status, res.OldestPass, res.Err)
+	}
+
+	// i= need not come first in ARC-Message-Signature and ARC-Seal, and
+	// other ARC- fields are ignored.
+	reordered := "ARC-Filter: whatever\r\n" + addARCSetTags(t, dkimTestMessage, key, 1, "a=ed25519-sha256; i=1", "none")
+	if res := verify(reordered); res.Status != AuthPass || res.OldestPass != 1 {
+		t.Errorf("i= not first: got %s, oldest pass %d (%v)", res.Status, res.OldestPass, res.Err)
+	}
+
+	failing := []struct {
+		name string
+		msg  string
+	}{
+		{"body changed after sealing", strings.Replace(two, "hungry", "thirsty", 1)},
+		{"set removed", two[strings.Index(two, "ARC-Seal: i=1"):]},
+		{"wrong cv", addARCSet(t, listed, key, 2, "none")},
+		{"cv fail", addARCSet(t, listed, key, 2, "fail")},
+		{"seal changed", strings.Replace(two, "cv=none", "cv=none ", 1)},
+		{"results changed", strings.Replace(two, "i=1; mx.example.org; dkim=pass", "i=1; mx.example.org; dkim=fail", 1)},
+		{"incomplete set", strings.Replace(one, "ARC-Authentication-Results", "X-Authentication-Results", 1)},
+	}
+	for _, f := range failing {
+		if res := verify(f.msg); res.Status != AuthFail {
+			t.Errorf("%s: got %s", f.name, res.Status)
+		}
+	}
+}
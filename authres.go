@@ -0,0 +1,199 @@
+// Parsing of Authentication-Results header fields (RFC 8601).
+
+package eml
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// AuthResults is a parsed Authentication-Results header field.
+type AuthResults struct {
+	// AuthServID identifies the host that performed the checks.
+	AuthServID string
+	// Version is the version of the header field format, 1 if not given.
+	Version int
+	// Results are the outcomes of the individual checks; it is empty if
+	// no checks were performed ("none").
+	Results []AuthResult
+}
+
+// AuthResult is the outcome of one authentication method.
+type AuthResult struct {
+	// Method is the lower-cased name of the method, such as "spf", "dkim",
+	// "dmarc" or "arc".
+	Method string
+	// Status is the lower-cased result. Besides the AuthStatus constants,
+	// methods define values such as "softfail" for spf.
+	Status AuthStatus
+	// Reason is the value of the reason property, if given.
+	Reason string
+	// Properties maps lower-cased "ptype.property" names, such as
+	// "header.d" or "smtp.mailfrom", to their values.
+	Properties map[string]string
+}
+
+// Method returns the results of the given method.
+func (a AuthResults) Method(method string) []AuthResult {
+	var rs []AuthResult
+	for _, r := range a.Results {
+		if strings.EqualFold(r.Method, method) {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// authToken is a token of an Authentication-Results header field: a word,
+// a quoted string, or one of the separators ";" and "=".
+type authToken struct {
+	text   string
+	quoted bool
+}
+
+func (t authToken) is(sep string) bool {
+	return !t.quoted && t.text == sep
+}
+
+// lexAuthResults splits an Authentication-Results value into tokens,
+// dropping white space and comments. Unterminated comments and quoted
+// strings extend to the end of the value.
+func lexAuthResults(s string) []authToken {
+	var ts []authToken
+	// Adjacent words and quoted strings, as in "a b"@example.com, form
+	// one token.
+	end := -1
+	add := func(t authToken, start, i int) {
+		if start == end && len(ts) > 0 && !ts[len(ts)-1].is(";") && !ts[len(ts)-1].is("=") {
+			ts[len(ts)-1].text += t.text
+			ts[len(ts)-1].quoted = ts[len(ts)-1].quoted || t.quoted
+		} else {
+			ts = append(ts, t)
+		}
+		end = i
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			depth := 0
+			for ; i < len(s); i++ {
+				if s[i] == '\\' {
+					i++
+				} else if s[i] == '(' {
+					depth++
+				} else if s[i] == ')' {
+					depth--
+					if depth == 0 {
+						i++
+						break
+					}
+				}
+			}
+		case c == '"':
+			start := i
+			var b strings.Builder
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			i++
+			add(authToken{text: b.String(), quoted: true}, start, i)
+		case c == ';' || c == '=':
+			ts = append(ts, authToken{text: string(c)})
+			i++
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\r\n();=\"", rune(s[i])) {
+				i++
+			}
+			add(authToken{text: s[start:i]}, start, i)
+		}
+	}
+	return ts
+}
+
+// ParseAuthenticationResults parses the value of an Authentication-Results
+// header field. Comments and white space are allowed anywhere between
+// tokens. Malformed method results are skipped; only a missing authserv-id
+// is an error.
+func ParseAuthenticationResults(value string) (AuthResults, error) {
+	ts := lexAuthResults(value)
+	a := AuthResults{Version: 1}
+	if len(ts) == 0 || ts[0].is(";") || ts[0].is("=") {
+		return a, errors.New("authentication-results: missing authserv-id")
+	}
+	a.AuthServID = ts[0].text
+	ts = ts[1:]
+	if len(ts) > 0 && !ts[0].quoted && !ts[0].is(";") {
+		if v, err := strconv.Atoi(ts[0].text); err == nil {
+			a.Version = v
+			ts = ts[1:]
+		}
+	}
+
+	var groups [][]authToken
+	for _, t := range ts {
+		if t.is(";") {
+			groups = append(groups, nil)
+		} else if len(groups) > 0 {
+			groups[len(groups)-1] = append(groups[len(groups)-1], t)
+		}
+	}
+	for _, g := range groups {
+		if len(g) == 1 && strings.EqualFold(g[0].text, "none") {
+			continue
+		}
+		if r, ok := parseAuthResult(g); ok {
+			a.Results = append(a.Results, r)
+		}
+	}
+	return a, nil
+}
+
+// parseAuthResult parses the "key=value" pairs of one method result.
+func parseAuthResult(ts []authToken) (AuthResult, bool) {
+	var r AuthResult
+	for i := 0; i < len(ts); {
+		if i+2 >= len(ts) || ts[i].quoted || ts[i].is("=") || !ts[i+1].is("=") || ts[i+2].is("=") {
+			// Skip stray tokens.
+			i++
+			continue
+		}
+		key := strings.ToLower(ts[i].text)
+		value := ts[i+2].text
+		i += 3
+		switch {
+		case r.Method == "":
+			// The method may carry a version, as in "dkim/1".
+			r.Method = strings.TrimSpace(strings.SplitN(key, "/", 2)[0])
+			r.Status = AuthStatus(strings.ToLower(value))
+		case key == "reason":
+			r.Reason = value
+		default:
+			if r.Properties == nil {
+				r.Properties = map[string]string{}
+			}
+			r.Properties[key] = value
+		}
+	}
+	return r, r.Method != ""
+}
+
+// AuthenticationResults parses the Authentication-Results header fields, in
+// the order they appear. Like the other getters, it only finds fields whose
+// name is spelled exactly so. Fields that cannot be parsed are skipped.
+func (h HeaderList) AuthenticationResults() []AuthResults {
+	var results []AuthResults
+	for _, v := range h["Authentication-Results"] {
+		if a, err := ParseAuthenticationResults(v); err == nil {
+			results = append(results, a)
+		}
+	}
+	return results
+}
@@ -0,0 +1,65 @@
+package eml
+
+import (
+	"reflect"
+	"testing"
+)
+
+type authResultsTest struct {
+	value string
+	ret   AuthResults
+}
+
+var authResultsTests = []authResultsTest{
+	{
+		"example.org 1; none",
+		AuthResults{AuthServID: "example.org", Version: 1},
+	},
+	{
+		"mx.google.com;\r\n       dkim=pass header.i=@example.com header.s=s1 header.b=AbCd;\r\n" +
+			"       spf=pass (google.com: domain of bounce@example.com designates 192.0.2.1 as permitted sender) smtp.mailfrom=bounce@example.com;\r\n" +
+			"       dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=example.com",
+		AuthResults{AuthServID: "mx.google.com", Version: 1, Results: []AuthResult{
+			{Method: "dkim", Status: AuthPass, Properties: map[string]string{"header.i": "@example.com", "header.s": "s1", "header.b": "AbCd"}},
+			{Method: "spf", Status: AuthPass, Properties: map[string]string{"smtp.mailfrom": "bounce@example.com"}},
+			{Method: "dmarc", Status: AuthPass, Properties: map[string]string{"header.from": "example.com"}},
+		}},
+	},
+	{
+		"(leading comment) example.com 2 (version (nested) comment); SPF = SoftFail (sender (not) permitted) smtp.mailfrom = \"odd;\\\"user\"@example.net" +
+			"; dkim/1 = fail reason=\"signature (did) not verify\" header.d=example.net; arc=none; garbage",
+		AuthResults{AuthServID: "example.com", Version: 2, Results: []AuthResult{
+			{Method: "spf", Status: "softfail", Properties: map[string]string{"smtp.mailfrom": "odd;\"user@example.net"}},
+			{Method: "dkim", Status: AuthFail, Reason: "signature (did) not verify", Properties: map[string]string{"header.d": "example.net"}},
+			{Method: "arc", Status: AuthNone},
+		}},
+	},
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	for _, at := range authResultsTests {
+		a, err := ParseAuthenticationResults(at.value)
+		if err != nil {
+			t.Errorf("ParseAuthenticationResults(%q) failed: %s", at.value, err)
+		} else if !reflect.DeepEqual(a, at.ret) {
+			t.Errorf("ParseAuthenticationResults(%q) = %#v; expected %#v", at.value, a, at.ret)
+		}
+	}
+	if _, err := ParseAuthenticationResults(" (only a comment) ; spf=pass"); err == nil {
+		t.Errorf("missing authserv-id not detected")
+	}
+}
+
+func TestHeaderListAuthenticationResults(t *testing.T) {
+	h := HeaderList{
+		"Authentication-Results": {"a.example; spf=pass smtp.mailfrom=x@y.example", "b.example; none", ";bad"},
+		"authentication-results": {"c.example; none"},
+	}
+	rs := h.AuthenticationResults()
+	if len(rs) != 2 || rs[0].AuthServID != "a.example" || rs[1].AuthServID != "b.example" {
+		t.Fatalf("unexpected results %#v", rs)
+	}
+	if spf := rs[0].Method("SPF"); len(spf) != 1 || spf[0].Properties["smtp.mailfrom"] != "x@y.example" {
+		t.Errorf("unexpected spf results %#v", spf)
+	}
+}
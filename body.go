@@ -0,0 +1,184 @@
+// Selection of the displayed body among the parts of a message.
+
+package eml
+
+import (
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/Schidstorm/eml/decoder"
+)
+
+// Body is a candidate for the displayed body of a message: an inline
+// text/plain or text/html part.
+type Body struct {
+	// Part is the index of the part in Message.Parts.
+	Part int
+	// MediaType is "text/plain" or "text/html".
+	MediaType string
+	// Text is the content of the part, see Part.Text.
+	Text string
+	// Selected reports whether the body makes up Message.Text or
+	// Message.Html. Bodies that lost to a later alternative are not
+	// selected.
+	Selected bool
+}
+
+// isAttachment reports whether a part has an attachment disposition.
+func (p Part) isAttachment() bool {
+	cd, ok := p.Headers["Content-Disposition"]
+	if !ok || len(cd) == 0 {
+		return false
+	}
+	d, _, err := mime.ParseMediaType(cd[0])
+	if err != nil {
+		return strings.Contains(strings.ToLower(cd[0]), "attachment")
+	}
+	return d == "attachment"
+}
+
+// filename returns the file name of a part: the filename parameter of its
+// Content-Disposition, or else the name parameter of its Content-Type. It is
+// "" if neither is set.
+func (p Part) filename() string {
+	if cd, ok := p.Headers["Content-Disposition"]; ok && len(cd) > 0 {
+		if name := headerParam(cd[0], "filename"); name != "" {
+			return name
+		}
+	}
+	return headerParam(p.Type, "name")
+}
+
+// headerParam returns a parameter of a header field value like that of
+// Content-Type, with RFC 2231 and encoded-word encodings undone. In values
+// that mime.ParseMediaType rejects, a quoted parameter is still found.
+func headerParam(value, param string) string {
+	var v string
+	if _, ps, err := mime.ParseMediaType(value); err == nil {
+		v = ps[param]
+	} else if m := regexp.MustCompile(`(?i)(?:^|[;\s])` + param + `\*?=\s*"([^"]*)"`).FindStringSubmatch(value); m != nil {
+		v = m[1]
+	}
+	if decoded, err := decoder.Parse([]byte(v)); err == nil {
+		v = string(decoded)
+	}
+	return v
+}
+
+// bodyType returns "text/plain" or "text/html" if the part can be a body,
+// and "" otherwise.
+func (p Part) bodyType() string {
+	if p.isAttachment() {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(p.Type)
+	if err != nil {
+		mt = strings.ToLower(strings.TrimSpace(strings.SplitN(p.Type, ";", 2)[0]))
+	}
+	if mt == "text/plain" || mt == "text/html" {
+		return mt
+	}
+	return ""
+}
+
+// bodySelection lists the parts making up the plain text and HTML bodies.
+type bodySelection struct {
+	plain, html []int
+}
+
+// selectBodies chooses the bodies of a MIME tree. Of a multipart/alternative,
+// the last alternative providing a plain text body supplies the plain text,
+// and the last one providing an HTML body supplies the HTML. Only the root of
+// a multipart/related and the signed content of a multipart/signed count.
+// The bodies of all other multiparts, such as multipart/mixed, are
+// concatenated.
+func selectBodies(n *mimeNode, parts []Part) (sel bodySelection) {
+	if n.part >= 0 {
+		switch parts[n.part].bodyType() {
+		case "text/plain":
+			sel.plain = []int{n.part}
+		case "text/html":
+			sel.html = []int{n.part}
+		}
+		return
+	}
+	if len(n.children) == 0 {
+		return
+	}
+
+	switch n.mediaType {
+	case "multipart/alternative":
+		for i := len(n.children) - 1; i >= 0; i-- {
+			s := selectBodies(n.children[i], parts)
+			if sel.plain == nil {
+				sel.plain = s.plain
+			}
+			if sel.html == nil {
+				sel.html = s.html
+			}
+		}
+	case "multipart/related":
+		return selectBodies(relatedRoot(n, parts), parts)
+	case "multipart/signed":
+		return selectBodies(n.children[0], parts)
+	default:
+		for _, c := range n.children {
+			s := selectBodies(c, parts)
+			sel.plain = append(sel.plain, s.plain...)
+			sel.html = append(sel.html, s.html...)
+		}
+	}
+	return
+}
+
+// relatedRoot returns the root of a multipart/related: the child named by
+// the start parameter, or the first child.
+func relatedRoot(n *mimeNode, parts []Part) *mimeNode {
+	if start := strings.Trim(n.params["start"], "<> "); start != "" {
+		for _, c := range n.children {
+			if first := firstLeaf(c); first >= 0 {
+				if cid, ok := parts[first].Headers["Content-Id"]; ok && len(cid) > 0 && strings.Trim(cid[0], "<> ") == start {
+					return c
+				}
+			}
+		}
+	}
+	return n.children[0]
+}
+
+func firstLeaf(n *mimeNode) int {
+	if n.part >= 0 || len(n.children) == 0 {
+		return n.part
+	}
+	return firstLeaf(n.children[0])
+}
+
+// bodies returns all body candidates of parts, marking the selected ones.
+func bodies(parts []Part, sel bodySelection) []Body {
+	selected := map[int]bool{}
+	for _, i := range append(append([]int{}, sel.plain...), sel.html...) {
+		selected[i] = true
+	}
+	var bs []Body
+	for i, p := range parts {
+		if mt := p.bodyType(); mt != "" {
+			bs = append(bs, Body{Part: i, MediaType: mt, Text: p.Text(), Selected: selected[i]})
+		}
+	}
+	return bs
+}
+
+// joinBodies concatenates the texts of the given parts, starting each on a
+// new line.
+func joinBodies(parts []Part, indexes []int) string {
+	var b strings.Builder
+	for _, i := range indexes {
+		s := b.String()
+		if len(s) > 0 && !strings.HasSuffix(s, "\n") {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(parts[i].Text())
+	}
+	return b.String()
+}
@@ -0,0 +1,149 @@
+package eml
+
+import (
+	"reflect"
+	"testing"
+)
+
+type bodyTest struct {
+	name  string
+	msg   []byte
+	text  string
+	html  string
+	parts []int
+}
+
+var bodyTests = []bodyTest{
+	{
+		name: "html only",
+		msg: crlf(`Content-Type: text/html
+
+<p>Hi</p>
+`),
+		text:  "Hi",
+		html:  "<p>Hi</p>\r\n",
+		parts: []int{0},
+	},
+	{
+		name: "alternative",
+		msg: crlf(`Content-Type: multipart/alternative; boundary=b
+
+--b
+Content-Type: text/plain
+
+plain
+--b
+Content-Type: text/html
+
+<p>html</p>
+--b
+Content-Type: text/plain
+
+better plain
+--b--
+`),
+		text:  "better plain",
+		html:  "<p>html</p>",
+		parts: []int{1, 2},
+	},
+	{
+		name: "mixed",
+		msg: crlf(`Content-Type: multipart/mixed; boundary=b
+
+--b
+Content-Type: text/plain
+
+first
+--b
+Content-Type: image/png
+
+png
+--b
+Content-Type: text/plain
+
+second
+--b--
+`),
+		text:  "first\r\nsecond",
+		parts: []int{0, 2},
+	},
+	{
+		name: "attachment first",
+		msg: crlf(`Content-Type: multipart/mixed; boundary=b
+
+--b
+Content-Type: text/plain
+Content-Disposition: attachment; filename="notes.txt"
+
+notes
+--b
+Content-Type: multipart/alternative; boundary=c
+
+--c
+Content-Type: text/plain
+
+body
+--c
+Content-Type: text/html
+
+<b>body</b>
+--c--
+--b--
+`),
+		text:  "body",
+		html:  "<b>body</b>",
+		parts: []int{1, 2},
+	},
+	{
+		name: "related",
+		msg: crlf(`Content-Type: multipart/related; boundary=b; start="<root@x>"
+
+--b
+Content-Type: text/html
+Content-ID: <other@x>
+
+<p>other</p>
+--b
+Content-Type: text/html
+Content-ID: <root@x>
+
+<p>root</p>
+--b--
+`),
+		text:  "root",
+		html:  "<p>root</p>",
+		parts: []int{1},
+	},
+}
+
+func TestBodySelection(t *testing.T) {
+	for _, bt := range bodyTests {
+		m, err := Parse(bt.msg)
+		if err != nil {
+			t.Errorf("%s: Parse returned error: %s", bt.name, err)
+			continue
+		}
+		if m.Text != bt.text || m.Html != bt.html {
+			t.Errorf("%s: got text %q, html %q; expected %q, %q", bt.name, m.Text, m.Html, bt.text, bt.html)
+		}
+		var selected []int
+		for _, b := range m.Bodies {
+			if b.Selected {
+				selected = append(selected, b.Part)
+			}
+		}
+		if !reflect.DeepEqual(selected, bt.parts) {
+			t.Errorf("%s: selected parts %v; expected %v", bt.name, selected, bt.parts)
+		}
+	}
+}
+
+func TestTextAttachment(t *testing.T) {
+	m, err := Parse(bodyTests[3].msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].Filename != "notes.txt" || string(m.Attachments[0].Data) != "notes" {
+		t.Errorf("unexpected attachments %#v", m.Attachments)
+	}
+}
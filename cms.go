@@ -0,0 +1,538 @@
+// Parsing of the Cryptographic Message Syntax (RFC 5652) as used by S/MIME.
+
+package eml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	// Hashes referenced by CMS digest algorithms.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidRSAESOAEP = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidRSASSAPSS = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// cmsDigests maps the digest algorithms CMS signatures may use to hashes.
+// MD5 is deliberately missing.
+var cmsDigests = map[string]crypto.Hash{
+	"1.3.14.3.2.26":          crypto.SHA1,
+	"2.16.840.1.101.3.4.2.4": crypto.SHA224,
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+// The explicitly tagged fields of CMS are read as the RawValue of the tag,
+// whose Bytes are the encoding of the tagged value.
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type cmsIssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type cmsEnvelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo cmsEncryptedContentInfo
+	UnprotectedAttrs     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type cmsKeyTransRecipientInfo struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type cmsOAEPParams struct {
+	Hash pkix.AlgorithmIdentifier `asn1:"explicit,optional,tag:0"`
+}
+
+// parseContentInfo parses a BER or DER encoded ContentInfo.
+func parseContentInfo(b []byte) (cmsContentInfo, error) {
+	var ci cmsContentInfo
+	der, err := berToDER(b)
+	if err != nil {
+		return ci, err
+	}
+	rest, err := asn1.Unmarshal(der, &ci)
+	if err == nil && len(rest) > 0 {
+		err = errors.New("trailing data")
+	}
+	if err != nil {
+		return ci, fmt.Errorf("cms: %v", err)
+	}
+	return ci, nil
+}
+
+// octets returns the content of the OCTET STRING v, which may be
+// implicitly tagged and split into segments as BER allows, or of the OCTET
+// STRING held by the explicit tag v.
+func octets(v asn1.RawValue) ([]byte, error) {
+	if !v.IsCompound {
+		return v.Bytes, nil
+	}
+	var out []byte
+	for rest := v.Bytes; len(rest) > 0; {
+		var seg asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &seg); err != nil {
+			return nil, err
+		}
+		out = append(out, seg.Bytes...)
+	}
+	return out, nil
+}
+
+// cmsSignerResult is the outcome of checking one SignerInfo.
+type cmsSignerResult struct {
+	signer      *x509.Certificate
+	signingTime time.Time
+	err         error
+}
+
+// verifySignedData checks the signatures of a SignedData against content,
+// which is the encapsulated content unless the signature is detached. It
+// returns the encapsulated content, the certificates included in the
+// SignedData and one result per signer.
+func verifySignedData(content asn1.RawValue, detached []byte) ([]byte, []*x509.Certificate, []cmsSignerResult, error) {
+	var sd cmsSignedData
+	if _, err := asn1.Unmarshal(content.Bytes, &sd); err != nil {
+		return nil, nil, nil, fmt.Errorf("cms: signed data: %v", err)
+	}
+	data := detached
+	if data == nil {
+		if len(sd.EncapContentInfo.EContent.FullBytes) == 0 {
+			return nil, nil, nil, errors.New("cms: signed data without content")
+		}
+		var err error
+		if data, err = octets(sd.EncapContentInfo.EContent); err != nil {
+			return nil, nil, nil, fmt.Errorf("cms: content: %v", err)
+		}
+	}
+	var certs []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		// Other certificate formats than X.509 are skipped.
+		for rest := sd.Certificates.Bytes; len(rest) > 0; {
+			var raw asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &raw); err != nil {
+				return nil, nil, nil, fmt.Errorf("cms: certificates: %v", err)
+			}
+			if raw.Class != asn1.ClassUniversal {
+				continue
+			}
+			cert, err := x509.ParseCertificate(raw.FullBytes)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("cms: certificates: %v", err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, nil, nil, errors.New("cms: no signers")
+	}
+
+	var results []cmsSignerResult
+	for _, si := range sd.SignerInfos {
+		var res cmsSignerResult
+		res.signer = findCertificate(certs, si.SID)
+		if res.signer == nil {
+			res.err = errors.New("cms: signer certificate not included")
+		} else {
+			res.signingTime, res.err = checkSignerInfo(si, sd.EncapContentInfo.EContentType, data, res.signer)
+		}
+		results = append(results, res)
+	}
+	return data, certs, results, nil
+}
+
+// findCertificate returns the certificate identified by a SignerIdentifier
+// or RecipientIdentifier: an IssuerAndSerialNumber or a
+// [0] SubjectKeyIdentifier.
+func findCertificate(certs []*x509.Certificate, id asn1.RawValue) *x509.Certificate {
+	for _, c := range certs {
+		if matchesIdentifier(c, id) {
+			return c
+		}
+	}
+	return nil
+}
+
+func matchesIdentifier(c *x509.Certificate, id asn1.RawValue) bool {
+	if id.Class == asn1.ClassContextSpecific && id.Tag == 0 {
+		return len(c.SubjectKeyId) > 0 && bytes.Equal(c.SubjectKeyId, id.Bytes)
+	}
+	var ias cmsIssuerAndSerial
+	if _, err := asn1.Unmarshal(id.FullBytes, &ias); err != nil || ias.Serial == nil {
+		return false
+	}
+	return bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) && c.SerialNumber.Cmp(ias.Serial) == 0
+}
+
+// checkSignerInfo verifies the signature of one signer over data, through
+// the signed attributes if there are any. It returns the signing time
+// attribute, if present.
+func checkSignerInfo(si cmsSignerInfo, contentType asn1.ObjectIdentifier, data []byte, cert *x509.Certificate) (time.Time, error) {
+	var signingTime time.Time
+	hash, ok := cmsDigests[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return signingTime, fmt.Errorf("cms: unsupported digest algorithm %s", si.DigestAlgorithm.Algorithm)
+	}
+	if !hash.Available() {
+		return signingTime, fmt.Errorf("cms: digest algorithm %s not available", si.DigestAlgorithm.Algorithm)
+	}
+	h := hash.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	signed := data
+	if len(si.SignedAttrs.FullBytes) > 0 {
+		var gotDigest, gotType bool
+		for rest := si.SignedAttrs.Bytes; len(rest) > 0; {
+			var attr cmsAttribute
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+				return signingTime, fmt.Errorf("cms: signed attributes: %v", err)
+			}
+			switch {
+			case attr.Type.Equal(oidAttrMessageDigest):
+				var md []byte
+				if _, err := asn1.Unmarshal(attr.Values.Bytes, &md); err != nil {
+					return signingTime, fmt.Errorf("cms: message digest: %v", err)
+				}
+				if !bytes.Equal(md, digest) {
+					return signingTime, errors.New("cms: message digest mismatch")
+				}
+				gotDigest = true
+			case attr.Type.Equal(oidAttrContentType):
+				var ct asn1.ObjectIdentifier
+				if _, err := asn1.Unmarshal(attr.Values.Bytes, &ct); err != nil {
+					return signingTime, fmt.Errorf("cms: content type: %v", err)
+				}
+				if !ct.Equal(contentType) {
+					return signingTime, errors.New("cms: content type mismatch")
+				}
+				gotType = true
+			case attr.Type.Equal(oidAttrSigningTime):
+				// A malformed signing time is ignored, as it is not
+				// needed for verification.
+				asn1.Unmarshal(attr.Values.Bytes, &signingTime)
+			}
+		}
+		if !gotDigest || !gotType {
+			return signingTime, errors.New("cms: signed attributes lack message digest or content type")
+		}
+		// The signature covers the attributes with their SET OF tag.
+		signed = append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+		h = hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	var err error
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if si.SignatureAlgorithm.Algorithm.Equal(oidRSASSAPSS) {
+			err = rsa.VerifyPSS(pub, hash, digest, si.Signature, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, si.Signature)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, si.Signature) {
+			err = errors.New("invalid signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, signed, si.Signature) {
+			err = errors.New("invalid signature")
+		}
+	default:
+		err = fmt.Errorf("unsupported key type %T", pub)
+	}
+	if err != nil {
+		return signingTime, fmt.Errorf("cms: %v", err)
+	}
+	return signingTime, nil
+}
+
+// decryptEnvelopedData decrypts an EnvelopedData for the recipient with the
+// given certificate and key. Without certificate, every recipient using key
+// transport is tried.
+func decryptEnvelopedData(content asn1.RawValue, cert *x509.Certificate, key crypto.Decrypter) ([]byte, error) {
+	var ed cmsEnvelopedData
+	if _, err := asn1.Unmarshal(content.Bytes, &ed); err != nil {
+		return nil, fmt.Errorf("cms: enveloped data: %v", err)
+	}
+	var cek []byte
+	var err error
+	found := false
+	for _, ri := range ed.RecipientInfos {
+		// Only key transport (RFC 5652 section 6.2.1) is supported; the
+		// other recipient types are tagged.
+		if ri.Class != asn1.ClassUniversal {
+			continue
+		}
+		var ktri cmsKeyTransRecipientInfo
+		if _, err := asn1.Unmarshal(ri.FullBytes, &ktri); err != nil {
+			continue
+		}
+		if cert != nil && !matchesIdentifier(cert, ktri.RID) {
+			continue
+		}
+		found = true
+		if cek, err = decryptKey(ktri, key); err == nil {
+			break
+		}
+	}
+	if !found {
+		return nil, errors.New("cms: not encrypted for this recipient")
+	}
+	if cek == nil {
+		return nil, fmt.Errorf("cms: decrypting content key: %v", err)
+	}
+
+	eci := ed.EncryptedContentInfo
+	ciphertext, err := octets(eci.EncryptedContent)
+	if err != nil {
+		return nil, fmt.Errorf("cms: encrypted content: %v", err)
+	}
+	var block cipher.Block
+	switch alg := eci.ContentEncryptionAlgorithm.Algorithm; {
+	case alg.Equal(oidAES128CBC), alg.Equal(oidAES192CBC), alg.Equal(oidAES256CBC):
+		block, err = aes.NewCipher(cek)
+	case alg.Equal(oidDESEDE3CBC):
+		block, err = des.NewTripleDESCipher(cek)
+	default:
+		return nil, fmt.Errorf("cms: unsupported content encryption algorithm %s", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cms: %v", err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil || len(iv) != block.BlockSize() {
+		return nil, errors.New("cms: invalid initialization vector")
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, errors.New("cms: invalid ciphertext length")
+	}
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, ciphertext)
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("cms: invalid padding")
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+func decryptKey(ktri cmsKeyTransRecipientInfo, key crypto.Decrypter) ([]byte, error) {
+	var opts crypto.DecrypterOpts
+	if ktri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAESOAEP) {
+		hash := crypto.SHA1
+		var params cmsOAEPParams
+		if len(ktri.KeyEncryptionAlgorithm.Parameters.FullBytes) > 0 {
+			if _, err := asn1.Unmarshal(ktri.KeyEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+				return nil, err
+			}
+			if params.Hash.Algorithm != nil {
+				var ok bool
+				if hash, ok = cmsDigests[params.Hash.Algorithm.String()]; !ok {
+					return nil, fmt.Errorf("unsupported OAEP hash %s", params.Hash.Algorithm)
+				}
+			}
+		}
+		opts = &rsa.OAEPOptions{Hash: hash}
+	}
+	return key.Decrypt(rand.Reader, ktri.EncryptedKey, opts)
+}
+
+// maxBERDepth bounds the nesting of BER values.
+const maxBERDepth = 64
+
+// berToDER converts BER to the DER that encoding/asn1 accepts, as far as
+// CMS producers need it: indefinite lengths are resolved and constructed
+// OCTET STRINGs are joined. Other DER rules, such as the order of SET
+// members, are not enforced.
+func berToDER(b []byte) ([]byte, error) {
+	tag, content, rest, err := berValue(b, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cms: %v", err)
+	}
+	if len(bytes.Trim(rest, "\x00")) > 0 {
+		return nil, errors.New("cms: trailing data")
+	}
+	return derEncode(tag, content), nil
+}
+
+// berValue parses one BER value and returns its tag, its content in DER and
+// the data following it.
+func berValue(b []byte, depth int) (tag, content, rest []byte, err error) {
+	if depth > maxBERDepth {
+		return nil, nil, nil, errors.New("nesting too deep")
+	}
+	if len(b) < 2 {
+		return nil, nil, nil, errors.New("truncated value")
+	}
+	n := 1
+	if b[0]&0x1f == 0x1f {
+		for n < len(b) && b[n]&0x80 != 0 {
+			n++
+		}
+		n++
+	}
+	if n >= len(b) {
+		return nil, nil, nil, errors.New("truncated tag")
+	}
+	tag, b = b[:n], b[n:]
+	constructed := tag[0]&0x20 != 0
+
+	indefinite := false
+	length := 0
+	switch l := b[0]; {
+	case l < 0x80:
+		length, b = int(l), b[1:]
+	case l == 0x80:
+		if !constructed {
+			return nil, nil, nil, errors.New("indefinite length of primitive value")
+		}
+		indefinite, b = true, b[1:]
+	default:
+		k := int(l & 0x7f)
+		if k > 4 || len(b) < 1+k {
+			return nil, nil, nil, errors.New("invalid length")
+		}
+		for _, c := range b[1 : 1+k] {
+			length = length<<8 | int(c)
+		}
+		b = b[1+k:]
+	}
+	if !indefinite && (length < 0 || length > len(b)) {
+		return nil, nil, nil, errors.New("truncated value")
+	}
+	if !constructed {
+		return tag, b[:length], b[length:], nil
+	}
+
+	// A constructed OCTET STRING becomes a primitive one.
+	join := len(tag) == 1 && tag[0] == 0x24
+	if join {
+		tag = []byte{0x04}
+	}
+	inner := b
+	if !indefinite {
+		inner, rest = b[:length], b[length:]
+	}
+	var out []byte
+	for {
+		if indefinite {
+			if len(inner) < 2 {
+				return nil, nil, nil, errors.New("missing end of contents")
+			}
+			if inner[0] == 0 && inner[1] == 0 {
+				rest = inner[2:]
+				break
+			}
+		} else if len(inner) == 0 {
+			break
+		}
+		ctag, ccontent, crest, err := berValue(inner, depth+1)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if join {
+			out = append(out, ccontent...)
+		} else {
+			out = append(out, derEncode(ctag, ccontent)...)
+		}
+		inner = crest
+	}
+	return tag, out, rest, nil
+}
+
+func derEncode(tag, content []byte) []byte {
+	out := append([]byte{}, tag...)
+	switch n := len(content); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	case n < 0x10000:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	case n < 0x1000000:
+		out = append(out, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
@@ -0,0 +1,98 @@
+package decoder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// ErrUnknownCharset is returned (wrapped) when a charset label cannot be
+// resolved to an encoding.
+var ErrUnknownCharset = errors.New("unknown charset")
+
+// CharsetRegistry resolves charset labels, as found in Content-Type
+// parameters and encoded-words, to encodings.
+type CharsetRegistry interface {
+	Lookup(label string) (encoding.Encoding, error)
+}
+
+// Registry is the built-in CharsetRegistry. It knows every encoding label and
+// alias of the WHATWG Encoding Standard, falls back to the IANA charset
+// registry, and lets callers register additional encodings or override
+// built-in ones. The zero value is ready to use, like NewRegistry().
+type Registry struct {
+	mu        sync.RWMutex
+	encodings map[string]encoding.Encoding
+}
+
+// NewRegistry returns a Registry containing only the built-in encodings.
+func NewRegistry() *Registry {
+	return &Registry{encodings: map[string]encoding.Encoding{}}
+}
+
+// Charsets is the registry used by UTF8, and therefore by both body and
+// header decoding. It may be replaced by a custom CharsetRegistry.
+var Charsets CharsetRegistry = NewRegistry()
+
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Trim(label, " \t\r\n\"'"))
+}
+
+// Register makes enc available under label. Labels are case-insensitive.
+func (r *Registry) Register(label string, enc encoding.Encoding) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.encodings == nil {
+		r.encodings = map[string]encoding.Encoding{}
+	}
+	r.encodings[normalizeLabel(label)] = enc
+}
+
+// Lookup returns the encoding for label.
+func (r *Registry) Lookup(label string) (encoding.Encoding, error) {
+	name := normalizeLabel(label)
+	r.mu.RLock()
+	enc, ok := r.encodings[name]
+	r.mu.RUnlock()
+	if ok {
+		return enc, nil
+	}
+
+	if enc, err := htmlindex.Get(name); err == nil {
+		return enc, nil
+	}
+	if enc, err := ianaindex.IANA.Encoding(name); err == nil && enc != nil {
+		return enc, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownCharset, label)
+}
+
+// Register adds enc to the default registry, if it is a *Registry.
+func Register(label string, enc encoding.Encoding) {
+	if r, ok := Charsets.(*Registry); ok {
+		r.Register(label, enc)
+	}
+}
+
+// UTF8 converts data from the charset cs to UTF-8 using Charsets. Data
+// declared as UTF-8 is returned unchanged.
+func UTF8(cs string, data []byte) ([]byte, error) {
+	return UTF8With(Charsets, cs, data)
+}
+
+// UTF8With converts data from the charset cs to UTF-8 using reg.
+func UTF8With(reg CharsetRegistry, cs string, data []byte) ([]byte, error) {
+	enc, err := reg.Lookup(cs)
+	if err != nil {
+		return []byte{}, err
+	}
+	if name, _ := htmlindex.Name(enc); name == "utf-8" {
+		return data, nil
+	}
+	return enc.NewDecoder().Bytes(data)
+}
@@ -0,0 +1,62 @@
+package decoder
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+type charsetTest struct {
+	charset  string
+	input    []byte
+	expected string
+}
+
+var charsetTests = []charsetTest{
+	{"UTF-8", []byte("grün"), "grün"},
+	{"us-ascii", []byte("plain"), "plain"},
+	{"ISO-8859-1", []byte{'g', 'r', 0xfc, 'n'}, "grün"},
+	{"cp1252", []byte{0x80, ' ', 0x93, 'x', 0x94}, "€ “x”"},
+	{"\"Windows-1252\"", []byte{0x80}, "€"},
+	{"ks_c_5601-1987", []byte{0xc7, 0xd1}, "한"},
+	{"gb2312", []byte{0xd6, 0xd0}, "中"},
+	{"x-mac-roman", []byte{0x8a}, "ä"},
+	{"Shift_JIS", []byte{0x82, 0xa0}, "あ"},
+	{"IBM437", []byte{0x81}, "ü"},
+}
+
+func TestUTF8(t *testing.T) {
+	for _, ct := range charsetTests {
+		actual, err := UTF8(ct.charset, ct.input)
+		if err != nil {
+			t.Errorf("UTF8(%q) returned error: %s", ct.charset, err)
+		} else if string(actual) != ct.expected {
+			t.Errorf("UTF8(%q) gave %q; expected %q", ct.charset, actual, ct.expected)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if _, err := UTF8With(r, "x-unknown", []byte("a")); !errors.Is(err, ErrUnknownCharset) {
+		t.Errorf("expected ErrUnknownCharset, got %v", err)
+	}
+
+	r.Register("X-Unknown", charmap.ISO8859_15)
+	actual, err := UTF8With(r, "x-unknown", []byte{0xa4})
+	if err != nil || string(actual) != "€" {
+		t.Errorf("registered charset gave %q, %v", actual, err)
+	}
+}
+
+func TestRegistryZeroValue(t *testing.T) {
+	var r Registry
+	if enc, err := r.Lookup("latin1"); err != nil || enc == nil {
+		t.Errorf("built-in lookup on zero Registry gave %v, %v", enc, err)
+	}
+	r.Register("x-unknown", charmap.ISO8859_15)
+	if enc, err := r.Lookup("X-Unknown"); err != nil || enc != charmap.ISO8859_15 {
+		t.Errorf("registered lookup on zero Registry gave %v, %v", enc, err)
+	}
+}
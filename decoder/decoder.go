@@ -1,130 +1,290 @@
 package decoder
 
 import (
-	"bytes"
+	"encoding/base64"
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"strings"
+	"unicode/utf8"
+)
 
-	"encoding/base64"
-	"mime/quotedprintable"
+// Word is a single RFC 2047 encoded-word, such as "=?UTF-8?Q?caf=C3=A9?=".
+type Word struct {
+	Charset string
+	// Language is the RFC 2231 language suffix of the charset, if any, as
+	// in "=?UTF-8*en?Q?...?=".
+	Language string
+	// Encoding is 'B' or 'Q'.
+	Encoding byte
+	// Text is the encoded text between the last two '?'.
+	Text string
+}
 
-	"github.com/paulrosania/go-charset/charset"
-)
+// WordError is returned when an encoded-word could not be decoded.
+type WordError struct {
+	Word string
+	Err  error
+}
+
+func (e *WordError) Error() string {
+	return fmt.Sprintf("invalid encoded-word %q: %s", e.Word, e.Err)
+}
 
-func UTF8(cs string, data []byte) ([]byte, error) {
-	if strings.ToUpper(cs) == "UTF-8" {
-		return data, nil
+func (e *WordError) Unwrap() error {
+	return e.Err
+}
+
+var errWordSyntax = errors.New("invalid encoding format")
+
+// ParseWord parses a complete encoded-word.
+func ParseWord(s string) (Word, error) {
+	w, n := scanWord(s)
+	if n != len(s) {
+		return Word{}, &WordError{s, errWordSyntax}
 	}
+	return w, nil
+}
 
-	r, err := charset.NewReader(cs, bytes.NewReader(data))
-	if err != nil {
-		return []byte{}, err
+// scanWord parses the encoded-word at the start of s and returns it along
+// with its length, or a length of 0 if s does not start with one.
+func scanWord(s string) (Word, int) {
+	if !strings.HasPrefix(s, "=?") {
+		return Word{}, 0
+	}
+	rest := s[2:]
+	q := strings.IndexByte(rest, '?')
+	if q <= 0 || len(rest) < q+3 || rest[q+2] != '?' {
+		return Word{}, 0
+	}
+	charset, enc := rest[:q], rest[q+1]
+	if strings.ContainsAny(charset, " \t\r\n") {
+		return Word{}, 0
+	}
+	text := rest[q+3:]
+	end := strings.Index(text, "?=")
+	if end < 0 || strings.ContainsAny(text[:end], " \t\r\n") {
+		return Word{}, 0
 	}
 
-	return ioutil.ReadAll(r)
+	w := Word{Charset: charset, Encoding: enc, Text: text[:end]}
+	if i := strings.IndexByte(charset, '*'); i >= 0 {
+		w.Charset, w.Language = charset[:i], charset[i+1:]
+	}
+	switch w.Encoding {
+	case 'b', 'B':
+		w.Encoding = 'B'
+	case 'q', 'Q':
+		w.Encoding = 'Q'
+	default:
+		return Word{}, 0
+	}
+	return w, 2 + q + 3 + end + 2
+}
 
+func (w Word) String() string {
+	charset := w.Charset
+	if w.Language != "" {
+		charset += "*" + w.Language
+	}
+	return "=?" + charset + "?" + string(w.Encoding) + "?" + w.Text + "?="
 }
 
-func Parse(bstr []byte) ([]byte, error) {
-	result, err := forEncodedParts(string(bstr), func(encodingName, encodingType, encodingContent string) (string, error) {
-		res, err := Decode(encodingName, encodingType, encodingContent)
-		return string(res), err
-	})
-	return []byte(result), err
+// Bytes undoes the B or Q encoding of the word without converting its
+// charset. Missing base64 padding is tolerated, as are malformed "=XX"
+// escapes in Q text, which are kept literally.
+func (w Word) Bytes() ([]byte, error) {
+	switch w.Encoding {
+	case 'B':
+		b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(w.Text, "="))
+		if err != nil {
+			return nil, &WordError{w.String(), err}
+		}
+		return b, nil
+	case 'Q':
+		return decodeQ(w.Text), nil
+	}
+	return nil, &WordError{w.String(), errors.New("missing encoding type")}
+}
 
+// Decode returns the text of the word converted to UTF-8.
+func (w Word) Decode() (string, error) {
+	b, err := w.Bytes()
+	if err != nil {
+		return "", err
+	}
+	return convert(w.Charset, b)
 }
 
-type encodingState int
+// convert converts b from charset to UTF-8. When the charset is unknown but
+// the bytes happen to be valid UTF-8, they are used as they are.
+func convert(charset string, b []byte) (string, error) {
+	res, err := UTF8(charset, b)
+	if err != nil {
+		if utf8.Valid(b) {
+			return string(b), nil
+		}
+		return "", err
+	}
+	return string(res), nil
+}
 
-const (
-	stateNone = encodingState(iota)
-	stateBegin
-	stateName
-	stateType
-	stateContent
-	stateEnd
-)
+func unhex(b byte) (byte, bool) {
+	switch {
+	case b >= '0' && b <= '9':
+		return b - '0', true
+	case b >= 'a' && b <= 'f':
+		return b - 'a' + 10, true
+	case b >= 'A' && b <= 'F':
+		return b - 'A' + 10, true
+	}
+	return 0, false
+}
 
-func forEncodedParts(input string, cb func(encodingName, encodingType, encodingContent string) (string, error)) (string, error) {
-	state := stateNone
-	result := strings.Builder{}
-	tmp := []rune{}
-	var name = ""
-	var typeString = ""
-	var content = ""
-	for i, c := range input {
-		switch state {
-		case stateNone:
-			if c == '=' {
-				state = stateBegin
-			} else {
-				result.WriteRune(c)
-			}
-		case stateBegin:
-			if c == '?' {
-				state = stateName
-			} else {
-				result.WriteRune('=')
-				result.WriteRune(c)
-				state = stateNone
-			}
-		case stateName:
-			if c == '?' {
-				name = string(tmp)
-				tmp = tmp[:0]
-				state = stateType
-			} else {
-				tmp = append(tmp, c)
-			}
-		case stateType:
-			if c == '?' {
-				typeString = string(tmp)
-				tmp = tmp[:0]
-				state = stateContent
-			} else {
-				tmp = append(tmp, c)
-			}
-		case stateContent:
-			if c == '?' && input[i+1] == '=' {
-				content = string(tmp)
-				tmp = tmp[:0]
-				state = stateEnd
-			} else {
-				tmp = append(tmp, c)
+// decodeQ decodes the Q encoding of RFC 2047 section 4.2, which differs from
+// quoted-printable in that '_' stands for a space.
+func decodeQ(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '_':
+			b = append(b, ' ')
+		case '=':
+			if i+2 < len(s) {
+				h, ok1 := unhex(s[i+1])
+				l, ok2 := unhex(s[i+2])
+				if ok1 && ok2 {
+					b = append(b, h<<4|l)
+					i += 2
+					continue
+				}
 			}
-		case stateEnd:
-			part, err := cb(name, typeString, content)
-			if err != nil {
-				return "", err
+			b = append(b, c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// Segment is a piece of a header value: either literal text or an
+// encoded-word.
+type Segment struct {
+	// Raw is the text of the segment as it appears in the header.
+	Raw string
+	// Word is the parsed encoded-word, or nil for literal text.
+	Word *Word
+}
+
+// Split breaks a header value into literal text and encoded-words. Text
+// that merely looks like the start of an encoded-word is literal.
+func Split(s string) []Segment {
+	var segs []Segment
+	lit := 0
+	for i := 0; i < len(s); {
+		if s[i] != '=' || i+1 >= len(s) || s[i+1] != '?' {
+			i++
+			continue
+		}
+		w, n := scanWord(s[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		if lit < i {
+			segs = append(segs, Segment{Raw: s[lit:i]})
+		}
+		segs = append(segs, Segment{Raw: s[i : i+n], Word: &w})
+		i += n
+		lit = i
+	}
+	if lit < len(s) {
+		segs = append(segs, Segment{Raw: s[lit:]})
+	}
+	return segs
+}
+
+func isLinearWhitespace(s string) bool {
+	return strings.Trim(s, " \t\r\n") == ""
+}
+
+// DecodeHeader decodes all encoded-words in a header value. White space
+// between adjacent encoded-words is dropped, and adjacent words in the same
+// charset are converted together, so that a multibyte character split
+// across words is reassembled. Words that cannot be decoded are kept as they
+// are; the first such failure is returned along with the best-effort result.
+func DecodeHeader(s string) (string, error) {
+	segs := Split(s)
+	var (
+		result   strings.Builder
+		firstErr error
+		pending  []byte
+		charset  string
+		raw      strings.Builder
+	)
+	flush := func() {
+		if raw.Len() == 0 {
+			return
+		}
+		text, err := convert(charset, pending)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = &WordError{raw.String(), err}
 			}
-			result.WriteString(part)
-			state = stateNone
+			text = raw.String()
 		}
+		result.WriteString(text)
+		pending, charset = nil, ""
+		raw.Reset()
 	}
 
-	if state != stateNone {
-		return result.String(), errors.New("invalid encoding format")
+	for i, seg := range segs {
+		if seg.Word == nil {
+			// Linear white space between two encoded-words is ignored.
+			if i > 0 && i+1 < len(segs) && segs[i-1].Word != nil && segs[i+1].Word != nil && isLinearWhitespace(seg.Raw) {
+				continue
+			}
+			flush()
+			result.WriteString(seg.Raw)
+			continue
+		}
+		b, err := seg.Word.Bytes()
+		if err != nil {
+			flush()
+			if firstErr == nil {
+				firstErr = err
+			}
+			result.WriteString(seg.Raw)
+			continue
+		}
+		if raw.Len() > 0 && !strings.EqualFold(charset, seg.Word.Charset) {
+			flush()
+		}
+		charset = seg.Word.Charset
+		pending = append(pending, b...)
+		raw.WriteString(seg.Raw)
 	}
+	flush()
+	return result.String(), firstErr
+}
 
-	return result.String(), nil
+// Parse decodes all encoded-words in a header value, see DecodeHeader.
+func Parse(bstr []byte) ([]byte, error) {
+	result, err := DecodeHeader(string(bstr))
+	return []byte(result), err
 }
 
+// Decode decodes the parts of a single encoded-word to UTF-8.
 func Decode(encodingName, encodingType, encodingContent string) ([]byte, error) {
-	var err error
-	var decoded []byte
-	switch strings.ToUpper(encodingType) {
-	case "Q":
-		decoded, err = ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader([]byte(encodingContent))))
-	case "B":
-		decoded, err = base64.StdEncoding.DecodeString(encodingContent)
-	default:
+	if len(encodingType) != 1 {
 		return nil, errors.New("missing encoding type")
 	}
-
+	w := Word{Charset: encodingName, Encoding: strings.ToUpper(encodingType)[0], Text: encodingContent}
+	if i := strings.IndexByte(w.Charset, '*'); i >= 0 {
+		w.Charset, w.Language = w.Charset[:i], w.Charset[i+1:]
+	}
+	s, err := w.Decode()
 	if err != nil {
 		return nil, err
 	}
-
-	return UTF8(encodingName, decoded)
+	return []byte(s), nil
 }
@@ -15,9 +15,21 @@ var testCases = []testCase{
 	{"", "", nil},
 	{"text without encoding.", "text without encoding.", nil},
 	{"=text with = equals", "=text with = equals", nil},
-	{"=?text with invalid content", "", errors.New("invalid encoding format")},
-	{"text with invalid=?content", "text with invalid", errors.New("invalid encoding format")},
-	{"=?UTF-8?Q?german_=C3=BC_=26_=26_=2E?=", "german_ü_&_&_.", nil},
+	{"=?text with invalid content", "=?text with invalid content", nil},
+	{"text with invalid=?content", "text with invalid=?content", nil},
+	{"trailing ?", "trailing ?", nil},
+	{"=?UTF-8?Q?trailing?", "=?UTF-8?Q?trailing?", nil},
+	{"=?UTF-8?Q?german_=C3=BC_=26_=26_=2E?=", "german ü & & .", nil},
+	{"=?UTF-8?q?lower=c3=bc?=", "lowerü", nil},
+	{"=?ISO-8859-1?Q?a?= =?ISO-8859-1?Q?b?=", "ab", nil},
+	{"=?ISO-8859-1?Q?a?=\r\n\t=?UTF-8?Q?b?= c =?UTF-8?Q?d?=", "ab c d", nil},
+	{"=?UTF-8?B?w6Q?=", "ä", nil},
+	{"=?UTF-8*en?Q?Hello_world?=", "Hello world", nil},
+	{"=?UTF-8?Q?=E2=82?= =?UTF-8?Q?=AC?=", "€", nil},
+	{"=?Shift_JIS?B?gqA=?= =?Shift_JIS?B?gqI=?=", "あい", nil},
+	{"=?UTF-8?B?!!!?= ok =?UTF-8?Q?fine?=", "=?UTF-8?B?!!!?= ok fine", errors.New(`invalid encoded-word "=?UTF-8?B?!!!?=": illegal base64 data at input byte 0`)},
+	{"=?x-unknown?Q?=FF?= ok", "=?x-unknown?Q?=FF?= ok", errors.New(`invalid encoded-word "=?x-unknown?Q?=FF?=": unknown charset "x-unknown"`)},
+	{"=?x-unknown?Q?plain?=", "plain", nil},
 }
 
 func stringOrNullString(e error) string {
@@ -41,3 +53,35 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+type parseWordTest struct {
+	word     string
+	expected Word
+	decoded  string
+}
+
+var parseWordTests = []parseWordTest{
+	{"=?UTF-8?Q?caf=C3=A9?=", Word{"UTF-8", "", 'Q', "caf=C3=A9"}, "café"},
+	{"=?iso-8859-1*de?b?R3L832U=?=", Word{"iso-8859-1", "de", 'B', "R3L832U="}, "Grüße"},
+}
+
+func TestParseWord(t *testing.T) {
+	for _, pt := range parseWordTests {
+		w, err := ParseWord(pt.word)
+		if err != nil {
+			t.Errorf("ParseWord(%q) returned error: %s", pt.word, err)
+			continue
+		}
+		if w != pt.expected {
+			t.Errorf("ParseWord(%q) gave %#v; expected %#v", pt.word, w, pt.expected)
+		}
+		if d, err := w.Decode(); err != nil || d != pt.decoded {
+			t.Errorf("Decode(%q) gave %q, %v; expected %q", pt.word, d, err, pt.decoded)
+		}
+	}
+	for _, bad := range []string{"=?UTF-8?Q?a?= ", "=?UTF-8?X?a?=", "=??Q?a?=", "=?UTF-8?Q?a b?="} {
+		if _, err := ParseWord(bad); err == nil {
+			t.Errorf("ParseWord(%q) did not return an error", bad)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package decoder
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CharsetSource records where the charset used to decode a text came from.
+type CharsetSource int
+
+const (
+	// SourceDeclared means the declared charset was used as is.
+	SourceDeclared CharsetSource = iota
+	// SourceDefault means no charset was declared or detected and the
+	// default was used.
+	SourceDefault
+	// SourceBOM means the charset was taken from a byte order mark.
+	SourceBOM
+	// SourceMeta means the charset was taken from an HTML <meta> element.
+	SourceMeta
+	// SourceDetected means the charset was guessed from the content.
+	SourceDetected
+)
+
+func (s CharsetSource) String() string {
+	switch s {
+	case SourceDeclared:
+		return "declared"
+	case SourceDefault:
+		return "default"
+	case SourceBOM:
+		return "bom"
+	case SourceMeta:
+		return "meta"
+	case SourceDetected:
+		return "detected"
+	}
+	return "unknown"
+}
+
+// CharsetDetection is the result of DetectCharset.
+type CharsetDetection struct {
+	// Charset is the charset the data should be decoded with.
+	Charset string
+	// Declared is the charset found in the Content-Type header, if any.
+	Declared string
+	// Source says how Charset was chosen.
+	Source CharsetSource
+	// BOMLength is the length of the byte order mark at the start of the
+	// data, which must be skipped before decoding.
+	BOMLength int
+}
+
+// DetectionCandidates lists the legacy charsets considered by the
+// statistical detector, in order of preference for equal scores.
+var DetectionCandidates = []string{
+	"windows-1252",
+	"iso-8859-2",
+	"windows-1251",
+	"koi8-r",
+	"iso-8859-7",
+	"shift_jis",
+	"euc-jp",
+	"euc-kr",
+	"gbk",
+	"big5",
+}
+
+// DefaultCharset is used when nothing is declared and the data is ASCII.
+const DefaultCharset = "us-ascii"
+
+var boms = []struct {
+	bom     []byte
+	charset string
+}{
+	{[]byte{0xef, 0xbb, 0xbf}, "utf-8"},
+	{[]byte{0xff, 0xfe}, "utf-16le"},
+	{[]byte{0xfe, 0xff}, "utf-16be"},
+}
+
+var metaCharsetR = regexp.MustCompile(`(?is)<meta\s[^>]*?charset\s*=\s*["']?\s*([a-z0-9_:.+-]+)`)
+
+// metaCharset returns the charset declared by an HTML <meta> element within
+// the first kilobyte of data, as the HTML prescan algorithm does.
+func metaCharset(data []byte) string {
+	if len(data) > 1024 {
+		data = data[:1024]
+	}
+	if m := metaCharsetR.FindSubmatch(data); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// DetectCharset decides which charset text data should be decoded with,
+// given the charset declared for it (empty if none) and its media type.
+//
+// A byte order mark always wins. For HTML, a <meta> charset fills in a
+// missing declaration. A declaration is then kept if the data is consistent
+// with it; otherwise, or if nothing is declared, valid UTF-8 is recognized
+// and anything else is matched against DetectionCandidates.
+func DetectCharset(data []byte, declared, mediaType string) CharsetDetection {
+	d := CharsetDetection{Charset: declared, Declared: declared, Source: SourceDeclared}
+
+	for _, b := range boms {
+		if bytes.HasPrefix(data, b.bom) {
+			d.Charset, d.Source, d.BOMLength = b.charset, SourceBOM, len(b.bom)
+			return d
+		}
+	}
+
+	if declared == "" && strings.HasPrefix(strings.ToLower(mediaType), "text/html") {
+		if meta := metaCharset(data); meta != "" {
+			if _, err := Charsets.Lookup(meta); err == nil {
+				declared, d.Charset, d.Source = meta, meta, SourceMeta
+			}
+		}
+	}
+
+	if isASCII(data) {
+		if declared == "" {
+			d.Charset, d.Source = DefaultCharset, SourceDefault
+		}
+		return d
+	}
+
+	if utf8.Valid(data) {
+		if !isUTF8Label(declared) {
+			d.Charset, d.Source = "utf-8", SourceDetected
+		}
+		return d
+	}
+
+	if declared != "" && !isUTF8Label(declared) && !isASCIILabel(declared) {
+		if _, err := Charsets.Lookup(declared); err == nil && score(data, declared) > math.Inf(-1) {
+			return d
+		}
+	}
+
+	if guess := guessCharset(data); guess != "" {
+		d.Charset, d.Source = guess, SourceDetected
+	}
+	return d
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func isUTF8Label(label string) bool {
+	switch normalizeLabel(label) {
+	case "utf-8", "utf8", "unicode-1-1-utf-8":
+		return true
+	}
+	return false
+}
+
+func isASCIILabel(label string) bool {
+	switch normalizeLabel(label) {
+	case "us-ascii", "ascii", "ansi_x3.4-1968", "iso646-us":
+		return true
+	}
+	return false
+}
+
+// guessCharset returns the best scoring candidate for data, or "" if none
+// of them can decode it.
+func guessCharset(data []byte) string {
+	if len(data) > 64*1024 {
+		data = data[:64*1024]
+	}
+	best, bestScore := "", math.Inf(-1)
+	for _, cs := range DetectionCandidates {
+		if s := score(data, cs); s > bestScore {
+			best, bestScore = cs, s
+		}
+	}
+	return best
+}
+
+type script int
+
+const (
+	scriptNone script = iota
+	scriptLatin
+	scriptCyrillic
+	scriptGreek
+	scriptCJK
+	scriptHangul
+	scriptOther
+)
+
+func scriptOf(r rune) script {
+	switch {
+	case !unicode.IsLetter(r):
+		return scriptNone
+	case unicode.Is(unicode.Latin, r):
+		return scriptLatin
+	case unicode.Is(unicode.Cyrillic, r):
+		return scriptCyrillic
+	case unicode.Is(unicode.Greek, r):
+		return scriptGreek
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+		return scriptCJK
+	case unicode.Is(unicode.Hangul, r):
+		return scriptHangul
+	}
+	return scriptOther
+}
+
+// score rates how plausible data is as text in charset cs. Undecodable data
+// scores -Inf. Otherwise letters score positively and kana, which is rarely
+// produced by decoding with the wrong charset, doubly so. Controls, symbols,
+// runs of accented Latin letters, case changes inside words and script
+// changes inside words, which are typical for text decoded with the wrong
+// charset, score negatively.
+func score(data []byte, cs string) float64 {
+	enc, err := Charsets.Lookup(cs)
+	if err != nil {
+		return math.Inf(-1)
+	}
+	text, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return math.Inf(-1)
+	}
+
+	total, n := 0.0, 0
+	prev := rune(' ')
+	for _, r := range string(text) {
+		if r >= 0x80 {
+			n++
+			switch {
+			case r == utf8.RuneError:
+				return math.Inf(-1)
+			case unicode.IsControl(r) || unicode.In(r, unicode.Co, unicode.Cs):
+				total -= 5
+			case unicode.IsLetter(r):
+				total++
+				if unicode.In(r, unicode.Hiragana, unicode.Katakana) {
+					total++
+				}
+				if prev >= 0x80 && unicode.Is(unicode.Latin, r) && unicode.Is(unicode.Latin, prev) {
+					total--
+				}
+			case unicode.IsPunct(r) || unicode.IsSymbol(r):
+				total -= 0.5
+			}
+		}
+		if (r >= 0x80 || prev >= 0x80) && unicode.IsLetter(r) && unicode.IsLetter(prev) {
+			if unicode.IsLower(prev) && unicode.IsUpper(r) {
+				total -= 2
+			}
+			if scriptOf(prev) != scriptOf(r) {
+				total -= 2
+			}
+		}
+		prev = r
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / float64(n)
+}
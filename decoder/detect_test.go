@@ -0,0 +1,40 @@
+package decoder
+
+import "testing"
+
+type detectTest struct {
+	data      []byte
+	declared  string
+	mediaType string
+	charset   string
+	source    CharsetSource
+}
+
+var detectTests = []detectTest{
+	{[]byte("plain"), "", "text/plain", "us-ascii", SourceDefault},
+	{[]byte("plain"), "iso-8859-1", "text/plain", "iso-8859-1", SourceDeclared},
+	{[]byte("\xef\xbb\xbfgrün"), "iso-8859-1", "text/plain", "utf-8", SourceBOM},
+	{[]byte("\xff\xfeh\x00i\x00"), "", "text/plain", "utf-16le", SourceBOM},
+	{[]byte("grün"), "us-ascii", "text/plain", "utf-8", SourceDetected},
+	{[]byte("grün"), "UTF-8", "text/plain", "UTF-8", SourceDeclared},
+	{[]byte("Gr\xfc\xdfe aus K\xf6ln, sch\xf6ne Gr\xfc\xdfe"), "UTF-8", "text/plain", "windows-1252", SourceDetected},
+	{[]byte("Gr\xfc\xdfe"), "iso-8859-15", "text/plain", "iso-8859-15", SourceDeclared},
+	{[]byte("\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd\x90\xa2\x8aE"), "us-ascii", "text/plain", "shift_jis", SourceDetected},
+	{[]byte("\xcf\xf0\xe8\xe2\xe5\xf2, \xec\xe8\xf0"), "", "text/plain", "windows-1251", SourceDetected},
+	{[]byte("\xf0\xd2\xc9\xd7\xc5\xd4, \xcd\xc9\xd2"), "", "text/plain", "koi8-r", SourceDetected},
+	{[]byte(`<html><head><meta charset="windows-1250"></head><body>P\xf8\xedli\xb9</body></html>`), "", "text/html", "windows-1250", SourceMeta},
+	{[]byte(`<meta http-equiv="Content-Type" content="text/html; charset=iso-8859-2">\xb1`), "", "text/html", "iso-8859-2", SourceMeta},
+}
+
+func TestDetectCharset(t *testing.T) {
+	for _, dt := range detectTests {
+		d := DetectCharset(dt.data, dt.declared, dt.mediaType)
+		if d.Charset != dt.charset || d.Source != dt.source {
+			t.Errorf("DetectCharset(%q, %q) gave %s (%s); expected %s (%s)",
+				dt.data, dt.declared, d.Charset, d.Source, dt.charset, dt.source)
+		}
+		if d.Declared != dt.declared {
+			t.Errorf("DetectCharset(%q, %q) recorded declared charset %q", dt.data, dt.declared, d.Declared)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package decoder
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxWordLength is the longest encoded-word RFC 2047 allows.
+	maxWordLength = 75
+	// DefaultLineLength is the line length header values are folded to.
+	DefaultLineLength = 76
+)
+
+// Encoder produces RFC 2047 encoded-words for header values.
+type Encoder struct {
+	// Charset is the charset the text is converted to before encoding. It
+	// defaults to UTF-8.
+	Charset string
+	// Phrase restricts the encoding to what RFC 2047 section 5 (3) allows
+	// in a display name. Otherwise the value is encoded as unstructured
+	// text, as in Subject.
+	Phrase bool
+	// LineLength is the line length to fold to, DefaultLineLength if zero.
+	LineLength int
+}
+
+// EncodeText encodes s for use as an unstructured header value in UTF-8.
+// offset is the number of characters already on the first line, usually
+// the length of the field name plus ": ".
+func EncodeText(s string, offset int) string {
+	res, _ := Encoder{}.Encode(s, offset)
+	return res
+}
+
+// EncodePhrase encodes s for use as the display name of an address in
+// UTF-8, see EncodeText.
+func EncodePhrase(s string, offset int) string {
+	res, _ := Encoder{Phrase: true}.Encode(s, offset)
+	return res
+}
+
+func (e Encoder) charset() string {
+	if e.Charset == "" {
+		return "UTF-8"
+	}
+	return e.Charset
+}
+
+func (e Encoder) lineLength() int {
+	if e.LineLength <= 0 {
+		return DefaultLineLength
+	}
+	return e.LineLength
+}
+
+// Encode encodes s as a header value, folding it to e.LineLength. Text that
+// needs no encoding is folded at white space (or, for phrases, quoted if
+// necessary). Otherwise the whole value is turned into a sequence of
+// encoded-words using whichever of the B and Q encodings is shorter. A
+// character is never split across two words.
+func (e Encoder) Encode(s string, offset int) (string, error) {
+	if !needsEncoding(s) {
+		if e.Phrase {
+			return quotePhrase(s), nil
+		}
+		return fold(s, offset, e.lineLength()), nil
+	}
+
+	chars, err := e.encodeChars(s)
+	if err != nil {
+		return "", err
+	}
+
+	n, qLen := 0, 0
+	for _, c := range chars {
+		n += len(c)
+		for _, b := range c {
+			qLen += e.qLen(b)
+		}
+	}
+	enc := byte('Q')
+	if (n+2)/3*4 < qLen {
+		enc = 'B'
+	}
+
+	prefix := "=?" + e.charset() + "?" + string(enc) + "?"
+	overhead := len(prefix) + len("?=")
+	maxLen := maxWordLength
+	if l := e.lineLength() - 1; l < maxLen {
+		maxLen = l
+	}
+	avail := e.lineLength() - offset
+	if avail > maxLen {
+		avail = maxLen
+	}
+
+	var res strings.Builder
+	var word []byte
+	first := true
+	emit := func() {
+		if !first {
+			res.WriteString("\r\n ")
+		}
+		first = false
+		res.WriteString(prefix)
+		if enc == 'B' {
+			res.WriteString(base64.StdEncoding.EncodeToString(word))
+		} else {
+			e.writeQ(&res, word)
+		}
+		res.WriteString("?=")
+		word = word[:0]
+		avail = maxLen
+	}
+	if avail < overhead+12 {
+		// Not even a short word fits on the first line, so fold
+		// before the first word.
+		first, avail = false, maxLen
+	}
+	for _, c := range chars {
+		next := append(word, c...)
+		if len(word) > 0 && overhead+e.encodedLen(enc, next) > avail {
+			emit()
+			next = append(word, c...)
+		}
+		word = next
+	}
+	emit()
+	return res.String(), nil
+}
+
+// encodeChars converts every character of s to the target charset
+// separately, so that words can be split between characters.
+func (e Encoder) encodeChars(s string) ([][]byte, error) {
+	var chars [][]byte
+	if isUTF8Label(e.charset()) {
+		for _, r := range s {
+			chars = append(chars, []byte(string(r)))
+		}
+		return chars, nil
+	}
+	enc, err := Charsets.Lookup(e.charset())
+	if err != nil {
+		return nil, err
+	}
+	encoder := enc.NewEncoder()
+	for _, r := range s {
+		c, err := encoder.Bytes([]byte(string(r)))
+		if err != nil {
+			return nil, err
+		}
+		chars = append(chars, c)
+	}
+	return chars, nil
+}
+
+func (e Encoder) encodedLen(enc byte, b []byte) int {
+	if enc == 'B' {
+		return (len(b) + 2) / 3 * 4
+	}
+	n := 0
+	for _, c := range b {
+		n += e.qLen(c)
+	}
+	return n
+}
+
+// qSafe reports whether b may appear literally in Q-encoded text.
+func (e Encoder) qSafe(b byte) bool {
+	switch {
+	case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		return true
+	case b == '!', b == '*', b == '+', b == '-', b == '/':
+		return true
+	case e.Phrase:
+		return false
+	}
+	return b > ' ' && b < 127 && b != '=' && b != '?' && b != '_'
+}
+
+func (e Encoder) qLen(b byte) int {
+	if b == ' ' || e.qSafe(b) {
+		return 1
+	}
+	return 3
+}
+
+func (e Encoder) writeQ(w *strings.Builder, b []byte) {
+	const hex = "0123456789ABCDEF"
+	for _, c := range b {
+		switch {
+		case c == ' ':
+			w.WriteByte('_')
+		case e.qSafe(c):
+			w.WriteByte(c)
+		default:
+			w.WriteByte('=')
+			w.WriteByte(hex[c>>4])
+			w.WriteByte(hex[c&0xf])
+		}
+	}
+}
+
+// needsEncoding reports whether s contains anything that cannot appear in a
+// header as is: non-ASCII or control characters, or text that a decoder
+// would mistake for an encoded-word.
+func needsEncoding(s string) bool {
+	if !utf8.ValidString(s) || strings.Contains(s, "=?") {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; b >= 127 || (b < ' ' && b != '\t') {
+			return true
+		}
+	}
+	return false
+}
+
+// quotePhrase returns s as a phrase, using a quoted-string if it contains
+// anything but atoms separated by single spaces.
+func quotePhrase(s string) string {
+	plain := s != ""
+	for _, w := range strings.Split(s, " ") {
+		if w == "" {
+			plain = false
+			break
+		}
+		for j := 0; j < len(w); j++ {
+			if !isAtext(w[j]) {
+				plain = false
+			}
+		}
+	}
+	if plain {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func isAtext(b byte) bool {
+	switch {
+	case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-/=?^_`{|}~", b) >= 0
+}
+
+// fold inserts line breaks before white space so that lines do not exceed
+// lineLength where possible.
+func fold(s string, offset, lineLength int) string {
+	var res strings.Builder
+	col := offset
+	for i, w := range strings.Split(s, " ") {
+		if i > 0 {
+			if col+1+len(w) > lineLength && col > 0 {
+				res.WriteString("\r\n")
+				col = 0
+			}
+			res.WriteByte(' ')
+			col++
+		}
+		res.WriteString(w)
+		col += len(w)
+	}
+	return res.String()
+}
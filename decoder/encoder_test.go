@@ -0,0 +1,78 @@
+package decoder
+
+import (
+	"strings"
+	"testing"
+)
+
+type encodeTest struct {
+	encoder  Encoder
+	input    string
+	offset   int
+	expected string
+}
+
+var encodeTests = []encodeTest{
+	{Encoder{}, "Hello, world", 9, "Hello, world"},
+	{Encoder{}, "Café au lait", 9, "=?UTF-8?Q?Caf=C3=A9_au_lait?="},
+	{Encoder{}, "Grüße", 9, "=?UTF-8?B?R3LDvMOfZQ==?="},
+	{Encoder{}, "日本語", 9, "=?UTF-8?B?5pel5pys6Kqe?="},
+	{Encoder{}, "see =?utf-8?q?x?= here", 0, "=?UTF-8?B?c2VlID0/dXRmLTg/cT94Pz0gaGVyZQ==?="},
+	{Encoder{Phrase: true}, "Joe Public", 0, "Joe Public"},
+	{Encoder{Phrase: true}, "Public, Joe", 0, `"Public, Joe"`},
+	{Encoder{Phrase: true}, "Jörg Public", 0, "=?UTF-8?Q?J=C3=B6rg_Public?="},
+	{Encoder{Phrase: true}, "Jörg (IT)", 0, "=?UTF-8?B?SsO2cmcgKElUKQ==?="},
+	{Encoder{Charset: "ISO-8859-1"}, "Grüße aus Köln", 0, "=?ISO-8859-1?Q?Gr=FC=DFe_aus_K=F6ln?="},
+}
+
+func TestEncode(t *testing.T) {
+	for _, et := range encodeTests {
+		actual, err := et.encoder.Encode(et.input, et.offset)
+		if err != nil {
+			t.Errorf("Encode(%q) returned error: %s", et.input, err)
+		} else if actual != et.expected {
+			t.Errorf("Encode(%q) gave %q; expected %q", et.input, actual, et.expected)
+		}
+	}
+
+	if _, err := (Encoder{Charset: "ISO-8859-1"}).Encode("日本", 0); err == nil {
+		t.Errorf("Encode did not fail for characters outside the charset")
+	}
+}
+
+func unfold(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "")
+}
+
+func TestEncodeFolding(t *testing.T) {
+	inputs := []string{
+		strings.Repeat("Grüße aus Köln ", 10),
+		strings.Repeat("日本語のテキスト", 12),
+		strings.Repeat("😀", 40),
+		strings.Repeat("plain ascii words ", 12),
+	}
+	for _, in := range inputs {
+		enc := EncodeText(in, len("Subject: "))
+		for i, line := range strings.Split(enc, "\r\n") {
+			max := DefaultLineLength
+			if i == 0 {
+				max -= len("Subject: ")
+			}
+			if len(line) > max {
+				t.Errorf("line %d of %q is %d characters long", i, enc, len(line))
+			}
+		}
+		dec, err := DecodeHeader(unfold(enc))
+		if err != nil || dec != in {
+			t.Errorf("round trip of %q gave %q, %v", in, dec, err)
+		}
+		for _, seg := range Split(unfold(enc)) {
+			if seg.Word == nil {
+				continue
+			}
+			if d, err := seg.Word.Decode(); err != nil || strings.ContainsRune(d, '�') {
+				t.Errorf("word %q does not decode on its own: %q, %v", seg.Raw, d, err)
+			}
+		}
+	}
+}
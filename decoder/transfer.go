@@ -0,0 +1,193 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const base64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+var base64Values = func() (v [256]int8) {
+	for i := range v {
+		v[i] = -1
+	}
+	for i := 0; i < len(base64Alphabet); i++ {
+		v[base64Alphabet[i]] = int8(i)
+	}
+	return
+}()
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n' || b == '\v' || b == '\f'
+}
+
+// Base64 decodes base64 data as found in message bodies, salvaging as much
+// as possible from damaged input instead of failing. White space anywhere
+// is ignored. Padding in the middle of the data ends a quantum early, as
+// when encoded chunks were concatenated; missing padding and truncated
+// quanta at the end are tolerated; characters outside the alphabet are
+// skipped, except for the URL-safe '-' and '_', which are accepted. Every
+// such repair is described in the returned warnings.
+func Base64(data []byte) ([]byte, []string) {
+	out := make([]byte, 0, len(data)/4*3+3)
+	var (
+		quantum  [4]byte
+		n        int
+		invalid  int
+		urlSafe  bool
+		midPad   bool
+		warnings []string
+	)
+	flush := func() {
+		switch n {
+		case 2:
+			out = append(out, quantum[0]<<2|quantum[1]>>4)
+		case 3:
+			out = append(out, quantum[0]<<2|quantum[1]>>4, quantum[1]<<4|quantum[2]>>2)
+		}
+	}
+
+	padded := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if isSpace(c) {
+			continue
+		}
+		if c == '=' {
+			if n == 1 {
+				warnings = append(warnings, fmt.Sprintf("base64: dropped incomplete quantum at offset %d", i))
+			}
+			flush()
+			n, padded = 0, true
+			continue
+		}
+		v := base64Values[c]
+		switch {
+		case v >= 0:
+		case c == '-' || c == '_':
+			v, urlSafe = 62, true
+			if c == '_' {
+				v = 63
+			}
+		default:
+			invalid++
+			continue
+		}
+		if padded {
+			midPad = true
+			padded = false
+		}
+		quantum[n] = byte(v)
+		n++
+		if n == 4 {
+			out = append(out, quantum[0]<<2|quantum[1]>>4, quantum[1]<<4|quantum[2]>>2, quantum[2]<<6|quantum[3])
+			n = 0
+		}
+	}
+
+	switch n {
+	case 1:
+		warnings = append(warnings, "base64: dropped incomplete quantum at end of data")
+	case 2, 3:
+		warnings = append(warnings, "base64: missing padding at end of data")
+		flush()
+	}
+	if midPad {
+		warnings = append(warnings, "base64: padding in the middle of data")
+	}
+	if urlSafe {
+		warnings = append(warnings, "base64: URL-safe alphabet used")
+	}
+	if invalid > 0 {
+		warnings = append(warnings, fmt.Sprintf("base64: skipped %d invalid characters", invalid))
+	}
+	return out, warnings
+}
+
+func unhexQP(b byte) (byte, bool, bool) {
+	switch {
+	case b >= 'a' && b <= 'f':
+		return b - 'a' + 10, true, true
+	}
+	v, ok := unhex(b)
+	return v, ok, false
+}
+
+// QuotedPrintable decodes quoted-printable data as found in message bodies,
+// keeping line endings as they are. It accepts lower-case hex digits, soft
+// line breaks followed by trailing white space and a final '=' without a
+// line break. Malformed escapes and unencoded 8-bit bytes are kept
+// literally. Every such repair is described in the returned warnings.
+func QuotedPrintable(data []byte) ([]byte, []string) {
+	out := make([]byte, 0, len(data))
+	var (
+		lower, spaceSoft, badEscape, eight int
+		warnings                           []string
+	)
+
+	for len(data) > 0 {
+		var line, eol []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, eol, data = data[:i], data[i:i+1], data[i+1:]
+			if len(line) > 0 && line[len(line)-1] == '\r' {
+				line, eol = line[:len(line)-1], []byte("\r\n")
+			}
+		} else {
+			line, data = data, nil
+		}
+
+		// Trailing white space was added in transport and is removed, as
+		// RFC 2045 section 6.7 (3) requires.
+		trimmed := bytes.TrimRight(line, " \t")
+		soft := false
+		if len(trimmed) > 0 && trimmed[len(trimmed)-1] == '=' {
+			soft = true
+			if len(trimmed) < len(line) {
+				spaceSoft++
+			}
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+
+		for i := 0; i < len(trimmed); i++ {
+			c := trimmed[i]
+			if c != '=' {
+				if c >= 0x80 {
+					eight++
+				}
+				out = append(out, c)
+				continue
+			}
+			if i+2 < len(trimmed) {
+				h, ok1, l1 := unhexQP(trimmed[i+1])
+				l, ok2, l2 := unhexQP(trimmed[i+2])
+				if ok1 && ok2 {
+					if l1 || l2 {
+						lower++
+					}
+					out = append(out, h<<4|l)
+					i += 2
+					continue
+				}
+			}
+			badEscape++
+			out = append(out, c)
+		}
+		if !soft {
+			out = append(out, eol...)
+		}
+	}
+
+	if lower > 0 {
+		warnings = append(warnings, fmt.Sprintf("quoted-printable: %d lower-case escapes", lower))
+	}
+	if spaceSoft > 0 {
+		warnings = append(warnings, fmt.Sprintf("quoted-printable: %d soft line breaks followed by white space", spaceSoft))
+	}
+	if badEscape > 0 {
+		warnings = append(warnings, fmt.Sprintf("quoted-printable: kept %d malformed escapes literally", badEscape))
+	}
+	if eight > 0 {
+		warnings = append(warnings, fmt.Sprintf("quoted-printable: %d unencoded 8-bit bytes", eight))
+	}
+	return out, warnings
+}
@@ -0,0 +1,51 @@
+package decoder
+
+import (
+	"reflect"
+	"testing"
+)
+
+type transferTest struct {
+	input    string
+	expected string
+	warnings []string
+}
+
+var base64Tests = []transferTest{
+	{"VGhpcyBpcyBhIHRlc3Q=", "This is a test", nil},
+	{"VGhpcyBp\r\ncyBhIHRl c3Q=\r\n", "This is a test", nil},
+	{"VGhpcyBpcyBhIHRlc3Q", "This is a test", []string{"base64: missing padding at end of data"}},
+	{"VGhpcw==IGlz", "This is", []string{"base64: padding in the middle of data"}},
+	{"VGhp*cyBp!cyBh", "This is a", []string{"base64: skipped 2 invalid characters"}},
+	{"VGhpcyBpcyBhIHRlc3QhI", "This is a test!", []string{"base64: dropped incomplete quantum at end of data"}},
+	{"-_-_", "\xfb\xff\xbf", []string{"base64: URL-safe alphabet used"}},
+}
+
+func TestBase64(t *testing.T) {
+	for _, tt := range base64Tests {
+		actual, warnings := Base64([]byte(tt.input))
+		if string(actual) != tt.expected || !reflect.DeepEqual(warnings, tt.warnings) {
+			t.Errorf("Base64(%q) gave %q, %#v; expected %q, %#v", tt.input, actual, warnings, tt.expected, tt.warnings)
+		}
+	}
+}
+
+var quotedPrintableTests = []transferTest{
+	{"caf=C3=A9\r\nline two\r\n", "café\r\nline two\r\n", nil},
+	{"soft=\r\nbreak\n", "softbreak\n", nil},
+	{"trailing   \r\nspace", "trailing\r\nspace", nil},
+	{"soft= \t\r\nbreak", "softbreak", []string{"quoted-printable: 1 soft line breaks followed by white space"}},
+	{"caf=c3=a9", "café", []string{"quoted-printable: 2 lower-case escapes"}},
+	{"1+1=2 and =ZZ", "1+1=2 and =ZZ", []string{"quoted-printable: kept 2 malformed escapes literally"}},
+	{"end=", "end", nil},
+	{"caf\xe9", "caf\xe9", []string{"quoted-printable: 1 unencoded 8-bit bytes"}},
+}
+
+func TestQuotedPrintable(t *testing.T) {
+	for _, tt := range quotedPrintableTests {
+		actual, warnings := QuotedPrintable([]byte(tt.input))
+		if string(actual) != tt.expected || !reflect.DeepEqual(warnings, tt.warnings) {
+			t.Errorf("QuotedPrintable(%q) gave %q, %#v; expected %q, %#v", tt.input, actual, warnings, tt.expected, tt.warnings)
+		}
+	}
+}
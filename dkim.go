@@ -0,0 +1,498 @@
+// Verification of DKIM signatures (RFC 6376, RFC 8463).
+
+package eml
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TXTResolver looks up DNS TXT records. *net.Resolver implements it; tests
+// and offline verification can use a local key store instead.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// AuthStatus is the result of an authentication check, named as in
+// Authentication-Results headers (RFC 8601).
+type AuthStatus string
+
+const (
+	AuthNone      AuthStatus = "none"
+	AuthPass      AuthStatus = "pass"
+	AuthFail      AuthStatus = "fail"
+	AuthNeutral   AuthStatus = "neutral"
+	AuthPolicy    AuthStatus = "policy"
+	AuthTempError AuthStatus = "temperror"
+	AuthPermError AuthStatus = "permerror"
+)
+
+// DKIMSignature is a parsed DKIM-Signature header field.
+type DKIMSignature struct {
+	Version      int
+	Algorithm    string // a=, such as "rsa-sha256"
+	Signature    []byte // b=
+	BodyHash     []byte // bh=
+	HeaderCanon  string // c=, "simple" or "relaxed"
+	BodyCanon    string
+	Domain       string   // d=
+	Headers      []string // h=, the signed header fields
+	Identity     string   // i=, defaults to "@" + Domain
+	Length       int64    // l=, or -1 if the whole body is signed
+	Selector     string   // s=
+	Timestamp    time.Time
+	Expiration   time.Time
+	QueryMethods []string // q=
+	raw          []byte   // the complete header field
+}
+
+// DKIMResult is the outcome of verifying one DKIM signature.
+type DKIMResult struct {
+	// Signature is the parsed signature, or nil if it could not be
+	// parsed.
+	Signature *DKIMSignature
+	Status    AuthStatus
+	// Err explains why the signature did not pass.
+	Err error
+	// Testing is set if the key is marked as being in test mode (t=y).
+	Testing bool
+}
+
+// DKIMVerifier verifies the DKIM signatures of messages.
+type DKIMVerifier struct {
+	// Resolver looks up the public keys. If nil, net.DefaultResolver is
+	// used.
+	Resolver TXTResolver
+	// Now returns the time expiration (x=) is checked against. If nil,
+	// time.Now is used; archives may set the time of receipt instead.
+	Now func() time.Time
+	// MinRSABits is the minimum accepted RSA key size, 1024 if zero.
+	MinRSABits int
+}
+
+var (
+	errDKIMSyntax   = errors.New("dkim: malformed signature")
+	errDKIMBodyHash = errors.New("dkim: body hash does not match")
+	errDKIMSig      = errors.New("dkim: signature does not verify")
+)
+
+// ParseDKIMSignature parses the value of a DKIM-Signature header field and
+// checks that the required tags are present.
+func ParseDKIMSignature(value string) (*DKIMSignature, error) {
+	tags, err := parseTagList(value)
+	if err != nil {
+		return nil, err
+	}
+	return dkimSignatureFromTags(tags)
+}
+
+// dkimSignatureFromTags builds a DKIMSignature from its parsed tags.
+func dkimSignatureFromTags(tags map[string]string) (*DKIMSignature, error) {
+	var err error
+	sig := &DKIMSignature{Length: -1, HeaderCanon: "simple", BodyCanon: "simple", QueryMethods: []string{"dns/txt"}}
+	for _, k := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[k]; !ok {
+			return nil, fmt.Errorf("%w: missing %s= tag", errDKIMSyntax, k)
+		}
+	}
+	if tags["v"] != "1" {
+		return nil, fmt.Errorf("%w: unsupported version %q", errDKIMSyntax, tags["v"])
+	}
+	sig.Version = 1
+	sig.Algorithm = strings.ToLower(tags["a"])
+	if sig.Signature, err = decodeTagBase64(tags["b"]); err != nil {
+		return nil, fmt.Errorf("%w: b= %v", errDKIMSyntax, err)
+	}
+	if sig.BodyHash, err = decodeTagBase64(tags["bh"]); err != nil {
+		return nil, fmt.Errorf("%w: bh= %v", errDKIMSyntax, err)
+	}
+	if c, ok := tags["c"]; ok {
+		h, b, hasBody := strings.Cut(strings.ToLower(c), "/")
+		sig.HeaderCanon = h
+		if hasBody {
+			sig.BodyCanon = b
+		}
+		for _, c := range []string{sig.HeaderCanon, sig.BodyCanon} {
+			if c != "simple" && c != "relaxed" {
+				return nil, fmt.Errorf("%w: unknown canonicalization %q", errDKIMSyntax, c)
+			}
+		}
+	}
+	sig.Domain = strings.ToLower(strings.TrimSuffix(tags["d"], "."))
+	sig.Selector = tags["s"]
+	fromSigned := false
+	for _, h := range strings.Split(tags["h"], ":") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			return nil, fmt.Errorf("%w: empty header name in h=", errDKIMSyntax)
+		}
+		fromSigned = fromSigned || strings.EqualFold(h, "From")
+		sig.Headers = append(sig.Headers, h)
+	}
+	if !fromSigned {
+		return nil, fmt.Errorf("%w: From is not signed", errDKIMSyntax)
+	}
+	sig.Identity = "@" + sig.Domain
+	if i, ok := tags["i"]; ok {
+		sig.Identity = i
+		at := strings.LastIndexByte(i, '@')
+		d := strings.ToLower(strings.TrimSuffix(i[at+1:], "."))
+		if at < 0 || (d != sig.Domain && !strings.HasSuffix(d, "."+sig.Domain)) {
+			return nil, fmt.Errorf("%w: i= is not within d=", errDKIMSyntax)
+		}
+	}
+	if l, ok := tags["l"]; ok {
+		if sig.Length, err = strconv.ParseInt(l, 10, 64); err != nil || sig.Length < 0 {
+			return nil, fmt.Errorf("%w: invalid l= %q", errDKIMSyntax, l)
+		}
+	}
+	if q, ok := tags["q"]; ok {
+		sig.QueryMethods = strings.Split(q, ":")
+	}
+	for _, k := range []string{"t", "x"} {
+		v, ok := tags[k]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s= %q", errDKIMSyntax, k, v)
+		}
+		if k == "t" {
+			sig.Timestamp = time.Unix(n, 0)
+		} else {
+			sig.Expiration = time.Unix(n, 0)
+		}
+	}
+	if !sig.Timestamp.IsZero() && !sig.Expiration.IsZero() && sig.Expiration.Before(sig.Timestamp) {
+		return nil, fmt.Errorf("%w: x= is before t=", errDKIMSyntax)
+	}
+	return sig, nil
+}
+
+// parseTagList parses a DKIM tag=value list. White space around tags and
+// values is removed; duplicate tags are an error.
+func parseTagList(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, spec := range strings.Split(s, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(spec, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%w: tag without value %q", errDKIMSyntax, strings.TrimSpace(spec))
+		}
+		if _, dup := tags[k]; dup {
+			return nil, fmt.Errorf("%w: duplicate tag %s=", errDKIMSyntax, k)
+		}
+		tags[k] = strings.TrimSpace(v)
+	}
+	return tags, nil
+}
+
+func decodeTagBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s))
+}
+
+// Verify verifies every DKIM-Signature of the message, in the order they
+// appear. A message without signatures gives no results.
+func (v DKIMVerifier) Verify(ctx context.Context, r RawMessage) []DKIMResult {
+	var results []DKIMResult
+	for _, h := range r.RawHeaders {
+		if !strings.EqualFold(string(h.Key), "DKIM-Signature") {
+			continue
+		}
+		sig, err := ParseDKIMSignature(string(h.Value))
+		if err != nil {
+			results = append(results, DKIMResult{Status: AuthPermError, Err: err})
+			continue
+		}
+		sig.raw = h.Raw
+		results = append(results, v.verify(ctx, r, sig))
+	}
+	return results
+}
+
+func (v DKIMVerifier) verify(ctx context.Context, r RawMessage, sig *DKIMSignature) DKIMResult {
+	res := DKIMResult{Signature: sig, Status: AuthPermError}
+	if sig.Algorithm != "rsa-sha256" && sig.Algorithm != "ed25519-sha256" {
+		res.Err = fmt.Errorf("dkim: unsupported algorithm %q", sig.Algorithm)
+		return res
+	}
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	if !sig.Expiration.IsZero() && now().After(sig.Expiration) {
+		res.Err = fmt.Errorf("dkim: signature expired at %s", sig.Expiration.UTC().Format(time.RFC3339))
+		return res
+	}
+
+	body := canonicalBody(r.Body, sig.BodyCanon)
+	if sig.Length >= 0 {
+		if sig.Length > int64(len(body)) {
+			res.Err = fmt.Errorf("dkim: l=%d exceeds body length %d", sig.Length, len(body))
+			res.Status = AuthFail
+			return res
+		}
+		body = body[:sig.Length]
+	}
+	if bh := sha256.Sum256(body); !bytes.Equal(bh[:], sig.BodyHash) {
+		res.Status, res.Err = AuthFail, errDKIMBodyHash
+		return res
+	}
+
+	key, status, err := v.checkSignature(ctx, sig, dkimSigningInput(r.RawHeaders, sig.Headers, sig.raw, sig.HeaderCanon))
+	res.Testing = key.testing
+	res.Status, res.Err = status, err
+	return res
+}
+
+// checkSignature looks up the key of a signature and verifies that the
+// signature covers data.
+func (v DKIMVerifier) checkSignature(ctx context.Context, sig *DKIMSignature, data []byte) (dkimKey, AuthStatus, error) {
+	key, err := v.lookupKey(ctx, sig)
+	if err != nil {
+		var te temporaryError
+		if errors.As(err, &te) {
+			return key, AuthTempError, err
+		}
+		return key, AuthPermError, err
+	}
+
+	hash := sha256.Sum256(data)
+	switch pub := key.pub.(type) {
+	case *rsa.PublicKey:
+		min := v.MinRSABits
+		if min == 0 {
+			min = 1024
+		}
+		if pub.N.BitLen() < min {
+			return key, AuthPermError, fmt.Errorf("dkim: RSA key of %d bits is too short", pub.N.BitLen())
+		}
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig.Signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, hash[:], sig.Signature) {
+			err = errDKIMSig
+		}
+	}
+	if err != nil {
+		return key, AuthFail, errDKIMSig
+	}
+	return key, AuthPass, nil
+}
+
+// temporaryError marks errors that may go away when retried, such as DNS
+// timeouts.
+type temporaryError struct {
+	err error
+}
+
+func (e temporaryError) Error() string { return e.err.Error() }
+func (e temporaryError) Unwrap() error { return e.err }
+
+// dkimKey is a parsed DKIM key record.
+type dkimKey struct {
+	pub     crypto.PublicKey
+	testing bool
+}
+
+// lookupKey fetches and parses the key record of a signature.
+func (v DKIMVerifier) lookupKey(ctx context.Context, sig *DKIMSignature) (dkimKey, error) {
+	dnsTXT := false
+	for _, q := range sig.QueryMethods {
+		dnsTXT = dnsTXT || strings.EqualFold(strings.TrimSpace(q), "dns/txt")
+	}
+	if !dnsTXT {
+		return dkimKey{}, errors.New("dkim: no supported query method")
+	}
+
+	resolver := v.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	name := sig.Selector + "._domainkey." + sig.Domain
+	txts, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return dkimKey{}, fmt.Errorf("dkim: no key for %s", name)
+		}
+		return dkimKey{}, temporaryError{fmt.Errorf("dkim: looking up %s: %w", name, err)}
+	}
+	if len(txts) == 0 {
+		return dkimKey{}, fmt.Errorf("dkim: no key for %s", name)
+	}
+	return parseDKIMKey(txts[0], sig)
+}
+
+// parseDKIMKey parses a key record (RFC 6376 section 3.6.1) and checks that
+// it may be used for the signature.
+func parseDKIMKey(record string, sig *DKIMSignature) (dkimKey, error) {
+	tags, err := parseTagList(record)
+	if err != nil {
+		return dkimKey{}, fmt.Errorf("dkim: malformed key record: %v", err)
+	}
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return dkimKey{}, fmt.Errorf("dkim: unsupported key record version %q", v)
+	}
+	if h, ok := tags["h"]; ok && !containsFold(strings.Split(h, ":"), "sha256") {
+		return dkimKey{}, errors.New("dkim: key does not allow sha256")
+	}
+	if s, ok := tags["s"]; ok && !containsFold(strings.Split(s, ":"), "email") && !containsFold(strings.Split(s, ":"), "*") {
+		return dkimKey{}, errors.New("dkim: key is not for email")
+	}
+	var key dkimKey
+	for _, f := range strings.Split(tags["t"], ":") {
+		switch strings.TrimSpace(f) {
+		case "y":
+			key.testing = true
+		case "s":
+			at := strings.LastIndexByte(sig.Identity, '@')
+			if !strings.EqualFold(strings.TrimSuffix(sig.Identity[at+1:], "."), sig.Domain) {
+				return dkimKey{}, errors.New("dkim: key does not allow subdomains in i=")
+			}
+		}
+	}
+
+	p, err := decodeTagBase64(tags["p"])
+	if err != nil {
+		return dkimKey{}, fmt.Errorf("dkim: malformed public key: %v", err)
+	}
+	if len(p) == 0 {
+		return dkimKey{}, errors.New("dkim: key has been revoked")
+	}
+	kt := strings.ToLower(tags["k"])
+	if kt == "" {
+		kt = "rsa"
+	}
+	if want, _, _ := strings.Cut(sig.Algorithm, "-"); kt != want {
+		return dkimKey{}, fmt.Errorf("dkim: key type %s does not match algorithm %s", kt, sig.Algorithm)
+	}
+	switch kt {
+	case "rsa":
+		pub, err := x509.ParsePKIXPublicKey(p)
+		if err != nil {
+			// Some publish the bare RSAPublicKey structure.
+			if pub, err = x509.ParsePKCS1PublicKey(p); err != nil {
+				return dkimKey{}, fmt.Errorf("dkim: malformed public key: %v", err)
+			}
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return dkimKey{}, errors.New("dkim: key is not an RSA key")
+		}
+		key.pub = rsaPub
+	case "ed25519":
+		if len(p) != ed25519.PublicKeySize {
+			return dkimKey{}, errors.New("dkim: malformed Ed25519 key")
+		}
+		key.pub = ed25519.PublicKey(p)
+	}
+	return key, nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(strings.TrimSpace(e), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// crlfLines converts bare LF line endings to CRLF.
+func crlfLines(b []byte) []byte {
+	if !bytes.Contains(b, []byte("\n")) {
+		return b
+	}
+	out := make([]byte, 0, len(b)+len(b)/32)
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+var wspRunR = regexp.MustCompile(`[ \t]+`)
+
+// canonicalBody applies the simple or relaxed body canonicalization of RFC
+// 6376 section 3.4.
+func canonicalBody(body []byte, canon string) []byte {
+	lines := strings.Split(string(crlfLines(body)), "\r\n")
+	if canon == "relaxed" {
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(wspRunR.ReplaceAllString(l, " "), " ")
+		}
+	}
+	// The last element follows the last CRLF and is empty for properly
+	// terminated bodies; trailing empty lines are ignored.
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if canon == "relaxed" {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// canonicalHeader applies the simple or relaxed header canonicalization of
+// RFC 6376 section 3.4 to a raw header field, including the final CRLF.
+func canonicalHeader(raw []byte, canon string) string {
+	if canon != "relaxed" {
+		return string(crlfLines(raw)) + "\r\n"
+	}
+	name, value, _ := strings.Cut(string(raw), ":")
+	value = strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+	value = strings.TrimSpace(wspRunR.ReplaceAllString(value, " "))
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + value + "\r\n"
+}
+
+// signatureTagR finds the b= tag of a DKIM-Signature, as opposed to bh=.
+var signatureTagR = regexp.MustCompile(`(^|;)([ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+
+// dkimSigningInput returns the data covered by a signature: the signed
+// header fields, selected from the bottom up, followed by the signature
+// header field itself with an empty b= tag and no final CRLF.
+func dkimSigningInput(headers []RawHeader, signed []string, sigRaw []byte, canon string) []byte {
+	used := make([]bool, len(headers))
+	var b bytes.Buffer
+	for _, name := range signed {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(string(headers[i].Key), strings.TrimSpace(name)) {
+				used[i] = true
+				b.WriteString(canonicalHeader(headers[i].Raw, canon))
+				break
+			}
+		}
+	}
+
+	name, value, _ := strings.Cut(string(sigRaw), ":")
+	value = signatureTagR.ReplaceAllString(value, "$1$2")
+	b.WriteString(strings.TrimSuffix(canonicalHeader([]byte(name+":"+value), canon), "\r\n"))
+	return b.Bytes()
+}
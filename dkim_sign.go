@@ -0,0 +1,178 @@
+// DKIM signing of messages (RFC 6376, RFC 8463).
+
+package eml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Schidstorm/eml/decoder"
+)
+
+// DefaultDKIMHeaders are the header fields a DKIMSigner signs if its
+// Headers are not set, as far as they are present in the message.
+var DefaultDKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// dkimOversigned are the DefaultDKIMHeaders that are signed once more than
+// they occur, so that adding another instance breaks the signature (RFC
+// 6376 section 8.15).
+var dkimOversigned = []string{"From", "Subject", "Date", "To"}
+
+// DKIMSigner adds DKIM signatures with relaxed/relaxed canonicalization to
+// messages.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	// Key is an *rsa.PrivateKey, giving rsa-sha256 signatures, or an
+	// ed25519.PrivateKey, giving ed25519-sha256 signatures.
+	Key crypto.Signer
+	// Headers lists the header fields to sign. A name listed more often
+	// than the field occurs also signs its absence, which keeps further
+	// instances from being added. If nil, the DefaultDKIMHeaders present
+	// in the message are signed, and From, Subject, Date and To once
+	// more. From is always required.
+	Headers []string
+	// Identity is the optional agent or user identifier (i=).
+	Identity string
+	// Expiration, if non-zero, limits the validity of the signature (x=).
+	Expiration time.Duration
+	// Now returns the signing time (t=). If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Header returns the DKIM-Signature header field for a message.
+func (s DKIMSigner) Header(r RawMessage) (RawHeader, error) {
+	var algorithm string
+	var opts crypto.SignerOpts
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		algorithm, opts = "rsa-sha256", crypto.SHA256
+	case ed25519.PrivateKey:
+		algorithm, opts = "ed25519-sha256", crypto.Hash(0)
+	default:
+		return RawHeader{}, fmt.Errorf("dkim: unsupported key type %T", s.Key)
+	}
+	if s.Domain == "" || s.Selector == "" {
+		return RawHeader{}, errors.New("dkim: domain and selector are required")
+	}
+	// The values end up in the tag list of the header field, so they must
+	// not break it up.
+	for _, v := range []string{s.Domain, s.Selector, s.Identity} {
+		if i := strings.IndexAny(v, "; \t\r\n\x00"); i >= 0 {
+			return RawHeader{}, &HeaderError{"DKIM-Signature", -1, fmt.Sprintf("character %q in tag value %q", v[i], v)}
+		}
+	}
+	for _, name := range s.Headers {
+		if err := ValidateHeaderName(name); err != nil {
+			return RawHeader{}, err
+		}
+	}
+
+	headers := s.Headers
+	if headers == nil {
+		for _, name := range DefaultDKIMHeaders {
+			n := len(headers)
+			for _, h := range r.RawHeaders {
+				if strings.EqualFold(string(h.Key), name) {
+					headers = append(headers, name)
+				}
+			}
+			if len(headers) > n && containsFold(dkimOversigned, name) {
+				headers = append(headers, name)
+			}
+		}
+	}
+	if !containsFold(headers, "From") {
+		return RawHeader{}, errors.New("dkim: From must be signed")
+	}
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now().Unix()
+	bh := sha256.Sum256(canonicalBody(r.Body, "relaxed"))
+
+	tags := []string{
+		"v=1", "a=" + algorithm, "c=relaxed/relaxed", "d=" + s.Domain, "s=" + s.Selector,
+		fmt.Sprintf("t=%d", t),
+	}
+	if s.Expiration != 0 {
+		tags = append(tags, fmt.Sprintf("x=%d", t+int64(s.Expiration/time.Second)))
+	}
+	if s.Identity != "" {
+		tags = append(tags, "i="+s.Identity)
+	}
+	tags = append(tags, "h="+strings.Join(headers, ":"), "bh="+base64.StdEncoding.EncodeToString(bh[:]))
+
+	var b strings.Builder
+	b.WriteString("DKIM-Signature:")
+	line := len(b.String())
+	for _, tag := range tags {
+		if line+len(tag)+2 > decoder.DefaultLineLength {
+			b.WriteString("\r\n\t")
+			line = 1
+		} else {
+			b.WriteByte(' ')
+			line++
+		}
+		b.WriteString(tag)
+		b.WriteByte(';')
+		line += len(tag) + 1
+	}
+	b.WriteString("\r\n\tb=")
+
+	hash := sha256.Sum256(dkimSigningInput(r.RawHeaders, headers, []byte(b.String()), "relaxed"))
+	sig, err := s.Key.Sign(rand.Reader, hash[:], opts)
+	if err != nil {
+		return RawHeader{}, fmt.Errorf("dkim: signing: %w", err)
+	}
+	for enc := base64.StdEncoding.EncodeToString(sig); enc != ""; {
+		n := len(enc)
+		if n > decoder.DefaultLineLength-4 {
+			n = decoder.DefaultLineLength - 4
+			b.WriteString(enc[:n])
+			b.WriteString("\r\n\t")
+		} else {
+			b.WriteString(enc)
+		}
+		enc = enc[n:]
+	}
+
+	raw := []byte(b.String())
+	key, value, _ := bytes.Cut(raw, []byte(":"))
+	return RawHeader{
+		Key:   key,
+		Value: bytes.TrimLeft(bytes.ReplaceAll(value, []byte("\r\n"), nil), " "),
+		Raw:   raw,
+	}, nil
+}
+
+// Sign returns msg with a DKIM-Signature header field prepended.
+func (s DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	r, err := ParseRaw(msg)
+	if err != nil {
+		return nil, err
+	}
+	h, err := s.Header(r)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(h.Raw)+2+len(msg))
+	out = append(out, h.Raw...)
+	out = append(out, "\r\n"...)
+	return append(out, msg...), nil
+}
@@ -0,0 +1,114 @@
+package eml
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDKIMSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	store := dkimKeyStore{
+		"rsa._domainkey.football.example.com": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPub),
+		"ed._domainkey.football.example.com":  "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub),
+	}
+	now := func() time.Time { return time.Unix(1100000000, 0) }
+	v := DKIMVerifier{Resolver: store, Now: now}
+
+	for _, s := range []DKIMSigner{
+		{Domain: "football.example.com", Selector: "rsa", Key: rsaKey, Now: now},
+		{Domain: "football.example.com", Selector: "ed", Key: edKey, Now: now, Expiration: time.Hour, Identity: "joe@football.example.com"},
+		{Domain: "football.example.com", Selector: "rsa", Key: rsaKey, Now: now, Headers: []string{"From", "Subject", "Subject"}},
+	} {
+		signed, err := s.Sign([]byte(dkimTestMessage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(signed), "DKIM-Signature: v=1;") || !strings.HasSuffix(string(signed), dkimTestMessage) {
+			t.Errorf("unexpected signed message %q", signed)
+		}
+		for _, line := range strings.Split(string(signed), "\r\n") {
+			if len(line) > 78 {
+				t.Errorf("line too long: %q", line)
+			}
+		}
+		r, err := ParseRaw(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := v.Verify(context.Background(), r)
+		if len(results) != 1 || results[0].Status != AuthPass {
+			t.Errorf("signature by %s does not verify: %#v", s.Selector, results)
+			continue
+		}
+		sig := results[0].Signature
+		if sig.HeaderCanon != "relaxed" || sig.BodyCanon != "relaxed" || !sig.Timestamp.Equal(now()) {
+			t.Errorf("unexpected signature %#v", sig)
+		}
+		if s.Headers == nil && strings.Join(sig.Headers, ":") != "From:From:Subject:Subject:Date:Date:To:To" {
+			t.Errorf("signed headers %v", sig.Headers)
+		}
+
+		// Relaxed canonicalization tolerates refolding, but not changes.
+		refolded := strings.Replace(string(signed), "Subject: Is dinner ready?", "Subject:  Is dinner\r\n  ready?", 1)
+		r, _ = ParseRaw([]byte(refolded))
+		if results := v.Verify(context.Background(), r); results[0].Status != AuthPass {
+			t.Errorf("refolded message does not verify: %v", results[0].Err)
+		}
+		// Verifiers use the bottom-most instances, so an added header field at
+		// the top only breaks signatures that sign its absence, as the
+		// default ones do for From and Subject.
+		for _, added := range []string{"Subject: Free money", "From: ceo@football.example.com"} {
+			r, _ = ParseRaw([]byte(added + "\r\n" + string(signed)))
+			name, _, _ := strings.Cut(added, ":")
+			n := 0
+			for _, h := range s.Headers {
+				if h == name {
+					n++
+				}
+			}
+			oversigned := s.Headers == nil || n > 1
+			if results := v.Verify(context.Background(), r); (results[0].Status == AuthPass) == oversigned {
+				t.Errorf("added %q: got %s", added, results[0].Status)
+			}
+		}
+	}
+}
+
+func TestDKIMSignErrors(t *testing.T) {
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	for _, s := range []DKIMSigner{
+		{Domain: "example.com", Selector: "s", Key: edKey, Headers: []string{"Subject"}},
+		{Domain: "example.com", Key: edKey},
+		{Domain: "example.com", Selector: "s", Key: fakeSigner{edPub}},
+		{Domain: "example.com", Selector: "s\r\nBcc: eve@example.org", Key: edKey},
+		{Domain: "example.com; s=other", Selector: "s", Key: edKey},
+		{Domain: "example.com", Selector: "s", Key: edKey, Headers: []string{"From", "Subject:\r\nX"}},
+	} {
+		if _, err := s.Sign([]byte(dkimTestMessage)); err == nil {
+			t.Errorf("Sign with %#v did not fail", s)
+		}
+	}
+}
+
+type fakeSigner struct {
+	pub crypto.PublicKey
+}
+
+func (s fakeSigner) Public() crypto.PublicKey { return s.pub }
+func (s fakeSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, nil
+}
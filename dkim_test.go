@@ -0,0 +1,243 @@
+package eml
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dkimKeyStore is a TXTResolver serving key records from memory.
+type dkimKeyStore map[string]string
+
+func (s dkimKeyStore) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if txt, ok := s[name]; ok {
+		return []string{txt}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+type canonTest struct {
+	canon   string
+	headers string
+	body    string
+}
+
+// The example of RFC 6376 section 3.4.6.
+const canonExample = "A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"
+
+var canonTests = []canonTest{
+	{"relaxed", "a:X\r\nb:Y Z\r\n", " C\r\nD E\r\n"},
+	{"simple", "A: X\r\nB : Y\t\r\n\tZ  \r\n", " C \r\nD \t E\r\n"},
+}
+
+func TestDKIMCanonicalization(t *testing.T) {
+	r, err := ParseRaw([]byte(canonExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ct := range canonTests {
+		var headers string
+		for _, h := range r.RawHeaders {
+			headers += canonicalHeader(h.Raw, ct.canon)
+		}
+		if headers != ct.headers {
+			t.Errorf("%s header canonicalization: got %q; expected %q", ct.canon, headers, ct.headers)
+		}
+		if body := string(canonicalBody(r.Body, ct.canon)); body != ct.body {
+			t.Errorf("%s body canonicalization: got %q; expected %q", ct.canon, body, ct.body)
+		}
+	}
+	if body := string(canonicalBody(nil, "simple")); body != "\r\n" {
+		t.Errorf("simple canonicalization of empty body: got %q", body)
+	}
+}
+
+const dkimTestMessage = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+// signTestMessage adds a DKIM-Signature with the given tags to msg.
+func signTestMessage(t *testing.T, msg string, key crypto.Signer, tags string) string {
+	r, err := ParseRaw([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseDKIMSignature(tags + "; bh=; b=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := canonicalBody(r.Body, sig.BodyCanon)
+	if sig.Length >= 0 {
+		body = body[:sig.Length]
+	}
+	bh := sha256.Sum256(body)
+	header := "DKIM-Signature: " + tags + ";\r\n\tbh=" + base64.StdEncoding.EncodeToString(bh[:]) + ";\r\n\tb="
+	hash := sha256.Sum256(dkimSigningInput(r.RawHeaders, sig.Headers, []byte(header), sig.HeaderCanon))
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		opts = crypto.Hash(0)
+	}
+	b, err := key.Sign(rand.Reader, hash[:], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header + base64.StdEncoding.EncodeToString(b) + "\r\n" + msg
+}
+
+func TestDKIMVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	store := dkimKeyStore{
+		"rsa._domainkey.football.example.com":     "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPub),
+		"ed._domainkey.football.example.com":      "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub),
+		"revoked._domainkey.football.example.com": "v=DKIM1; p=",
+	}
+	v := DKIMVerifier{Resolver: store, Now: func() time.Time { return time.Unix(1100000000, 0) }}
+
+	type test struct {
+		name   string
+		key    crypto.Signer
+		tags   string
+		tamper func(string) string
+		status AuthStatus
+	}
+	tests := []test{
+		{"rsa relaxed", rsaKey, "v=1; a=rsa-sha256; c=relaxed/relaxed; d=football.example.com; s=rsa; h=From:To:Subject", nil, AuthPass},
+		{"rsa simple", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=rsa; h=From:To:Subject:Date", nil, AuthPass},
+		{"ed25519", edKey, "v=1; a=ed25519-sha256; c=relaxed/simple; d=football.example.com; s=ed; h=from:subject", nil, AuthPass},
+		{"refolded header, relaxed", rsaKey, "v=1; a=rsa-sha256; c=relaxed/relaxed; d=football.example.com; s=rsa; h=From:Subject", func(m string) string {
+			return strings.Replace(m, "Subject: Is dinner ready?", "Subject:  Is dinner\r\n ready?", 1)
+		}, AuthPass},
+		{"refolded header, simple", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=rsa; h=From:Subject", func(m string) string {
+			return strings.Replace(m, "Subject: Is dinner ready?", "Subject:  Is dinner\r\n ready?", 1)
+		}, AuthFail},
+		{"altered body", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=rsa; h=From", func(m string) string {
+			return strings.Replace(m, "lost", "won", 1)
+		}, AuthFail},
+		{"altered header", edKey, "v=1; a=ed25519-sha256; d=football.example.com; s=ed; h=From:Subject", func(m string) string {
+			return strings.Replace(m, "dinner", "lunch", 1)
+		}, AuthFail},
+		{"added header signed as absent", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=rsa; h=From:Subject:Subject", func(m string) string {
+			return strings.Replace(m, "\r\n\r\n", "\r\nSubject: Free money\r\n\r\n", 1)
+		}, AuthFail},
+		{"body length", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=rsa; h=From; l=10", func(m string) string {
+			return m + "Appended.\r\n"
+		}, AuthPass},
+		{"expired", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=rsa; h=From; t=1000000000; x=1000000100", nil, AuthPermError},
+		{"not yet expired", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=rsa; h=From; t=1000000000; x=1200000000", nil, AuthPass},
+		{"revoked key", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=revoked; h=From", nil, AuthPermError},
+		{"missing key", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=nokey; h=From", nil, AuthPermError},
+		{"wrong key type", rsaKey, "v=1; a=rsa-sha256; d=football.example.com; s=ed; h=From", nil, AuthPermError},
+	}
+	for _, tt := range tests {
+		msg := signTestMessage(t, dkimTestMessage, tt.key, tt.tags)
+		if tt.tamper != nil {
+			msg = tt.tamper(msg)
+		}
+		r, err := ParseRaw([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := v.Verify(context.Background(), r)
+		if len(results) != 1 {
+			t.Errorf("%s: got %d results", tt.name, len(results))
+			continue
+		}
+		if results[0].Status != tt.status {
+			t.Errorf("%s: got %s (%v); expected %s", tt.name, results[0].Status, results[0].Err, tt.status)
+		}
+	}
+}
+
+// rfc8463Message is the ed25519 signed example of RFC 8463 appendix A.3.
+const rfc8463Message = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+// TestDKIMVerifyRFC8463 checks a signature made by another implementation,
+// so that canonicalization is not only tested against itself.
+func TestDKIMVerifyRFC8463(t *testing.T) {
+	v := DKIMVerifier{Resolver: dkimKeyStore{
+		"brisbane._domainkey.football.example.com": "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=",
+	}}
+	for _, tt := range []struct {
+		msg    string
+		status AuthStatus
+	}{
+		{rfc8463Message, AuthPass},
+		// Relaxed canonicalization ignores the line endings and white space.
+		{strings.ReplaceAll(strings.Replace(rfc8463Message, "Subject: Is dinner", "Subject:   Is  dinner", 1), "\r\n", "\n"), AuthPass},
+		{strings.Replace(rfc8463Message, "hungry", "thirsty", 1), AuthFail},
+		{strings.Replace(rfc8463Message, "Suzie Q", "Suzie R", 1), AuthFail},
+	} {
+		r, err := ParseRaw([]byte(tt.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := v.Verify(context.Background(), r)
+		if len(results) != 1 || results[0].Status != tt.status {
+			t.Errorf("got %#v; expected %s for %q", results, tt.status, tt.msg)
+			continue
+		}
+		if sig := results[0].Signature; sig.Identity != "@football.example.com" || !sig.Timestamp.Equal(time.Unix(1528637909, 0)) {
+			t.Errorf("unexpected signature %#v", sig)
+		}
+	}
+}
+
+func TestParseDKIMSignature(t *testing.T) {
+	bad := []string{
+		"v=1; a=rsa-sha256; d=example.com; s=s; h=To; bh=; b=",
+		"v=2; a=rsa-sha256; d=example.com; s=s; h=From; bh=; b=",
+		"v=1; a=rsa-sha256; d=example.com; s=s; h=From; bh=; b=; i=joe@example.org",
+		"v=1; a=rsa-sha256; d=example.com; s=s; h=From; bh=; b=; d=example.org",
+		"v=1; a=rsa-sha256; d=example.com; s=s; h=From; bh=; b=; t=20; x=10",
+	}
+	for _, s := range bad {
+		if _, err := ParseDKIMSignature(s); err == nil {
+			t.Errorf("ParseDKIMSignature(%q) did not fail", s)
+		}
+	}
+	sig, err := ParseDKIMSignature("v=1; a=rsa-sha256; c=relaxed; d=Example.COM; s=s; h=From : To; i=joe@mail.example.com; bh=AAAA; b=AA\r\n\tAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.HeaderCanon != "relaxed" || sig.BodyCanon != "simple" || sig.Domain != "example.com" || len(sig.Headers) != 2 || sig.Headers[1] != "To" || len(sig.Signature) != 3 {
+		t.Errorf("unexpected signature %#v", sig)
+	}
+}
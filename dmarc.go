@@ -0,0 +1,257 @@
+// Evaluation of DMARC policies (RFC 7489).
+
+package eml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// AuthChecker evaluates SPF, DKIM and DMARC for received messages.
+type AuthChecker struct {
+	// Resolver performs all DNS lookups. If nil, net.DefaultResolver is
+	// used.
+	Resolver DNSResolver
+	// Now is passed on to DKIM verification, see DKIMVerifier.
+	Now func() time.Time
+}
+
+func (c AuthChecker) resolver() DNSResolver {
+	if c.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return c.Resolver
+}
+
+// DMARCPolicy is the handling a domain requests for failing messages.
+type DMARCPolicy string
+
+const (
+	DMARCNone       DMARCPolicy = "none"
+	DMARCQuarantine DMARCPolicy = "quarantine"
+	DMARCReject     DMARCPolicy = "reject"
+)
+
+// DMARCRecord is a parsed DMARC policy record.
+type DMARCRecord struct {
+	Policy          DMARCPolicy // p=
+	SubdomainPolicy DMARCPolicy // sp=, defaults to Policy
+	// Percent is the share of failing messages the policy applies to.
+	Percent int // pct=
+	// StrictDKIM and StrictSPF require exact domain matches for alignment
+	// (adkim=s, aspf=s) instead of organizational domain matches.
+	StrictDKIM bool
+	StrictSPF  bool
+	// AggregateReports and FailureReports are the report addresses
+	// (rua=, ruf=).
+	AggregateReports []string
+	FailureReports   []string
+}
+
+// DMARCResult is the outcome of a DMARC evaluation.
+type DMARCResult struct {
+	// Status is AuthPass if SPF or DKIM passed for an aligned domain,
+	// AuthFail if neither did, and AuthNone if the From domain publishes
+	// no policy.
+	Status AuthStatus
+	// Domain is the domain of the From address.
+	Domain string
+	// PolicyDomain is the domain the record was found at: Domain or its
+	// organizational domain.
+	PolicyDomain string
+	Record       *DMARCRecord
+	// Policy is the policy that applies to the message, taking the
+	// subdomain policy into account. Applying Record.Percent is left to
+	// the caller.
+	Policy      DMARCPolicy
+	DKIMAligned bool
+	SPFAligned  bool
+	Err         error
+}
+
+// AuthReport combines the results of CheckMessage.
+type AuthReport struct {
+	SPF   SPFResult
+	DKIM  []DKIMResult
+	DMARC DMARCResult
+}
+
+// CheckMessage evaluates SPF for the connecting ip, HELO name and envelope
+// sender, verifies the DKIM signatures of the message and evaluates the
+// DMARC policy of its From domain against both.
+func (c AuthChecker) CheckMessage(ctx context.Context, r RawMessage, ip net.IP, helo, mailFrom string) AuthReport {
+	var rep AuthReport
+	rep.SPF = c.CheckSPF(ctx, ip, helo, mailFrom)
+	rep.DKIM = DKIMVerifier{Resolver: c.resolver(), Now: c.Now}.Verify(ctx, r)
+	h := HeaderList{}
+	for _, rh := range r.RawHeaders {
+		h.Add(string(rh.Key), string(rh.Value))
+	}
+	rep.DMARC = c.CheckDMARC(ctx, h, rep.DKIM, rep.SPF)
+	return rep
+}
+
+// CheckDMARC evaluates the DMARC policy of the From domain of a message,
+// given the results of DKIM verification and the SPF check.
+func (c AuthChecker) CheckDMARC(ctx context.Context, h HeaderList, dkim []DKIMResult, spf SPFResult) DMARCResult {
+	var res DMARCResult
+	var boxes []MailboxAddr
+	for _, a := range h.From() {
+		boxes = append(boxes, mailboxes(a)...)
+	}
+	domains := map[string]bool{}
+	for _, b := range boxes {
+		domains[strings.ToLower(b.Domain())] = true
+	}
+	if len(domains) != 1 {
+		res.Status, res.Err = AuthPermError, fmt.Errorf("dmarc: need exactly one From domain, found %d", len(domains))
+		return res
+	}
+	for d := range domains {
+		res.Domain = strings.TrimSuffix(d, ".")
+	}
+
+	policyDomain := res.Domain
+	rec, err := c.dmarcRecord(ctx, policyDomain)
+	if org := organizationalDomain(res.Domain); rec == nil && err == nil && org != res.Domain {
+		policyDomain = org
+		rec, err = c.dmarcRecord(ctx, policyDomain)
+	}
+	if err != nil {
+		res.Status, res.Err = AuthTempError, err
+		return res
+	}
+	if rec == nil {
+		res.Status = AuthNone
+		return res
+	}
+	res.Record, res.PolicyDomain = rec, policyDomain
+	res.Policy = rec.Policy
+	if res.PolicyDomain != res.Domain {
+		res.Policy = rec.SubdomainPolicy
+	}
+
+	for _, d := range dkim {
+		if d.Status == AuthPass && d.Signature != nil && aligned(d.Signature.Domain, res.Domain, rec.StrictDKIM) {
+			res.DKIMAligned = true
+		}
+	}
+	res.SPFAligned = spf.Status == AuthPass && aligned(spf.Domain, res.Domain, rec.StrictSPF)
+	res.Status = AuthFail
+	if res.DKIMAligned || res.SPFAligned {
+		res.Status = AuthPass
+	}
+	return res
+}
+
+// organizationalDomain returns the registered domain of a domain according
+// to the public suffix list, or the domain itself if that cannot be
+// determined.
+func organizationalDomain(domain string) string {
+	org, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(domain))
+	if err != nil {
+		return strings.ToLower(domain)
+	}
+	return org
+}
+
+// aligned reports whether two domains are aligned, in strict mode if they
+// are equal and in relaxed mode if their organizational domains are.
+func aligned(a, b string, strict bool) bool {
+	a, b = strings.ToLower(strings.TrimSuffix(a, ".")), strings.ToLower(strings.TrimSuffix(b, "."))
+	if strict {
+		return a == b
+	}
+	return organizationalDomain(a) == organizationalDomain(b)
+}
+
+// dmarcRecord looks up the DMARC record of a domain. It returns nil
+// without error if there is no usable record.
+func (c AuthChecker) dmarcRecord(ctx context.Context, domain string) (*DMARCRecord, error) {
+	txts, err := c.resolver().LookupTXT(ctx, "_dmarc."+domain)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("dmarc: looking up _dmarc.%s: %w", domain, err)
+	}
+	var records []string
+	for _, t := range txts {
+		if strings.HasPrefix(strings.TrimSpace(t), "v=DMARC1") {
+			records = append(records, t)
+		}
+	}
+	// Several records are treated as none (RFC 7489 section 6.6.3).
+	if len(records) != 1 {
+		return nil, nil
+	}
+	rec, err := ParseDMARCRecord(records[0])
+	if err != nil {
+		return nil, nil
+	}
+	return rec, nil
+}
+
+// ParseDMARCRecord parses a DMARC policy record. An invalid p= is taken as
+// "none" if reports are requested, as RFC 7489 section 6.6.3 asks.
+func ParseDMARCRecord(s string) (*DMARCRecord, error) {
+	tags, err := parseTagList(s)
+	if err != nil {
+		return nil, err
+	}
+	if tags["v"] != "DMARC1" {
+		return nil, errors.New("dmarc: not a DMARC1 record")
+	}
+	rec := &DMARCRecord{Percent: 100}
+	if rua, ok := tags["rua"]; ok {
+		rec.AggregateReports = splitReportURIs(rua)
+	}
+	if ruf, ok := tags["ruf"]; ok {
+		rec.FailureReports = splitReportURIs(ruf)
+	}
+	policy := func(v string) (DMARCPolicy, bool) {
+		switch p := DMARCPolicy(strings.ToLower(v)); p {
+		case DMARCNone, DMARCQuarantine, DMARCReject:
+			return p, true
+		}
+		return "", false
+	}
+	var ok bool
+	if rec.Policy, ok = policy(tags["p"]); !ok {
+		if rec.AggregateReports == nil {
+			return nil, fmt.Errorf("dmarc: invalid policy %q", tags["p"])
+		}
+		rec.Policy = DMARCNone
+	}
+	rec.SubdomainPolicy = rec.Policy
+	if sp, present := tags["sp"]; present {
+		if p, ok := policy(sp); ok {
+			rec.SubdomainPolicy = p
+		}
+	}
+	if pct, present := tags["pct"]; present {
+		if n, err := strconv.Atoi(pct); err == nil && n >= 0 && n <= 100 {
+			rec.Percent = n
+		}
+	}
+	rec.StrictDKIM = strings.EqualFold(tags["adkim"], "s")
+	rec.StrictSPF = strings.EqualFold(tags["aspf"], "s")
+	return rec, nil
+}
+
+func splitReportURIs(s string) []string {
+	var uris []string
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			uris = append(uris, u)
+		}
+	}
+	return uris
+}
@@ -0,0 +1,133 @@
+package eml
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseDMARCRecord(t *testing.T) {
+	rec, err := ParseDMARCRecord("v=DMARC1; p=Reject; sp=none; pct=20; adkim=s; rua=mailto:a@example.com, mailto:b@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DMARCRecord{
+		Policy:           DMARCReject,
+		SubdomainPolicy:  DMARCNone,
+		Percent:          20,
+		StrictDKIM:       true,
+		AggregateReports: []string{"mailto:a@example.com", "mailto:b@example.com"},
+	}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("got %#v; expected %#v", rec, want)
+	}
+
+	rec, err = ParseDMARCRecord("v=DMARC1; p=bogus; rua=mailto:a@example.com")
+	if err != nil || rec.Policy != DMARCNone || rec.SubdomainPolicy != DMARCNone || rec.Percent != 100 {
+		t.Errorf("invalid policy with reports: got %#v, %v", rec, err)
+	}
+	for _, s := range []string{"v=DMARC1; p=bogus", "v=DMARC2; p=none", "p=none"} {
+		if _, err := ParseDMARCRecord(s); err == nil {
+			t.Errorf("ParseDMARCRecord(%q) did not fail", s)
+		}
+	}
+}
+
+var dmarcZone = dnsZone{
+	txt: map[string][]string{
+		"_dmarc.example.com":        {"v=DMARC1; p=reject; sp=quarantine"},
+		"_dmarc.strict.example.org": {"v=DMARC1; p=quarantine; adkim=s; aspf=s"},
+		"_dmarc.example.co.uk":      {"v=DMARC1; p=none"},
+		"_dmarc.twice.example.net":  {"v=DMARC1; p=reject", "v=DMARC1; p=none"},
+	},
+	fail: map[string]error{
+		"_dmarc.down.example.net": &net.DNSError{Err: "server misbehaving", Name: "_dmarc.down.example.net", IsTemporary: true},
+	},
+}
+
+type dmarcTest struct {
+	from         string
+	dkim         string // domain of a passing DKIM signature
+	spf          string // domain of a passing SPF check
+	status       AuthStatus
+	policy       DMARCPolicy
+	policyDomain string
+}
+
+var dmarcTests = []dmarcTest{
+	{"joe@example.com", "example.com", "", AuthPass, DMARCReject, "example.com"},
+	{"joe@example.com", "", "example.com", AuthPass, DMARCReject, "example.com"},
+	{"joe@example.com", "mail.example.com", "", AuthPass, DMARCReject, "example.com"},
+	{"joe@example.com", "example.net", "bounces.example.org", AuthFail, DMARCReject, "example.com"},
+	{"Joe <joe@news.Example.com>", "", "example.com", AuthPass, DMARCQuarantine, "example.com"},
+	{"joe@news.example.com", "", "", AuthFail, DMARCQuarantine, "example.com"},
+	{"joe@strict.example.org", "strict.example.org", "", AuthPass, DMARCQuarantine, "strict.example.org"},
+	{"joe@strict.example.org", "example.org", "mail.strict.example.org", AuthFail, DMARCQuarantine, "strict.example.org"},
+	// The organizational domain follows the public suffix list.
+	{"joe@shop.example.co.uk", "", "example.co.uk", AuthPass, DMARCNone, "example.co.uk"},
+	{"joe@shop.example.co.uk", "co.uk", "", AuthFail, DMARCNone, "example.co.uk"},
+	{"joe@example.net", "example.net", "", AuthNone, "", ""},
+	{"joe@twice.example.net", "", "", AuthNone, "", ""},
+	{"joe@down.example.net", "", "", AuthTempError, "", ""},
+	{"joe@example.com, jane@example.net", "example.com", "", AuthPermError, "", ""},
+}
+
+func TestCheckDMARC(t *testing.T) {
+	c := AuthChecker{Resolver: dmarcZone}
+	for _, tt := range dmarcTests {
+		h := HeaderList{}
+		h.Add("From", tt.from)
+		var dkim []DKIMResult
+		if tt.dkim != "" {
+			dkim = append(dkim,
+				DKIMResult{Signature: &DKIMSignature{Domain: "unrelated.example"}, Status: AuthPass},
+				DKIMResult{Signature: &DKIMSignature{Domain: tt.dkim}, Status: AuthPass})
+		}
+		spf := SPFResult{Status: AuthFail, Domain: tt.spf}
+		if tt.spf != "" {
+			spf.Status = AuthPass
+		}
+		res := c.CheckDMARC(context.Background(), h, dkim, spf)
+		if res.Status != tt.status || res.Policy != tt.policy || res.PolicyDomain != tt.policyDomain {
+			t.Errorf("From %s, DKIM %q, SPF %q: got %s (%v), policy %q at %q; expected %s, policy %q at %q",
+				tt.from, tt.dkim, tt.spf, res.Status, res.Err, res.Policy, res.PolicyDomain, tt.status, tt.policy, tt.policyDomain)
+		}
+	}
+
+	// A failing signature does not align.
+	h := HeaderList{}
+	h.Add("From", "joe@example.com")
+	res := c.CheckDMARC(context.Background(), h, []DKIMResult{{Signature: &DKIMSignature{Domain: "example.com"}, Status: AuthFail, Err: errors.New("bad signature")}}, SPFResult{})
+	if res.Status != AuthFail || res.DKIMAligned {
+		t.Errorf("failing signature: got %s, aligned %v", res.Status, res.DKIMAligned)
+	}
+}
+
+func TestCheckMessage(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	zone := dnsZone{
+		txt: map[string][]string{
+			"ed._domainkey.football.example.com": {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)},
+			"example.net":                        {"v=spf1 ip4:192.0.2.0/24 -all"},
+			"_dmarc.example.com":                 {"v=DMARC1; p=reject"},
+		},
+	}
+	msg := signTestMessage(t, dkimTestMessage, key, "v=1; a=ed25519-sha256; c=relaxed/relaxed; d=football.example.com; s=ed; h=From:Subject")
+	r, err := ParseRaw([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := AuthChecker{Resolver: zone}.CheckMessage(context.Background(), r, net.ParseIP("192.0.2.25"), "mail.example.net", "bounces@example.net")
+	if rep.SPF.Status != AuthPass || len(rep.DKIM) != 1 || rep.DKIM[0].Status != AuthPass {
+		t.Fatalf("got SPF %s (%v) and DKIM %+v", rep.SPF.Status, rep.SPF.Err, rep.DKIM)
+	}
+	d := rep.DMARC
+	if d.Status != AuthPass || !d.DKIMAligned || d.SPFAligned || d.Domain != "football.example.com" || d.Policy != DMARCReject {
+		t.Errorf("unexpected DMARC result %+v", d)
+	}
+}
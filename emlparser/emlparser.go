@@ -4,7 +4,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/Schidstorm/eml"
@@ -30,29 +31,57 @@ func main() {
 	m, err := eml.Parse(emlraw)
 	checkerr(err, "failed parse file")
 
-	dir := Filename[0 : len(Filename)-len(path.Ext(Filename))] //crop extension
+	dir := Filename[0 : len(Filename)-len(filepath.Ext(Filename))] //crop extension
 
 	err = os.MkdirAll(dir, 0755)
 	checkerr(err, "failed create directory for save data")
-
-	for _, attachment := range m.Attachments {
-		err = ioutil.WriteFile(path.Join(dir, attachment.Filename), attachment.Data, 0755)
-		checkerr(err, "failed save attachment "+attachment.Filename)
-	}
+	out := outputDir{path: dir, used: map[string]bool{}}
 
 	if len(m.Html) > 0 {
-		err = ioutil.WriteFile(path.Join(dir, "body.html"), []byte(m.Html), 0755)
+		err = out.write("body.html", []byte(m.Html))
 		checkerr(err, "failed save html body")
 	}
 	if len(m.Text) > 0 {
-		err = ioutil.WriteFile(path.Join(dir, "body.txt"), []byte(m.Text), 0755)
+		err = out.write("body.txt", []byte(m.Text))
 		checkerr(err, "failed save text body")
 	}
 
-	header := []string{m.FullHeaders.Date().String(), m.FullHeaders.Subject(), m.FullHeaders.From()[0].Email(), m.FullHeaders.To()[0].Email()}
-	err = ioutil.WriteFile(path.Join(dir, "header.txt"), []byte(strings.Join(header, "\n")), 0755)
+	header := []string{m.FullHeaders.Date().String(), m.FullHeaders.Subject()}
+	if from := m.FullHeaders.From(); len(from) > 0 {
+		header = append(header, from[0].Email())
+	}
+	if to := m.FullHeaders.To(); len(to) > 0 {
+		header = append(header, to[0].Email())
+	}
+	err = out.write("header.txt", []byte(strings.Join(header, "\n")))
 	checkerr(err, "failed save headers")
 
+	// Attachments come last, so that they cannot take the names above.
+	for _, attachment := range m.Attachments {
+		err = out.write(eml.SafeFilename(attachment.Filename), attachment.Data)
+		checkerr(err, "failed save attachment "+strconv.Quote(attachment.Filename))
+	}
+}
+
+// outputDir writes the files extracted from one message to a directory.
+type outputDir struct {
+	path string
+	// used holds the names written so far, in lower case for file systems
+	// that ignore case.
+	used map[string]bool
+}
+
+// write writes data to the file name in the directory. If a file of that
+// name was already written, " (1)", " (2)" and so on are inserted before
+// the extension. Files from earlier runs are overwritten.
+func (d *outputDir) write(name string, data []byte) error {
+	ext := filepath.Ext(name)
+	stem := name[:len(name)-len(ext)]
+	for i := 1; d.used[strings.ToLower(name)]; i++ {
+		name = stem + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	d.used[strings.ToLower(name)] = true
+	return ioutil.WriteFile(filepath.Join(d.path, name), data, 0644)
 }
 
 func checkerr(err error, msg string) {
@@ -0,0 +1,93 @@
+// Sanitizing of attachment file names.
+
+package eml
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxFilenameLength is the longest name SafeFilename returns, in bytes.
+	// It leaves room below the usual limit of 255 for suffixes that make
+	// names unique.
+	maxFilenameLength = 240
+	// maxExtensionLength is the longest extension kept when names are
+	// shortened.
+	maxExtensionLength = 16
+	// defaultFilename replaces names that are empty after sanitizing.
+	defaultFilename = "attachment"
+)
+
+// windowsReserved are the device names Windows reserves, with or without
+// extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SafeFilename turns the file name of an attachment into one that can be
+// created in a directory on any common file system without leaving it.
+// Directory components are dropped, both with slashes and backslashes.
+// Control and formatting characters, such as the right-to-left override,
+// are removed; characters Windows does not allow are replaced by '_', as
+// are invalid UTF-8 sequences. Leading dots, which would hide the file or
+// refer to a directory, and trailing dots and spaces are removed. Windows
+// device names such as "CON" or "nul.txt" get a '_' prepended. Long names
+// are shortened to 240 bytes, keeping the extension, which leaves room for
+// suffixes that make them unique. Names that end up empty become
+// "attachment".
+func SafeFilename(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(name, ". ")
+	name = strings.TrimRight(name, ". ")
+
+	stem := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		stem = name[:i]
+	}
+	if windowsReserved[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		name = "_" + name
+	}
+
+	if len(name) > maxFilenameLength {
+		ext := path.Ext(name)
+		if len(ext) > maxExtensionLength || strings.ContainsRune(ext, ' ') {
+			ext = ""
+		}
+		name = truncateUTF8(name[:len(name)-len(ext)], maxFilenameLength-len(ext))
+		name = strings.TrimRight(name, ". ") + ext
+	}
+	if name == "" {
+		return defaultFilename
+	}
+	return name
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,45 @@
+package eml
+
+import (
+	"strings"
+	"testing"
+)
+
+type safeFilenameTest struct {
+	name string
+	ret  string
+}
+
+var safeFilenameTests = []safeFilenameTest{
+	{"report.pdf", "report.pdf"},
+	{"Résumé 2024.docx", "Résumé 2024.docx"},
+	{"../../.bashrc", "bashrc"},
+	{"/etc/cron.d/x", "x"},
+	{`C:\Windows\System32\evil.dll`, "evil.dll"},
+	{`..\..\startup.bat`, "startup.bat"},
+	{"..", "attachment"},
+	{"dir/", "attachment"},
+	{"", "attachment"},
+	{"a\x00b\x1bc\r\nd.txt", "abcd.txt"},
+	{"invoice\u202Efdp.exe", "invoicefdp.exe"},
+	{"tab\there.txt", "tabhere.txt"},
+	{"no\u00a0break\u2028.txt", "no break .txt"},
+	{`what?<is>"this"|*:.txt`, "what__is__this____.txt"},
+	{"trailing. . ", "trailing"},
+	{"CON", "_CON"},
+	{"nul.txt", "_nul.txt"},
+	{"Com1 .tar.gz", "_Com1 .tar.gz"},
+	{"console.txt", "console.txt"},
+	{"bad\xffutf8.txt", "bad_utf8.txt"},
+	{strings.Repeat("a", 300) + ".txt", strings.Repeat("a", 236) + ".txt"},
+	{strings.Repeat("ä", 200) + ".txt", strings.Repeat("ä", 118) + ".txt"},
+	{strings.Repeat("a", 250) + "." + strings.Repeat("b", 20), strings.Repeat("a", 240)},
+}
+
+func TestSafeFilename(t *testing.T) {
+	for _, tt := range safeFilenameTests {
+		if ret := SafeFilename(tt.name); ret != tt.ret {
+			t.Errorf("SafeFilename(%q) = %q; expected %q", tt.name, ret, tt.ret)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Decoding and encoding of format=flowed text (RFC 3676).
+
+package eml
+
+import (
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultFlowedWidth is the line length EncodeFlowed wraps to when no width
+// is given, as recommended by RFC 3676 section 4.2.
+const DefaultFlowedWidth = 78
+
+// FlowedParagraph is a logical line of format=flowed text: the lines that
+// were joined by soft line breaks, along with their quote depth.
+type FlowedParagraph struct {
+	QuoteDepth int
+	Text       string
+}
+
+// isFlowed reports whether a Content-Type denotes format=flowed text, and
+// whether DelSp=yes is set.
+func isFlowed(contentType string) (flowed, delSp bool) {
+	mt, ps, err := mime.ParseMediaType(contentType)
+	if err != nil || mt != "text/plain" || !strings.EqualFold(ps["format"], "flowed") {
+		return false, false
+	}
+	return true, strings.EqualFold(ps["delsp"], "yes")
+}
+
+// Paragraphs returns the paragraphs of a format=flowed text part, or nil for
+// any other part.
+func (p Part) Paragraphs() []FlowedParagraph {
+	flowed, delSp := isFlowed(p.Type)
+	if !flowed {
+		return nil
+	}
+	return DecodeFlowed(string(p.Data), delSp)
+}
+
+// Text returns the text of the part. For format=flowed parts, the flowed
+// lines are unwrapped and space-stuffing is undone; a final line break is
+// kept.
+func (p Part) Text() string {
+	flowed, delSp := isFlowed(p.Type)
+	if !flowed {
+		return string(p.Data)
+	}
+	eol := "\n"
+	if strings.Contains(string(p.Data), "\r\n") {
+		eol = "\r\n"
+	}
+	text := FlowedText(DecodeFlowed(string(p.Data), delSp), eol)
+	if strings.HasSuffix(string(p.Data), "\n") {
+		text += eol
+	}
+	return text
+}
+
+// DecodeFlowed splits format=flowed text into paragraphs, as described in
+// RFC 3676 section 4.2. Lines ending in a space are joined with the next
+// line of the same quote depth; if delSp is set, that space is removed.
+// Quote markers and space-stuffing are removed from the text.
+func DecodeFlowed(text string, delSp bool) []FlowedParagraph {
+	var (
+		paras []FlowedParagraph
+		cur   *FlowedParagraph
+	)
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for _, line := range strings.Split(text, "\n") {
+		depth := 0
+		for depth < len(line) && line[depth] == '>' {
+			depth++
+		}
+		line = line[depth:]
+		line = strings.TrimPrefix(line, " ")
+
+		// A quote depth change ends a paragraph even if the previous line
+		// was flowed.
+		if cur != nil && cur.QuoteDepth != depth {
+			cur = nil
+		}
+		if cur == nil {
+			paras = append(paras, FlowedParagraph{QuoteDepth: depth})
+			cur = &paras[len(paras)-1]
+		}
+
+		soft := strings.HasSuffix(line, " ") && line != "-- "
+		if soft && delSp {
+			line = line[:len(line)-1]
+		}
+		cur.Text += line
+		if !soft {
+			cur = nil
+		}
+	}
+	return paras
+}
+
+// FlowedText renders paragraphs as plain text, one line per paragraph, with
+// a "> " prefix per quote level, separated by eol.
+func FlowedText(paras []FlowedParagraph, eol string) string {
+	lines := make([]string, len(paras))
+	for i, p := range paras {
+		lines[i] = p.Text
+		if p.QuoteDepth > 0 {
+			lines[i] = strings.Repeat(">", p.QuoteDepth) + " " + p.Text
+		}
+	}
+	return strings.Join(lines, eol)
+}
+
+// EncodeFlowed renders paragraphs as format=flowed text with CRLF line
+// endings, wrapping them at spaces so that lines do not exceed width
+// (DefaultFlowedWidth if zero). With delSp, which must then be announced
+// as DelSp=yes, words longer than a line are broken as well. Lines are
+// space-stuffed where required and trailing spaces before hard breaks are
+// removed.
+func EncodeFlowed(paras []FlowedParagraph, width int, delSp bool) string {
+	if width <= 0 {
+		width = DefaultFlowedWidth
+	}
+	var b strings.Builder
+	for _, p := range paras {
+		prefix := ""
+		if p.QuoteDepth > 0 {
+			prefix = strings.Repeat(">", p.QuoteDepth) + " "
+		}
+		text := p.Text
+		if text != "-- " {
+			text = strings.TrimRight(text, " ")
+		}
+		avail := width - len(prefix) - 1
+		if avail < 1 {
+			avail = 1
+		}
+		for {
+			line, rest := text, ""
+			if len(text) > avail && text != "-- " {
+				line, rest = breakFlowed(text, avail, delSp)
+			}
+			if prefix == "" && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, ">") || strings.HasPrefix(line, "From ")) {
+				line = " " + line
+			}
+			b.WriteString(prefix)
+			b.WriteString(line)
+			if rest == "" {
+				b.WriteString("\r\n")
+				break
+			}
+			if delSp {
+				b.WriteByte(' ')
+			}
+			b.WriteString("\r\n")
+			text = rest
+		}
+	}
+	return b.String()
+}
+
+// breakFlowed splits text into a line of at most avail bytes (where
+// possible) and the rest. Without delSp the line ends in the space it was
+// broken at, which marks it as flowed.
+func breakFlowed(text string, avail int, delSp bool) (string, string) {
+	if i := strings.LastIndexByte(text[:avail+1], ' '); i > 0 {
+		return text[:i+1], text[i+1:]
+	}
+	if delSp {
+		i := avail
+		for i > 0 && !utf8.RuneStart(text[i]) {
+			i--
+		}
+		if i > 0 {
+			return text[:i], text[i:]
+		}
+	}
+	if i := strings.IndexByte(text, ' '); i >= 0 {
+		return text[:i+1], text[i+1:]
+	}
+	return text, ""
+}
+
+// FormatFlowed encodes plain text as format=flowed, treating every line as
+// a paragraph whose quote depth is given by its leading '>' characters.
+func FormatFlowed(text string, width int, delSp bool) string {
+	var paras []FlowedParagraph
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for _, line := range strings.Split(text, "\n") {
+		depth := 0
+		for depth < len(line) && line[depth] == '>' {
+			depth++
+		}
+		if depth > 0 {
+			line = strings.TrimPrefix(line[depth:], " ")
+		}
+		paras = append(paras, FlowedParagraph{QuoteDepth: depth, Text: line})
+	}
+	return EncodeFlowed(paras, width, delSp)
+}
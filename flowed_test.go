@@ -0,0 +1,122 @@
+package eml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type decodeFlowedTest struct {
+	text  string
+	delSp bool
+	paras []FlowedParagraph
+}
+
+var decodeFlowedTests = []decodeFlowedTest{
+	{
+		"This is a long \r\nparagraph.\r\n\r\nSecond.\r\n",
+		false,
+		[]FlowedParagraph{{0, "This is a long paragraph."}, {0, ""}, {0, "Second."}},
+	},
+	{
+		"With DelSp the \r\nspace goes.\r\n",
+		true,
+		[]FlowedParagraph{{0, "With DelSp thespace goes."}},
+	},
+	{
+		"> Quoted and \r\n> flowed.\r\n>> Deeper \r\n> back\r\n",
+		false,
+		[]FlowedParagraph{{1, "Quoted and flowed."}, {2, "Deeper "}, {1, "back"}},
+	},
+	{
+		" >not a quote\r\n From here\r\n",
+		false,
+		[]FlowedParagraph{{0, ">not a quote"}, {0, "From here"}},
+	},
+	{
+		"text\r\n-- \r\nsig \r\nline\r\n",
+		false,
+		[]FlowedParagraph{{0, "text"}, {0, "-- "}, {0, "sig line"}},
+	},
+}
+
+func TestDecodeFlowed(t *testing.T) {
+	for _, ft := range decodeFlowedTests {
+		paras := DecodeFlowed(ft.text, ft.delSp)
+		if !reflect.DeepEqual(paras, ft.paras) {
+			t.Errorf("DecodeFlowed(%q) gave %#v; expected %#v", ft.text, paras, ft.paras)
+		}
+	}
+}
+
+func TestEncodeFlowed(t *testing.T) {
+	paras := []FlowedParagraph{
+		{0, strings.Repeat("lorem ipsum ", 20) + "end"},
+		{0, ">not a quote"},
+		{2, "a quoted " + strings.Repeat("x", 100) + " line"},
+		{0, "trailing   "},
+		{0, "-- "},
+		{0, "日本語のテキスト日本語のテキスト日本語のテキスト日本語のテキスト"},
+	}
+	for _, delSp := range []bool{false, true} {
+		enc := EncodeFlowed(paras, 40, delSp)
+		for _, line := range strings.Split(strings.TrimSuffix(enc, "\r\n"), "\r\n") {
+			if len(line) > 40 && !strings.Contains(line, "xxx") && !(strings.Contains(line, "日本") && !delSp) {
+				t.Errorf("line %q is longer than 40", line)
+			}
+		}
+		expected := append([]FlowedParagraph{}, paras...)
+		expected[3].Text = "trailing"
+		if dec := DecodeFlowed(enc, delSp); !reflect.DeepEqual(dec, expected) {
+			t.Errorf("round trip with delSp=%v gave %#v; expected %#v", delSp, dec, expected)
+		}
+	}
+}
+
+func TestFormatFlowed(t *testing.T) {
+	enc := FormatFlowed("Hello there, this is long\n> quoted reply\nFrom me\n", 20, false)
+	expected := "Hello there, this \r\nis long\r\n> quoted reply\r\n From me\r\n"
+	if enc != expected {
+		t.Errorf("FormatFlowed gave %q; expected %q", enc, expected)
+	}
+}
+
+func TestParseFlowed(t *testing.T) {
+	msg := crlf(`Content-Type: text/plain; format=flowed; delsp=yes
+
+Hello, this is a wrapped li 
+ne.
+> quoted
+`)
+	m, err := Parse(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != "Hello, this is a wrapped line.\r\n> quoted\r\n" {
+		t.Errorf("unexpected text %q", m.Text)
+	}
+	if paras := m.Parts[0].Paragraphs(); len(paras) != 2 || paras[1].QuoteDepth != 1 {
+		t.Errorf("unexpected paragraphs %#v", paras)
+	}
+}
+
+type partTextTest struct {
+	data string
+	text string
+}
+
+var partTextTests = []partTextTest{
+	{"wrapped \nline\n", "wrapped line\n"},
+	{"wrapped \r\nline\r\n", "wrapped line\r\n"},
+	{"wrapped \nline", "wrapped line"},
+	{"first\n\nsecond \nline\n", "first\n\nsecond line\n"},
+}
+
+func TestPartTextFlowed(t *testing.T) {
+	for _, tt := range partTextTests {
+		p := Part{Type: "text/plain; format=flowed", Data: []byte(tt.data)}
+		if text := p.Text(); text != tt.text {
+			t.Errorf("Text of %q = %q; expected %q", tt.data, text, tt.text)
+		}
+	}
+}
@@ -2,4 +2,7 @@ module github.com/Schidstorm/eml
 
 go 1.18
 
-require github.com/paulrosania/go-charset v0.0.0-20190326053356-55c9d7a5834c
+require (
+	golang.org/x/net v0.19.0
+	golang.org/x/text v0.14.0
+)
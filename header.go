@@ -2,6 +2,8 @@
 
 package eml
 
+import "bytes"
+
 func split(ts []token, s token) [][]token {
 	r, l := [][]token{}, 0
 	for i, t := range ts {
@@ -33,3 +35,100 @@ func parseAddressList(s []byte) ([]Address, error) {
 	}
 	return al, nil
 }
+
+// ParseAddressListLenient parses an address list the way parseAddressList
+// does, but does not give up on the first malformed entry. It returns every
+// address that could be parsed together with the raw text of the entries
+// that could not.
+func ParseAddressListLenient(s []byte) (al []Address, invalid []string) {
+	al = []Address{}
+	for _, f := range splitAddressList(s) {
+		f = bytes.TrimSpace(f)
+		if len(f) == 0 {
+			continue
+		}
+		ts, err := tokenize(f)
+		if err == nil {
+			var a Address
+			if a, err = parseAddress(ts); err == nil {
+				al = append(al, a)
+				continue
+			}
+		}
+		invalid = append(invalid, string(f))
+	}
+	return
+}
+
+// splitAddressList splits an address list on the commas that separate its
+// entries. Commas inside quoted strings, comments, angle addresses, domain
+// literals and groups are skipped. If the input ends inside one of those, the
+// unterminated remainder is split on every comma, so that a single broken
+// entry does not swallow the ones after it.
+func splitAddressList(s []byte) [][]byte {
+	var (
+		fs                      [][]byte
+		start                   int
+		quoted, group           bool
+		comment, angle, literal int
+		opened                  = map[byte]int{}
+	)
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		switch {
+		case b == '\\' && (quoted || comment > 0):
+			i++
+		case quoted:
+			quoted = b != '"'
+		case comment > 0:
+			if b == '(' {
+				comment++
+			} else if b == ')' {
+				comment--
+			}
+		case literal > 0:
+			if b == ']' {
+				literal = 0
+			}
+		default:
+			switch b {
+			case '"':
+				quoted, opened[b] = true, i
+			case '(':
+				comment, opened[b] = 1, i
+			case '[':
+				literal, opened[b] = 1, i
+			case '<':
+				angle, opened[b] = 1, i
+			case '>':
+				angle = 0
+			case ':':
+				if angle == 0 && !group {
+					group, opened[b] = true, i
+				}
+			case ';':
+				group = false
+			case ',':
+				if angle == 0 && !group {
+					fs = append(fs, s[start:i])
+					start = i + 1
+				}
+			}
+		}
+	}
+
+	open := len(s)
+	for b, unterminated := range map[byte]bool{'"': quoted, '(': comment > 0, '[': literal > 0, '<': angle > 0, ':': group} {
+		if unterminated && opened[b] < open {
+			open = opened[b]
+		}
+	}
+	if open == len(s) {
+		return append(fs, s[start:])
+	}
+	// Keep the text before the unterminated construct intact and fall back
+	// to splitting naively from there.
+	naive := bytes.Split(s[open:], []byte{','})
+	naive[0] = s[start : open+len(naive[0])]
+	return append(fs, naive...)
+}
@@ -148,6 +148,21 @@ func (h HeaderList) Bcc() []Address {
 	return nil
 }
 
+// AddressListLenient parses every instance of the address header key, such
+// as "To" or "Cc", with ParseAddressListLenient. Unlike To and Cc, a single
+// malformed entry does not cause the whole list to be dropped; the entries
+// that could not be parsed are returned as raw text instead.
+func (h HeaderList) AddressListLenient(key string) ([]Address, []string) {
+	var addrs []Address
+	var invalid []string
+	for _, header := range h[key] {
+		al, bad := ParseAddressListLenient([]byte(header))
+		addrs = append(addrs, al...)
+		invalid = append(invalid, bad...)
+	}
+	return addrs, invalid
+}
+
 func (h HeaderList) Subject() string {
 	if header, ok := h.FirstByKey("Subject"); ok {
 		subject, err := decoder.Parse([]byte(header))
@@ -42,3 +42,64 @@ func TestParseAddressList(t *testing.T) {
 		}
 	}
 }
+
+type parseAddressListLenientTest struct {
+	ins     string
+	out     []Address
+	invalid []string
+}
+
+var parseAddressListLenientTests = []parseAddressListLenientTest{
+	{
+		`a@x.com, "Bob" <bob@y, c@z.com`,
+		[]Address{
+			MailboxAddr{``, `a`, `x.com`},
+			MailboxAddr{``, `c`, `z.com`},
+		},
+		[]string{`"Bob" <bob@y`},
+	},
+	{
+		`"Smith, John" <john@x.test>,, broken, <boss@nil.test>`,
+		[]Address{
+			MailboxAddr{`"Smith, John"`, `john`, `x.test`},
+			MailboxAddr{``, `boss`, `nil.test`},
+		},
+		[]string{`broken`},
+	},
+	{
+		`A Group:Ed Jones <c@a.test>,joe@where.test;, Unterminated <u@x.test, d@y.test`,
+		[]Address{
+			GroupAddr{
+				`A Group`,
+				[]MailboxAddr{
+					{`Ed Jones`, `c`, `a.test`},
+					{``, `joe`, `where.test`},
+				},
+			},
+			MailboxAddr{``, `d`, `y.test`},
+		},
+		[]string{`Unterminated <u@x.test`},
+	},
+}
+
+func TestParseAddressListLenient(t *testing.T) {
+	for _, pt := range parseAddressListLenientTests {
+		o, invalid := ParseAddressListLenient([]byte(pt.ins))
+		if !reflect.DeepEqual(o, pt.out) || !reflect.DeepEqual(invalid, pt.invalid) {
+			t.Errorf(
+				"ParseAddressListLenient: incorrect result for %#v: %#v, %#v vs. %#v, %#v",
+				pt.ins, o, invalid, pt.out, pt.invalid)
+		}
+	}
+}
+
+func TestAddressListLenient(t *testing.T) {
+	h := HeaderList{"To": {`a@x.com, "Bob" <bob@y`, `c@z.com`}}
+	if h.To() != nil {
+		t.Errorf("To: expected nil for malformed header")
+	}
+	o, invalid := h.AddressListLenient("To")
+	if len(o) != 2 || len(invalid) != 1 {
+		t.Errorf("AddressListLenient: got %#v, %#v", o, invalid)
+	}
+}
@@ -0,0 +1,116 @@
+// Safe construction and serialization of header fields.
+
+package eml
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// HeaderError describes why a header field cannot be written without
+// changing the structure of the message, such as a value whose line breaks
+// would start a new header field.
+type HeaderError struct {
+	Name string
+	// Pos is the byte offset of the problem in the value, or -1 if the
+	// name is at fault.
+	Pos    int
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	if e.Pos < 0 {
+		return fmt.Sprintf("invalid header field name %q: %s", e.Name, e.Reason)
+	}
+	return fmt.Sprintf("invalid value of header field %q at offset %d: %s", e.Name, e.Pos, e.Reason)
+}
+
+// ValidateHeaderName checks that name is a field name as RFC 5322 section
+// 2.2 defines it: one or more printable US-ASCII characters other than
+// colon.
+func ValidateHeaderName(name string) error {
+	if name == "" {
+		return &HeaderError{name, -1, "empty"}
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 33 || c > 126 || c == ':' {
+			return &HeaderError{name, -1, fmt.Sprintf("character %q not allowed", c)}
+		}
+	}
+	return nil
+}
+
+// ValidateHeaderValue checks that value can follow "name:" in a header
+// field. Line breaks are only allowed as CRLF followed by a space or tab,
+// which folds the field; CR and LF on their own, NUL and a line break at
+// the end are rejected.
+func ValidateHeaderValue(name, value string) error {
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case 0:
+			return &HeaderError{name, i, "NUL character"}
+		case '\n':
+			return &HeaderError{name, i, "bare LF"}
+		case '\r':
+			if i+1 >= len(value) || value[i+1] != '\n' {
+				return &HeaderError{name, i, "bare CR"}
+			}
+			if i+2 >= len(value) || !isWSP(value[i+2]) {
+				return &HeaderError{name, i, "line break not followed by white space"}
+			}
+			i++
+		}
+	}
+	return nil
+}
+
+// NewRawHeader builds a header field to add to a RawMessage. The name is
+// validated with ValidateHeaderName and the value, which must be encoded
+// and folded already, with ValidateHeaderValue.
+func NewRawHeader(name, value string) (RawHeader, error) {
+	if err := ValidateHeaderName(name); err != nil {
+		return RawHeader{}, err
+	}
+	if err := ValidateHeaderValue(name, value); err != nil {
+		return RawHeader{}, err
+	}
+	raw := name + ": " + value
+	return RawHeader{
+		Key:   []byte(name),
+		Value: []byte(strings.ReplaceAll(value, "\r\n", "")),
+		Raw:   []byte(raw),
+	}, nil
+}
+
+// Bytes serializes the message: its header fields as given by their Raw
+// form, or by key and value for fields without one, each followed by CRLF,
+// an empty line and the body. Line breaks are written as CRLF throughout,
+// in the header fields as well as in the body. Bytes fails with a *HeaderError if a field would not be read back
+// as a single field with the same name.
+func (r RawMessage) Bytes() ([]byte, error) {
+	var b bytes.Buffer
+	for _, h := range r.RawHeaders {
+		raw := h.Raw
+		if raw == nil {
+			raw = []byte(string(h.Key) + ": " + string(h.Value))
+		}
+		raw = crlfLines(raw)
+		name, value, ok := bytes.Cut(raw, []byte(":"))
+		if !ok {
+			return nil, &HeaderError{string(raw), -1, "missing colon"}
+		}
+		// The obsolete syntax allows white space before the colon.
+		if err := ValidateHeaderName(string(bytes.TrimRight(name, " \t"))); err != nil {
+			return nil, err
+		}
+		if err := ValidateHeaderValue(string(name), string(value)); err != nil {
+			return nil, err
+		}
+		b.Write(raw)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(crlfLines(r.Body))
+	return b.Bytes(), nil
+}
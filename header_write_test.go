@@ -0,0 +1,71 @@
+package eml
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type headerFieldTest struct {
+	name  string
+	value string
+	pos   int // of the error, or -2 if there is none
+}
+
+var headerFieldTests = []headerFieldTest{
+	{"Subject", "Is dinner ready?", -2},
+	{"Subject", "Is dinner\r\n ready?", -2},
+	{"X-Custom.Header_1", "\r\n\tfolded at the start", -2},
+	{"Subject", "Hi\r\nBcc: eve@example.org", 2},
+	{"Subject", "Hi\nBcc: eve@example.org", 2},
+	{"Subject", "Hi\rBcc: eve@example.org", 2},
+	{"Subject", "Hi\x00", 2},
+	{"Subject", "Hi\r\n", 2},
+	{"Subject", "Hi\r\n\r\n body", 2},
+	{"", "x", -1},
+	{"Sub ject", "x", -1},
+	{"Subject:", "x", -1},
+	{"Subjéct", "x", -1},
+	{"Bcc: eve@example.org\r\nSubject", "x", -1},
+}
+
+func TestNewRawHeader(t *testing.T) {
+	for _, tt := range headerFieldTests {
+		h, err := NewRawHeader(tt.name, tt.value)
+		if tt.pos == -2 {
+			if err != nil {
+				t.Errorf("NewRawHeader(%q, %q): %v", tt.name, tt.value, err)
+			} else if r, err := ParseRaw(append(h.Raw, "\r\n\r\n"...)); err != nil || len(r.RawHeaders) != 1 || !reflect.DeepEqual(r.RawHeaders[0], h) {
+				t.Errorf("NewRawHeader(%q, %q) = %+v, parsed back as %+v", tt.name, tt.value, h, r.RawHeaders)
+			}
+			continue
+		}
+		var herr *HeaderError
+		if !errors.As(err, &herr) || herr.Pos != tt.pos || herr.Name != tt.name {
+			t.Errorf("NewRawHeader(%q, %q): got error %v; expected one at %d", tt.name, tt.value, err, tt.pos)
+		}
+	}
+}
+
+func TestRawMessageBytes(t *testing.T) {
+	msg := "From: Joe <joe@football.example.com>\nSubject : Is dinner\n ready?\n\nHi.\nBye.\r\n"
+	r, err := ParseRaw([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.Bytes()
+	if err != nil || string(b) != strings.ReplaceAll(strings.ReplaceAll(msg, "\r\n", "\n"), "\n", "\r\n") {
+		t.Errorf("got %q, %v", b, err)
+	}
+
+	// Fields built by hand are checked as well.
+	r.RawHeaders = append(r.RawHeaders, RawHeader{Key: []byte("To"), Value: []byte("suzie@example.net\nBcc: eve@example.org")})
+	if _, err := r.Bytes(); err == nil {
+		t.Errorf("injected header field not detected")
+	}
+	r.RawHeaders[2] = RawHeader{Key: []byte("To"), Value: []byte("suzie@example.net")}
+	if b, err := r.Bytes(); err != nil || !strings.Contains(string(b), "\r\nTo: suzie@example.net\r\n\r\n") {
+		t.Errorf("got %q, %v", b, err)
+	}
+}
@@ -0,0 +1,389 @@
+// Conversion of HTML bodies to plain text.
+
+package eml
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLToText renders an HTML document as readable plain text. Block
+// elements start new lines, paragraphs and headings are separated by blank
+// lines, list items are marked with "*" or their number, block quotes are
+// prefixed with "> " and tables are laid out in aligned columns. Links are
+// followed by a "[n]" reference to a list of their targets at the end of
+// the text. The contents of script and style elements are dropped.
+// Character references are decoded. Lines end in "\n".
+func HTMLToText(s string) string {
+	doc, err := html.ParseWithOptions(strings.NewReader(s), html.ParseOptionEnableScripting(false))
+	if err != nil {
+		// The parser only fails on read errors, which strings.Reader does
+		// not produce.
+		return s
+	}
+	return nodeText(doc)
+}
+
+// nodeText renders an HTML node as described for HTMLToText.
+func nodeText(n *html.Node) string {
+	var links []string
+	r := &textRenderer{links: &links}
+	r.node(n)
+	text := r.String()
+	if len(links) > 0 {
+		var b strings.Builder
+		b.WriteString(text)
+		if text != "" {
+			b.WriteString("\n\n")
+		}
+		for i, l := range links {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(&b, "[%d] %s", i+1, l)
+		}
+		text = b.String()
+	}
+	return text
+}
+
+// textRenderer accumulates the text of an HTML tree. Line breaks are not
+// written until the next text, so that runs of block boundaries collapse.
+type textRenderer struct {
+	b        strings.Builder
+	prefix   []string // per nesting level: "> " for quotes, indentation for lists
+	marker   string   // list marker replacing the innermost prefix on the next line
+	newlines int      // line breaks to write before the next text
+	line     bool     // whether the current line has text
+	space    bool     // whether white space precedes the next text
+	pre      int      // depth of pre elements
+	last     string   // prefix of the last line written
+	links    *[]string
+}
+
+func (r *textRenderer) String() string {
+	return strings.TrimRight(r.b.String(), " \n")
+}
+
+// block requests n line breaks before the next text: 1 to start a new line
+// and 2 to leave a blank line.
+func (r *textRenderer) block(n int) {
+	if n > r.newlines {
+		r.newlines = n
+	}
+	r.space = false
+}
+
+// emit writes s, which does not contain line breaks, preceded by any
+// pending line breaks, line prefix or space.
+func (r *textRenderer) emit(s string) {
+	if r.newlines > 0 && r.b.Len() > 0 {
+		// Blank lines only carry the prefixes shared by the lines around
+		// them.
+		blank, cur := r.last, strings.Join(r.prefix, "")
+		for !strings.HasPrefix(cur, blank) {
+			blank = blank[:len(blank)-1]
+		}
+		r.b.WriteByte('\n')
+		for i := 1; i < r.newlines; i++ {
+			r.b.WriteString(strings.TrimRight(blank, " "))
+			r.b.WriteByte('\n')
+		}
+		r.line = false
+	}
+	r.newlines = 0
+	if !r.line {
+		r.last = strings.Join(r.prefix, "")
+		if r.marker != "" && len(r.prefix) > 0 {
+			r.b.WriteString(strings.Join(r.prefix[:len(r.prefix)-1], ""))
+			r.b.WriteString(r.marker)
+		} else {
+			r.b.WriteString(r.last)
+		}
+		r.marker = ""
+		r.line = true
+	} else if r.space {
+		r.b.WriteByte(' ')
+	}
+	r.space = false
+	r.b.WriteString(s)
+}
+
+// text writes the contents of a text node, collapsing white space outside
+// pre elements.
+func (r *textRenderer) text(s string) {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if r.pre > 0 {
+		for i, l := range strings.Split(s, "\n") {
+			if i > 0 {
+				r.newlines++
+				r.line = false
+			}
+			if l != "" {
+				r.emit(strings.ReplaceAll(l, "\u00a0", " "))
+			}
+		}
+		return
+	}
+	start := -1
+	for i, c := range s {
+		if unicode.IsSpace(c) && c != '\u00a0' {
+			if start >= 0 {
+				r.emit(strings.ReplaceAll(s[start:i], "\u00a0", " "))
+				start = -1
+			}
+			r.space = true
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		r.emit(strings.ReplaceAll(s[start:], "\u00a0", " "))
+	}
+}
+
+func (r *textRenderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.node(c)
+	}
+}
+
+func (r *textRenderer) push(prefix string) {
+	r.prefix = append(r.prefix, prefix)
+}
+
+func (r *textRenderer) pop() {
+	r.prefix = r.prefix[:len(r.prefix)-1]
+}
+
+func (r *textRenderer) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		r.text(n.Data)
+		return
+	case html.DocumentNode:
+		r.children(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Head, atom.Template:
+	case atom.Br:
+		r.newlines++
+		r.line = false
+		r.space = false
+	case atom.Hr:
+		r.block(2)
+		r.emit("----------")
+		r.block(2)
+	case atom.Img:
+		if alt := attr(n, "alt"); alt != "" {
+			r.text(alt)
+		}
+	case atom.A:
+		r.link(n)
+	case atom.P, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Dl:
+		r.block(2)
+		r.children(n)
+		r.block(2)
+	case atom.Pre:
+		r.block(2)
+		r.pre++
+		r.children(n)
+		r.pre--
+		r.block(2)
+	case atom.Blockquote:
+		r.block(2)
+		r.push("> ")
+		r.children(n)
+		r.block(2)
+		r.pop()
+	case atom.Ul, atom.Ol:
+		r.list(n)
+	case atom.Li:
+		// A list item outside of a list.
+		r.item(n, "* ")
+	case atom.Dd:
+		r.block(1)
+		r.push("    ")
+		r.children(n)
+		r.block(1)
+		r.pop()
+	case atom.Table:
+		r.table(n)
+	case atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Nav,
+		atom.Aside, atom.Main, atom.Address, atom.Figure, atom.Figcaption,
+		atom.Form, atom.Fieldset, atom.Center, atom.Dt, atom.Tr, atom.Caption,
+		atom.Details, atom.Summary:
+		r.block(1)
+		r.children(n)
+		r.block(1)
+	case atom.Td, atom.Th:
+		r.space = true
+		r.children(n)
+		r.space = true
+	default:
+		r.children(n)
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// link writes the text of an anchor followed by a reference to its target.
+// Targets that are fragments or scripts, or that repeat the text, are not
+// referenced.
+func (r *textRenderer) link(n *html.Node) {
+	start := r.b.Len()
+	r.children(n)
+	href := strings.TrimSpace(attr(n, "href"))
+	lower := strings.ToLower(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(lower, "javascript:") {
+		return
+	}
+	text := strings.TrimSpace(r.b.String()[start:])
+	if text == href || "mailto:"+text == lower || strings.TrimSuffix(href, "/") == text {
+		return
+	}
+	num := 0
+	for i, l := range *r.links {
+		if l == href {
+			num = i + 1
+		}
+	}
+	if num == 0 {
+		*r.links = append(*r.links, href)
+		num = len(*r.links)
+	}
+	r.space = r.b.Len() > start
+	r.emit(fmt.Sprintf("[%d]", num))
+}
+
+// list writes the items of a ul or ol element.
+func (r *textRenderer) list(n *html.Node) {
+	r.block(1)
+	if len(r.prefix) == 0 {
+		r.block(2)
+	}
+	num := 1
+	if n.DataAtom == atom.Ol {
+		fmt.Sscanf(attr(n, "start"), "%d", &num)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			r.node(c)
+			continue
+		}
+		marker := "* "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", num)
+			num++
+		}
+		r.item(c, marker)
+	}
+	r.block(1)
+	if len(r.prefix) == 0 {
+		r.block(2)
+	}
+}
+
+func (r *textRenderer) item(n *html.Node, marker string) {
+	r.block(1)
+	r.push(strings.Repeat(" ", len(marker)))
+	r.marker = marker
+	r.children(n)
+	r.block(1)
+	r.marker = ""
+	r.pop()
+}
+
+// table lays out the rows of a table in columns that are as wide as their
+// widest cell, separated by two spaces. Cells are rendered on their own,
+// so that they may span several lines.
+func (r *textRenderer) table(n *html.Node) {
+	var rows [][][]string
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				collect(c)
+			case atom.Tr:
+				var row [][]string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type != html.ElementNode || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+						continue
+					}
+					sub := &textRenderer{links: r.links}
+					sub.children(cell)
+					row = append(row, strings.Split(sub.String(), "\n"))
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	collect(n)
+
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			for _, l := range cell {
+				if w := utf8.RuneCountInString(l); w > widths[i] {
+					widths[i] = w
+				}
+			}
+		}
+	}
+
+	r.block(2)
+	for _, row := range rows {
+		height := 0
+		for _, cell := range row {
+			if len(cell) > height {
+				height = len(cell)
+			}
+		}
+		for l := 0; l < height; l++ {
+			var b strings.Builder
+			for i, cell := range row {
+				if widths[i] == 0 {
+					continue
+				}
+				s := ""
+				if l < len(cell) {
+					s = cell[l]
+				}
+				if b.Len() > 0 {
+					b.WriteString("  ")
+				}
+				b.WriteString(s)
+				b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(s)))
+			}
+			if line := strings.TrimRight(b.String(), " "); line != "" {
+				r.block(1)
+				r.emit(line)
+			}
+		}
+	}
+	r.block(2)
+}
@@ -0,0 +1,55 @@
+package eml
+
+import "testing"
+
+type htmlTextTest struct {
+	html string
+	text string
+}
+
+var htmlTextTests = []htmlTextTest{
+	{
+		"<p>Hello,   <b>world</b>!</p><p>Second&nbsp;paragraph &amp; more.</p>",
+		"Hello, world!\n\nSecond paragraph & more.",
+	},
+	{
+		"<head><title>T</title><style>p {}</style></head><body><script>alert(1)</script>Text</body>",
+		"Text",
+	},
+	{
+		"line one<br>line two<br><br>line four",
+		"line one\nline two\n\nline four",
+	},
+	{
+		`See <a href="https://example.com/a">the docs</a> and <a href="https://example.com/a">again</a> or <a href="https://example.com">https://example.com</a>.`,
+		"See the docs [1] and again [1] or https://example.com.\n\n[1] https://example.com/a",
+	},
+	{
+		"<ul><li>one</li><li>two<ol start=3><li>three</li><li>four</li></ol></li></ul>",
+		"* one\n* two\n  3. three\n  4. four",
+	},
+	{
+		"<p>Intro</p><blockquote><p>quoted</p><p>more</p></blockquote><p>After</p>",
+		"Intro\n\n> quoted\n>\n> more\n\nAfter",
+	},
+	{
+		"<table><tr><th>Item</th><th>Price</th></tr><tr><td>Apple</td><td>1</td></tr><tr><td>Watermelon</td><td>12</td></tr></table>",
+		"Item        Price\nApple       1\nWatermelon  12",
+	},
+	{
+		"<pre>  indented\n    code</pre>",
+		"  indented\n    code",
+	},
+	{
+		`<h1>Title</h1><div>a</div><div>b <img src="x.png" alt="logo"></div><hr><div>c</div>`,
+		"Title\n\na\nb logo\n\n----------\n\nc",
+	},
+}
+
+func TestHTMLToText(t *testing.T) {
+	for _, ht := range htmlTextTests {
+		if got := HTMLToText(ht.html); got != ht.text {
+			t.Errorf("HTMLToText(%q) = %q; expected %q", ht.html, got, ht.text)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Extraction of uuencoded and yEnc blocks embedded in plain text.
+
+package eml
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	uuBeginR = regexp.MustCompile(`^begin [0-7]{3,4} (.+)$`)
+	yKeyR    = regexp.MustCompile(`(\w+)=(\S+)`)
+)
+
+// splitLines splits data into lines, keeping the line endings.
+func splitLines(data []byte) [][]byte {
+	var lines [][]byte
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, data)
+			break
+		}
+		lines = append(lines, data[:i+1])
+		data = data[i+1:]
+	}
+	return lines
+}
+
+func trimEOL(line []byte) string {
+	return strings.TrimRight(string(line), "\r\n")
+}
+
+// extractInline removes uuencoded ("begin 644 name" ... "end") and yEnc
+// ("=ybegin" ... "=yend") blocks from a plain text body and returns the
+// remaining text along with the decoded blocks as attachments. Blocks
+// without an end line, or that cannot be decoded at all, are left in the
+// text.
+func extractInline(data []byte) ([]byte, []Attachment) {
+	lines := splitLines(data)
+	var (
+		text bytes.Buffer
+		atts []Attachment
+	)
+	for i := 0; i < len(lines); i++ {
+		line := trimEOL(lines[i])
+		var (
+			att Attachment
+			end int
+			ok  bool
+		)
+		switch {
+		case uuBeginR.MatchString(line):
+			att, end, ok = extractUU(lines, i)
+		case strings.HasPrefix(line, "=ybegin "):
+			att, end, ok = extractYEnc(lines, i)
+		}
+		if !ok {
+			text.Write(lines[i])
+			continue
+		}
+		atts = append(atts, att)
+		i = end
+	}
+	return text.Bytes(), atts
+}
+
+// extractUU decodes the uuencoded block starting at lines[start] and
+// returns it with the index of its "end" line.
+func extractUU(lines [][]byte, start int) (Attachment, int, bool) {
+	for end := start + 1; end < len(lines); end++ {
+		if strings.TrimSpace(trimEOL(lines[end])) != "end" {
+			continue
+		}
+		b, err := decodeUU(bytes.Join(lines[start:end+1], nil))
+		if err != nil {
+			return Attachment{}, 0, false
+		}
+		return Attachment{Filename: b.Filename, Data: b.Data, Warnings: b.Warnings}, end, true
+	}
+	return Attachment{}, 0, false
+}
+
+// yEncParams parses the keyword=value pairs of a yEnc control line. The
+// name keyword is always last and takes the rest of the line, as it may
+// contain spaces.
+func yEncParams(line string) map[string]string {
+	params := map[string]string{}
+	if i := strings.Index(line, " name="); i >= 0 {
+		params["name"] = strings.TrimSpace(line[i+len(" name="):])
+		line = line[:i]
+	}
+	for _, m := range yKeyR.FindAllStringSubmatch(line, -1) {
+		params[m[1]] = m[2]
+	}
+	return params
+}
+
+// extractYEnc decodes the yEnc block starting at lines[start] and returns
+// it with the index of its "=yend" line. The size and CRC32 given in the
+// trailer are verified, and mismatches are reported as warnings.
+func extractYEnc(lines [][]byte, start int) (Attachment, int, bool) {
+	begin := yEncParams(trimEOL(lines[start]))
+	att := Attachment{Filename: begin["name"]}
+	var part map[string]string
+
+	var data []byte
+	i := start + 1
+	if i < len(lines) && strings.HasPrefix(trimEOL(lines[i]), "=ypart ") {
+		part = yEncParams(trimEOL(lines[i]))
+		att.Warnings = append(att.Warnings, fmt.Sprintf("yEnc: only part %s of %s", begin["part"], begin["total"]))
+		i++
+	}
+	for ; i < len(lines); i++ {
+		line := bytes.TrimRight(lines[i], "\r\n")
+		if bytes.HasPrefix(line, []byte("=yend")) {
+			end := yEncParams(string(line))
+			att.Data = data
+			att.Warnings = append(att.Warnings, verifyYEnc(data, begin, part, end)...)
+			return att, i, true
+		}
+		for j := 0; j < len(line); j++ {
+			c := line[j]
+			if c == '=' && j+1 < len(line) {
+				j++
+				c = line[j] - 64
+			}
+			data = append(data, c-42)
+		}
+	}
+	return Attachment{}, 0, false
+}
+
+func verifyYEnc(data []byte, begin, part, end map[string]string) []string {
+	var warnings []string
+	if size, err := strconv.Atoi(end["size"]); err == nil && size != len(data) {
+		warnings = append(warnings, fmt.Sprintf("yEnc: size is %d, trailer says %d", len(data), size))
+	} else if part == nil && begin["size"] != "" && begin["size"] != end["size"] {
+		warnings = append(warnings, fmt.Sprintf("yEnc: header size %s does not match trailer size %s", begin["size"], end["size"]))
+	}
+
+	key := "crc32"
+	if part != nil {
+		key = "pcrc32"
+	}
+	if sum, ok := end[key]; ok {
+		want, err := strconv.ParseUint(sum, 16, 32)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("yEnc: malformed %s %q", key, sum))
+		} else if got := crc32.ChecksumIEEE(data); uint32(want) != got {
+			warnings = append(warnings, fmt.Sprintf("yEnc: %s is %08x, trailer says %08x", key, got, want))
+		}
+	} else {
+		warnings = append(warnings, "yEnc: no checksum in trailer")
+	}
+	return warnings
+}
@@ -0,0 +1,67 @@
+package eml
+
+import (
+	"reflect"
+	"testing"
+)
+
+const yEncData = "*+,-./0123456789:;<=}>?@ABCDEFGHIJKLMNOPQ\x92\x8f\x96\x96\x99J*4gJ\xa3o\x98\x8d"
+
+var yEncDecoded = []byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f !\x22#$%&'hello \x00\x0a= yEnc")
+
+type extractInlineTest struct {
+	text     string
+	rest     string
+	embedded []Attachment
+}
+
+var extractInlineTests = []extractInlineTest{
+	{
+		"no blocks here\r\nbegin with a word\r\n",
+		"no blocks here\r\nbegin with a word\r\n",
+		nil,
+	},
+	{
+		"Here is the file:\r\nbegin 644 pets.txt\r\n(0V%T(&1O9PH \r\n`\r\nend\r\nBye\r\n",
+		"Here is the file:\r\nBye\r\n",
+		[]Attachment{{Filename: "pets.txt", Data: []byte("Cat dog\n")}},
+	},
+	{
+		"before\n=ybegin line=128 size=54 name=my file.bin\n" + yEncData + "\n=yend size=54 crc32=ae7a0551\nafter\n",
+		"before\nafter\n",
+		[]Attachment{{Filename: "my file.bin", Data: yEncDecoded}},
+	},
+	{
+		"=ybegin line=128 size=54 name=bad.bin\n" + yEncData + "\n=yend size=54 crc32=00000000\n",
+		"",
+		[]Attachment{{Filename: "bad.bin", Data: yEncDecoded, Warnings: []string{"yEnc: crc32 is ae7a0551, trailer says 00000000"}}},
+	},
+	{
+		"begin 644 unterminated.txt\n(0V%T(&1O9PH \n",
+		"begin 644 unterminated.txt\n(0V%T(&1O9PH \n",
+		nil,
+	},
+}
+
+func TestExtractInline(t *testing.T) {
+	for _, et := range extractInlineTests {
+		rest, embedded := extractInline([]byte(et.text))
+		if string(rest) != et.rest || !reflect.DeepEqual(embedded, et.embedded) {
+			t.Errorf("extractInline(%q) gave %q, %#v; expected %q, %#v", et.text, rest, embedded, et.rest, et.embedded)
+		}
+	}
+}
+
+func TestParseExtractEmbedded(t *testing.T) {
+	msg := []byte("Content-Type: text/plain; charset=iso-8859-1\r\n\r\nSee attached.\r\n=ybegin line=128 size=54 name=data.bin\r\n" + yEncData + "\r\n=yend size=54 crc32=ae7a0551\r\n")
+	m, err := ParseWithOptions(msg, Options{ExtractEmbedded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != "See attached.\r\n" {
+		t.Errorf("unexpected text %q", m.Text)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].Filename != "data.bin" || !reflect.DeepEqual(m.Attachments[0].Data, yEncDecoded) {
+		t.Errorf("unexpected attachments %#v", m.Attachments)
+	}
+}
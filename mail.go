@@ -6,13 +6,9 @@ package eml
 
 import (
 	"bytes"
-	"encoding/base64"
 	"errors"
 	"fmt"
-	"io"
 	"mime"
-	"mime/quotedprintable"
-	"regexp"
 	"strings"
 
 	"github.com/Schidstorm/eml/decoder"
@@ -25,38 +21,82 @@ type HeaderInfo struct {
 
 type Message struct {
 	HeaderInfo
-	Body        []byte
-	Text        string
-	Html        string
+	Body []byte
+	Text string
+	Html string
+	// Attachments holds the parts that are not bodies, as well as the
+	// files embedded in text with Options.ExtractEmbedded.
 	Attachments []Attachment
 	Parts       []Part
+	// Bodies lists every part that could be displayed as the body of the
+	// message; the selected ones make up Text and Html.
+	Bodies []Body
 }
 
 type Attachment struct {
 	Filename string
 	Data     []byte
+	// Warnings describes problems found while decoding the attachment,
+	// such as checksum mismatches.
+	Warnings []string
+	// ContentType is the declared media type without parameters. It is
+	// empty for attachments embedded in text.
+	ContentType string
+	// SniffedType is the media type recognized from the content, as
+	// returned by SniffType.
+	SniffedType string
+	// TypeMismatch is set if the content, the declared type and the
+	// extension of the file name disagree, such as an executable named
+	// "invoice.pdf".
+	TypeMismatch bool
+	// DoubleExtension is set for names like "invoice.pdf.exe" that hide an
+	// executable extension behind another one.
+	DoubleExtension bool
+	// RTLO is set if the file name contains bidirectional control
+	// characters, such as the right-to-left override, which can make it
+	// display with a different extension.
+	RTLO bool
 }
 
 type Header struct {
 	Key, Value string
 }
 
+// Options enables optional processing steps in ParseWithOptions and
+// ProcessWithOptions. The zero value gives the behavior of Parse and Process.
+type Options struct {
+	// DetectCharset checks the declared charset of text parts against their
+	// content and fills in or overrides it when it is missing or wrong. The
+	// decision is recorded in Part.Detection.
+	DetectCharset bool
+	// ExtractEmbedded removes uuencoded and yEnc blocks from plain text
+	// parts and adds them to Message.Attachments.
+	ExtractEmbedded bool
+}
+
 func Parse(s []byte) (m Message, e error) {
+	return ParseWithOptions(s, Options{})
+}
+
+func ParseWithOptions(s []byte, opts Options) (m Message, e error) {
 	r, e := ParseRaw(s)
 	if e != nil {
 		return
 	}
-	return Process(r)
+	return ProcessWithOptions(r, opts)
 }
 
 func Process(r RawMessage) (m Message, e error) {
+	return ProcessWithOptions(r, Options{})
+}
+
+func ProcessWithOptions(r RawMessage, opts Options) (m Message, e error) {
 	m.FullHeaders = HeaderList{}
 	for _, rh := range r.RawHeaders {
 		if isUnstructuredHeader(string(rh.Key)) {
-			v, err := decoder.Parse(rh.Value)
-			if err != nil {
-				v = rh.Value
-			}
+			// Decoding is best-effort: words that cannot be decoded are
+			// kept as they are.
+			v, _ := decoder.Parse(rh.Value)
 			m.FullHeaders.Add(string(rh.Key), string(v))
 		} else {
 			m.FullHeaders.Add(string(rh.Key), string(rh.Value))
@@ -64,71 +104,72 @@ func Process(r RawMessage) (m Message, e error) {
 	}
 
 	var parts []Part
+	var tree *mimeNode
 	var er error
 
 	// is multipart with base64 encoding valid?
 	mediaType := m.FullHeaders.MediaType()
-	if mediaType.Type == "multipart/alternative" || mediaType.Type == "multipart/mixed" {
-		parts, er = parseMultipartBody(m.FullHeaders.ContentType(), r.Body)
+	if strings.HasPrefix(mediaType.Type, "multipart/") {
+		tree, er = parseMultipartTree(m.FullHeaders.ContentType(), r.Body, opts, &parts)
 		if er != nil {
 			e = er
 			return
 		}
 	} else {
-		body := r.Body
-		if hdr, ok := m.HeaderInfo.FullHeaders.FirstByKey("Content-Transfer-Encoding"); ok {
-			body, er = decodeByTransferEncoding(body, hdr)
-			if er != nil {
-				e = er
-				return
-			}
+		hdr, _ := m.HeaderInfo.FullHeaders.FirstByKey("Content-Transfer-Encoding")
+		body, warnings, er := decodeByTransferEncoding(r.Body, hdr)
+		if er != nil {
+			e = er
+			return
 		}
 
-		_, ps, err := mime.ParseMediaType(m.HeaderInfo.FullHeaders.ContentType())
+		mt, ps, err := mime.ParseMediaType(m.HeaderInfo.FullHeaders.ContentType())
 		if err != nil {
 			e = err
 			return
 		}
 
-		if charset, ok := ps["charset"]; ok && charset != "" {
+		var embedded []Attachment
+		if opts.ExtractEmbedded && mt == "text/plain" {
+			body, embedded = extractInline(body)
+		}
+
+		charset := ps["charset"]
+		var detection *decoder.CharsetDetection
+		if opts.DetectCharset && strings.HasPrefix(mt, "text/") {
+			body, charset, detection = detectAndDecode(body, charset, mt)
+		} else if charset != "" {
 			body = encodeData(body, charset)
 		}
 
-		parts = append(parts, Part{m.FullHeaders.ContentType(), ps["charset"], body, nil})
+		parts = append(parts, Part{Type: m.FullHeaders.ContentType(), Charset: charset, Data: body, Detection: detection, Warnings: warnings, Embedded: embedded})
+		tree = &mimeNode{mediaType: mt, params: ps}
 	}
 
 	for _, part := range parts {
-		switch {
-		case strings.Contains(part.Type, "text/plain"):
-			m.Text = string(part.Data)
-		case strings.Contains(part.Type, "text/html"):
-			m.Html = string(part.Data)
-
-		default:
-			if cd, ok := part.Headers["Content-Disposition"]; ok {
-				if strings.Contains(cd[0], "attachment") {
-					filename := regexp.MustCompile("(?msi)name=\"(.*?)\"").FindStringSubmatch(cd[0]) //.FindString(cd[0])
-					if len(filename) < 2 {
-						fmt.Println("failed get filename from header content-disposition")
-						break
-					}
-
-					dfilename, err := decoder.Parse([]byte(filename[1]))
-					if err != nil {
-						fmt.Println("Failed decode filename of attachment", err)
-					} else {
-						filename[1] = string(dfilename)
-					}
-
-					m.Attachments = append(m.Attachments, Attachment{filename[1], part.Data})
-
-				}
-			}
+		m.Attachments = append(m.Attachments, part.Embedded...)
+		if part.bodyType() != "" {
+			continue
 		}
+		// Every other leaf is an attachment, whatever its disposition, so
+		// that nothing escapes inspection.
+		ct, _, _ := mime.ParseMediaType(part.Type)
+		m.Attachments = append(m.Attachments, Attachment{Filename: part.filename(), Data: part.Data, ContentType: ct})
+	}
+
+	for i := range m.Attachments {
+		m.Attachments[i].inspect()
 	}
 
+	sel := selectBodies(tree, parts)
 	m.Parts = parts
-	m.Text = string(parts[0].Data)
+	m.Bodies = bodies(parts, sel)
+	m.Text = joinBodies(parts, sel.plain)
+	m.Html = joinBodies(parts, sel.html)
+	if m.Text == "" && m.Html != "" {
+		// HTML-only messages get a plain text rendering of their HTML.
+		m.Text = HTMLToText(m.Html)
+	}
 	return
 }
 
@@ -141,16 +182,87 @@ func isUnstructuredHeader(key string) bool {
 	}
 }
 
-func decodeByTransferEncoding(body []byte, transferEncoding string) ([]byte, error) {
-	var reader io.Reader = bytes.NewBuffer(body)
-	switch transferEncoding {
+// decodeByTransferEncoding undoes the given Content-Transfer-Encoding, whose
+// name is matched case-insensitively. Damaged base64, quoted-printable and
+// uuencoded data is decoded as far as possible. Bodies declared as 7bit,
+// 8bit or binary are checked against the declaration. The returned warnings
+// describe any repairs and mismatches, as well as encodings that are not
+// understood.
+func decodeByTransferEncoding(body []byte, transferEncoding string) ([]byte, []string, error) {
+	switch te := strings.ToLower(strings.TrimSpace(transferEncoding)); te {
 	case "quoted-printable":
-		reader = quotedprintable.NewReader(reader)
+		data, warnings := decoder.QuotedPrintable(body)
+		return data, warnings, nil
 	case "base64":
-		reader = base64.NewDecoder(base64.StdEncoding, reader)
+		data, warnings := decoder.Base64(body)
+		return data, warnings, nil
+	case "x-uuencode", "x-uue", "uuencode", "x-uuencoded":
+		b, err := decodeUU(body)
+		return b.Data, b.Warnings, err
+	case "7bit", "8bit", "binary", "":
+		return body, validateTransferEncoding(body, te), nil
+	default:
+		return body, []string{fmt.Sprintf("unknown Content-Transfer-Encoding %q, body left as is", transferEncoding)}, nil
+	}
+}
+
+// maxLineLength is the longest line RFC 5322 allows, excluding the CRLF.
+const maxLineLength = 998
+
+// validateTransferEncoding checks an unencoded body against the rules of
+// RFC 2045 section 2 for the declared encoding. An empty encoding is the
+// default, 7bit.
+func validateTransferEncoding(body []byte, te string) []string {
+	if te == "binary" {
+		return nil
+	}
+	if te == "" {
+		te = "7bit"
+	}
+	var warnings []string
+	eight, nul, long := -1, -1, -1
+	line := 0
+	for i, b := range body {
+		switch {
+		case b == '\n':
+			n := i - line
+			if n > 0 && body[i-1] == '\r' {
+				n--
+			}
+			if n > maxLineLength && long < 0 {
+				long = line
+			}
+			line = i + 1
+		case b == 0:
+			if nul < 0 {
+				nul = i
+			}
+		case b >= 0x80:
+			if eight < 0 {
+				eight = i
+			}
+		}
+	}
+	if len(body)-line > maxLineLength && long < 0 {
+		long = line
 	}
+	if te == "7bit" && eight >= 0 {
+		warnings = append(warnings, fmt.Sprintf("8-bit data at offset %d in body declared as 7bit", eight))
+	}
+	if nul >= 0 {
+		warnings = append(warnings, fmt.Sprintf("NUL at offset %d in body declared as %s", nul, te))
+	}
+	if long >= 0 {
+		warnings = append(warnings, fmt.Sprintf("line at offset %d longer than %d octets in body declared as %s", long, maxLineLength, te))
+	}
+	return warnings
+}
 
-	return io.ReadAll(reader)
+// detectAndDecode runs charset detection on text data and converts it to
+// UTF-8 with the detected charset.
+func detectAndDecode(data []byte, declared, mediaType string) ([]byte, string, *decoder.CharsetDetection) {
+	d := decoder.DetectCharset(data, declared, mediaType)
+	return encodeData(data[d.BOMLength:], d.Charset), d.Charset, &d
 }
 
 func encodeData(data []byte, charset string) []byte {
@@ -164,6 +276,9 @@ func encodeData(data []byte, charset string) []byte {
 
 type RawHeader struct {
 	Key, Value []byte
+	// Raw is the header field as it appeared in the message, including
+	// folding, without the final line break.
+	Raw []byte
 }
 
 type RawMessage struct {
@@ -228,13 +343,13 @@ func ParseRaw(s []byte) (m RawMessage, e error) {
 		case HVAL:
 			if b == CR && i < len(s)-2 && s[i+1] == LF && !isWSP(s[i+2]) {
 				v := bytes.Replace(s[vstart:i], CRLF, nil, -1)
-				hdr := RawHeader{s[kstart:kend], v}
+				hdr := RawHeader{s[kstart:kend], v, s[kstart:i]}
 				m.RawHeaders = append(m.RawHeaders, hdr)
 				state = READY
 				i++
 			} else if b == LF && i < len(s)-1 && !isWSP(s[i+1]) {
 				v := bytes.Replace(s[vstart:i], CRLF, nil, -1)
-				hdr := RawHeader{s[kstart:kend], v}
+				hdr := RawHeader{s[kstart:kend], v, bytes.TrimSuffix(s[kstart:i], []byte{CR})}
 				m.RawHeaders = append(m.RawHeaders, hdr)
 				state = READY
 			}
@@ -4,6 +4,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/Schidstorm/eml/decoder"
 )
 
 // Converts all newlines to CRLFs.
@@ -43,7 +45,7 @@ c
 
 `),
 		ret: RawMessage{
-			RawHeaders: []RawHeader{{crlf("a"), crlf("b")}},
+			RawHeaders: []RawHeader{{crlf("a"), crlf("b"), crlf("a: b")}},
 			Body:       crlf(""),
 		},
 	},
@@ -55,8 +57,8 @@ c: def
 `),
 		ret: RawMessage{
 			RawHeaders: []RawHeader{
-				{crlf("a"), crlf("b")},
-				{crlf("c"), crlf("def hi")},
+				{crlf("a"), crlf("b"), crlf("a: b")},
+				{crlf("c"), crlf("def hi"), crlf("c: def\n hi")},
 			},
 			Body: crlf(``),
 		},
@@ -70,9 +72,9 @@ hello, world
 `),
 		ret: RawMessage{
 			RawHeaders: []RawHeader{
-				{crlf("a"), crlf("b")},
-				{crlf("c"), crlf("d fdsa")},
-				{crlf("ef"), crlf("as")},
+				{crlf("a"), crlf("b"), crlf("a: b")},
+				{crlf("c"), crlf("d fdsa"), crlf("c: d fdsa")},
+				{crlf("ef"), crlf("as"), crlf("ef:  as")},
 			},
 			Body: crlf(`hello, world
 `),
@@ -87,9 +89,9 @@ hello, world
 `),
 		ret: RawMessage{
 			RawHeaders: []RawHeader{
-				{[]byte("a"), []byte("b")},
-				{[]byte("c"), []byte("d fdsa")},
-				{[]byte("ef"), []byte("as")},
+				{[]byte("a"), []byte("b"), []byte("a: b")},
+				{[]byte("c"), []byte("d fdsa"), []byte("c: d fdsa")},
+				{[]byte("ef"), []byte("as"), []byte("ef:  as")},
 			},
 			Body: []byte(`hello, world
 `),
@@ -143,7 +145,8 @@ var parseTests = []parseTest{
 			HeaderInfo: HeaderInfo{
 				FullHeaders: HeaderList{},
 			},
-			Text: "\r\n",
+			Text:   "\r\n",
+			Bodies: []Body{{Part: 0, MediaType: "text/plain", Text: "\r\n", Selected: true}},
 			Parts: []Part{
 				{
 					Type:    "text/plain",
@@ -162,7 +165,8 @@ G'day, mate.
 			HeaderInfo: HeaderInfo{
 				FullHeaders: HeaderList{"Subject": {"Hello, world"}},
 			},
-			Text: "G'day, mate.\r\n",
+			Text:   "G'day, mate.\r\n",
+			Bodies: []Body{{Part: 0, MediaType: "text/plain", Text: "G'day, mate.\r\n", Selected: true}},
 			Parts: []Part{
 				{
 					Type:    "text/plain",
@@ -179,9 +183,10 @@ G'day, mate.
 `),
 		Message{
 			HeaderInfo: HeaderInfo{
-				FullHeaders: HeaderList{"Subject": {"german_ü_&_&_."}},
+				FullHeaders: HeaderList{"Subject": {"german ü & & ."}},
 			},
-			Text: "G'day, mate.\r\n",
+			Text:   "G'day, mate.\r\n",
+			Bodies: []Body{{Part: 0, MediaType: "text/plain", Text: "G'day, mate.\r\n", Selected: true}},
 			Parts: []Part{
 				{
 					Type:    "text/plain",
@@ -200,9 +205,10 @@ G'day, mate.
 `),
 		Message{
 			HeaderInfo: HeaderInfo{
-				FullHeaders: HeaderList{"Subject": {"german_ü_&_&_. german_ü_&_&_."}},
+				FullHeaders: HeaderList{"Subject": {"german ü & & .german ü & & ."}},
 			},
-			Text: "G'day, mate.\r\n",
+			Text:   "G'day, mate.\r\n",
+			Bodies: []Body{{Part: 0, MediaType: "text/plain", Text: "G'day, mate.\r\n", Selected: true}},
 			Parts: []Part{
 				{
 					Type:    "text/plain",
@@ -228,7 +234,8 @@ VGhpcyBpcyBhIHRlc3QgaW4gYmFzZTY0
 					"Content-Transfer-Encoding": {"base64"},
 				},
 			},
-			Text: "This is a test in base64This is a test in base64",
+			Text:   "This is a test in base64This is a test in base64",
+			Bodies: []Body{{Part: 0, MediaType: "text/plain", Text: "This is a test in base64This is a test in base64", Selected: true}},
 			Parts: []Part{
 				{
 					Type:    "text/plain",
@@ -259,22 +266,26 @@ Some text.
 				},
 			},
 			Text: "Some text.",
+			Bodies: []Body{
+				{Part: 0, MediaType: "text/plain", Text: "Some text."},
+				{Part: 1, MediaType: "text/plain", Text: "Some text.", Selected: true},
+			},
 			Parts: []Part{
 				{
-					"text/plain",
-					"UTF-8",
-					[]byte("Some text."),
-					map[string][]string{
+					Type:    "text/plain",
+					Charset: "UTF-8",
+					Data:    []byte("Some text."),
+					Headers: map[string][]string{
 						"Content-Type": {
 							"text/plain",
 						},
 					},
 				},
 				{
-					"text/plain",
-					"UTF-8",
-					[]byte("Some text."),
-					map[string][]string{
+					Type:    "text/plain",
+					Charset: "UTF-8",
+					Data:    []byte("Some text."),
+					Headers: map[string][]string{
 						"Content-Type": {
 							"text/plain",
 						},
@@ -298,3 +309,87 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDetectCharset(t *testing.T) {
+	msg := crlf("Content-Type: multipart/mixed; boundary=b\n\n--b\nContent-Type: text/plain; charset=us-ascii\n\nSch\xf6ne Gr\xfc\xdfe\n--b\nContent-Type: text/plain\n\n\xef\xbb\xbfgr\xc3\xbcn\n--b--\n")
+	m, err := ParseWithOptions(msg, Options{DetectCharset: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		data    string
+		charset string
+		source  decoder.CharsetSource
+	}{
+		{"Schöne Grüße", "windows-1252", decoder.SourceDetected},
+		{"grün", "utf-8", decoder.SourceBOM},
+	}
+	for i, e := range expected {
+		p := m.Parts[i]
+		if string(p.Data) != e.data || p.Charset != e.charset || p.Detection == nil || p.Detection.Source != e.source {
+			t.Errorf("part %d: got %q in %s (%+v); expected %q in %s", i, p.Data, p.Charset, p.Detection, e.data, e.charset)
+		}
+	}
+}
+
+type transferEncodingTest struct {
+	body     string
+	te       string
+	data     string
+	warnings []string
+}
+
+var transferEncodingTests = []transferEncodingTest{
+	{"VGhpcyBpcyBhIHRlc3Q=", "Base64", "This is a test", nil},
+	{"caf=C3=A9", "QUOTED-PRINTABLE", "café", nil},
+	{"begin 644 cat.txt\n#0V%T\n`\nend\n", "x-uuencode", "Cat", nil},
+	{"begin 644 cat.txt\n#0V%T\n`\nend\n", "X-UUE", "Cat", nil},
+	{"plain\r\n", "7bit", "plain\r\n", nil},
+	{"plain\r\n", "", "plain\r\n", nil},
+	{"caf\xc3\xa9", "7bit", "caf\xc3\xa9", []string{"8-bit data at offset 3 in body declared as 7bit"}},
+	{"caf\xc3\xa9", "", "caf\xc3\xa9", []string{"8-bit data at offset 3 in body declared as 7bit"}},
+	{"caf\xc3\xa9", " 8Bit ", "caf\xc3\xa9", nil},
+	{"a\x00b", "8bit", "a\x00b", []string{"NUL at offset 1 in body declared as 8bit"}},
+	{"a\x00b", "binary", "a\x00b", nil},
+	{"short\r\n" + strings.Repeat("x", 999) + "\r\n", "8bit", "short\r\n" + strings.Repeat("x", 999) + "\r\n", []string{"line at offset 7 longer than 998 octets in body declared as 8bit"}},
+	{strings.Repeat("x", 998) + "\r\n", "7bit", strings.Repeat("x", 998) + "\r\n", nil},
+	{"data", "x-custom", "data", []string{`unknown Content-Transfer-Encoding "x-custom", body left as is`}},
+}
+
+func TestDecodeByTransferEncoding(t *testing.T) {
+	for _, tt := range transferEncodingTests {
+		data, warnings, err := decodeByTransferEncoding([]byte(tt.body), tt.te)
+		if err != nil {
+			t.Errorf("decodeByTransferEncoding(%q, %q) returned error: %s", tt.body, tt.te, err)
+		} else if string(data) != tt.data || !reflect.DeepEqual(warnings, tt.warnings) {
+			t.Errorf("decodeByTransferEncoding(%q, %q) gave %q, %#v; expected %q, %#v", tt.body, tt.te, data, warnings, tt.data, tt.warnings)
+		}
+	}
+}
+
+func TestParseDamagedBase64(t *testing.T) {
+	msg := crlf(`Content-Type: multipart/mixed; boundary=b
+
+--b
+Content-Type: text/plain
+Content-Transfer-Encoding: Base64
+
+VGhpcyBpcyBhIHRlc3Q
+--b
+Content-Type: text/html
+Content-Transfer-Encoding: quoted-printable
+
+<p>caf=c3=a9</p>=  
+--b--
+`)
+	m, err := Parse(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Parts[0].Data) != "This is a test" || !reflect.DeepEqual(m.Parts[0].Warnings, []string{"base64: missing padding at end of data"}) {
+		t.Errorf("unexpected first part %q, %#v", m.Parts[0].Data, m.Parts[0].Warnings)
+	}
+	if m.Html != "<p>café</p>" || len(m.Parts[1].Warnings) != 2 {
+		t.Errorf("unexpected second part %q, %#v", m.Html, m.Parts[1].Warnings)
+	}
+}
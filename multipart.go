@@ -9,7 +9,11 @@ import (
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"net/textproto"
 	"regexp"
+	"strings"
+
+	"github.com/Schidstorm/eml/decoder"
 )
 
 type Part struct {
@@ -17,59 +21,108 @@ type Part struct {
 	Charset string
 	Data    []byte
 	Headers map[string][]string
+	// Detection records how Charset was chosen when charset detection is
+	// enabled; it is nil otherwise.
+	Detection *decoder.CharsetDetection
+	// Warnings describes problems found while decoding the part that did
+	// not prevent it from being decoded.
+	Warnings []string
+	// Embedded holds the uuencoded and yEnc blocks that were removed from
+	// the text of the part when Options.ExtractEmbedded is set.
+	Embedded []Attachment
+}
+
+// mimeNode is a node of the MIME tree of a message. Leaves refer to their
+// entry in the flat list of parts; multiparts have children instead.
+type mimeNode struct {
+	mediaType string
+	params    map[string]string
+	part      int
+	children  []*mimeNode
 }
 
 // Parse the body of a message, using the given content-type. If the content
 // type is multipart, the parts slice will contain an entry for each part
 // present; otherwise, it will contain a single entry, with the entire (raw)
 // message contents.
-func parseMultipartBody(ct string, body []byte) (parts []Part, err error) {
-	_, ps, err := mime.ParseMediaType(ct)
+func parseMultipartBody(ct string, body []byte, opts Options) (parts []Part, err error) {
+	_, err = parseMultipartTree(ct, body, opts, &parts)
+	return
+}
+
+// parseMultipartTree parses a multipart body like parseMultipartBody,
+// appending its leaves to parts, and returns the MIME tree of the body.
+func parseMultipartTree(ct string, body []byte, opts Options, parts *[]Part) (*mimeNode, error) {
+	mt, ps, err := mime.ParseMediaType(ct)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	boundary, ok := ps["boundary"]
 	if !ok {
 		return nil, errors.New("encountered part without boundary in multipart body")
 	}
+	node := &mimeNode{mediaType: mt, params: ps, part: -1}
 	r := multipart.NewReader(bytes.NewReader(body), boundary)
 	p, err := r.NextRawPart()
 	for err != io.EOF {
+		if err != nil {
+			return node, err
+		}
 		data, _ := ioutil.ReadAll(p) // ignore error
 
-		var subparts []Part
-		subparts, err = parseMultipartBody(p.Header["Content-Type"][0], data)
-		for i := range subparts {
-			subparts[i].Headers = p.Header
+		partType := p.Header.Get("Content-Type")
+		if partType == "" {
+			partType = "text/plain"
 		}
 
-		//if err == nil then body have sub multipart, and append him
-		if err == nil {
-			parts = append(parts, subparts...)
-		} else {
-			contenttype := regexp.MustCompile("(?is)charset=(.*)").FindStringSubmatch(p.Header["Content-Type"][0])
-			charset := "UTF-8"
-			if len(contenttype) > 1 {
-				charset = contenttype[1]
+		// A multipart that cannot be parsed is kept as a single part.
+		var child *mimeNode
+		if strings.HasPrefix(strings.ToLower(partType), "multipart/") {
+			n := len(*parts)
+			if child, err = parseMultipartTree(partType, data, opts, parts); err != nil {
+				*parts = (*parts)[:n]
 			}
-
-			if hdr, ok := p.Header["Content-Transfer-Encoding"]; ok {
-				data, err = decodeByTransferEncoding(data, hdr[0])
-				if err != nil {
-					return nil, err
-				}
+		}
+		if child == nil || err != nil {
+			child, err = parseLeafPart(partType, p.Header, data, opts, parts)
+			if err != nil {
+				return node, err
 			}
-
-			data = encodeData(data, charset)
-
-			part := Part{p.Header["Content-Type"][0], charset, data, p.Header}
-			parts = append(parts, part)
 		}
+		node.children = append(node.children, child)
 		p, err = r.NextRawPart()
 	}
-	if err == io.EOF {
-		err = nil
+	return node, nil
+}
+
+// parseLeafPart decodes a part that is not a multipart and appends it to
+// parts.
+func parseLeafPart(ct string, header textproto.MIMEHeader, data []byte, opts Options, parts *[]Part) (*mimeNode, error) {
+	contenttype := regexp.MustCompile("(?is)charset=(.*)").FindStringSubmatch(ct)
+	charset := "UTF-8"
+	if len(contenttype) > 1 {
+		charset = contenttype[1]
 	}
-	return
+
+	data, warnings, err := decodeByTransferEncoding(data, header.Get("Content-Transfer-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+
+	mt, ps, _ := mime.ParseMediaType(ct)
+	var embedded []Attachment
+	if opts.ExtractEmbedded && mt == "text/plain" {
+		data, embedded = extractInline(data)
+	}
+
+	var detection *decoder.CharsetDetection
+	if opts.DetectCharset && strings.HasPrefix(mt, "text/") {
+		data, charset, detection = detectAndDecode(data, ps["charset"], mt)
+	} else {
+		data = encodeData(data, charset)
+	}
+
+	*parts = append(*parts, Part{ct, charset, data, header, detection, warnings, embedded})
+	return &mimeNode{mediaType: mt, params: ps, part: len(*parts) - 1}, nil
 }
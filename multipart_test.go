@@ -30,20 +30,20 @@ Some other text.
 `),
 		rps: []Part{
 			{
-				"text/plain; charset=ISO-8859-1",
-				"ISO-8859-1",
-				[]byte("Some text."),
-				map[string][]string{
+				Type:    "text/plain; charset=ISO-8859-1",
+				Charset: "ISO-8859-1",
+				Data:    []byte("Some text."),
+				Headers: map[string][]string{
 					"Content-Type": {
 						"text/plain; charset=ISO-8859-1",
 					},
 				},
 			},
 			{
-				"text/html; charset=ISO-8859-1",
-				"ISO-8859-1",
-				[]byte("Some other text."),
-				map[string][]string{
+				Type:    "text/html; charset=ISO-8859-1",
+				Charset: "ISO-8859-1",
+				Data:    []byte("Some other text."),
+				Headers: map[string][]string{
 					"Content-Type": {
 						"text/html; charset=ISO-8859-1",
 					},
@@ -58,7 +58,7 @@ Some other text.
 
 func TestParseBody(t *testing.T) {
 	for _, pt := range parseBodyTests {
-		parts, e := parseMultipartBody(pt.ct, pt.body)
+		parts, e := parseMultipartBody(pt.ct, pt.body, Options{})
 		if e != nil {
 			t.Errorf("parseBody returned error for %#v: %#v", pt, e)
 		} else if !reflect.DeepEqual(parts, pt.rps) {
@@ -0,0 +1,256 @@
+// Address normalization and comparison.
+
+package eml
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ProviderRule describes mailbox equivalences that a particular mail provider
+// applies to its local parts. RFC 5321 treats local parts as case-sensitive,
+// so none of these rules are applied unless the caller configures them.
+type ProviderRule struct {
+	// Domains the rule applies to, e.g. "gmail.com" and "googlemail.com".
+	Domains []string
+	// CanonicalDomain, if set, replaces any of Domains in the canonical form.
+	CanonicalDomain string
+	// IgnoreDots drops all '.' characters from the local part.
+	IgnoreDots bool
+	// TagSeparators lists the characters that start a sub-address tag, e.g.
+	// "+" for "user+tag@example.com". Everything from the first separator on
+	// is dropped.
+	TagSeparators string
+	// CaseInsensitive lower-cases the local part.
+	CaseInsensitive bool
+}
+
+// Normalizer turns addresses into a canonical form that can be compared. The
+// zero value lower-cases and IDNA-encodes the domain and leaves the local part
+// untouched.
+type Normalizer struct {
+	Rules []ProviderRule
+}
+
+// DefaultNormalizer is used by the comparison helpers on the address types.
+var DefaultNormalizer = &Normalizer{}
+
+func (n *Normalizer) rule(domain string) *ProviderRule {
+	if n == nil {
+		return nil
+	}
+	for i := range n.Rules {
+		for _, d := range n.Rules[i].Domains {
+			if canonicalDomain(d) == domain {
+				return &n.Rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Normalize returns the canonical form of a mailbox. The display name is
+// dropped, since it does not take part in mailbox identity.
+func (n *Normalizer) Normalize(ma MailboxAddr) MailboxAddr {
+	local := unquoteLocal(ma.local)
+	domain := canonicalDomain(ma.domain)
+	if r := n.rule(domain); r != nil {
+		if r.TagSeparators != "" {
+			if i := strings.IndexAny(local, r.TagSeparators); i > 0 {
+				local = local[:i]
+			}
+		}
+		if r.IgnoreDots {
+			local = strings.ReplaceAll(local, ".", "")
+		}
+		if r.CaseInsensitive {
+			local = strings.ToLower(local)
+		}
+		if r.CanonicalDomain != "" {
+			domain = canonicalDomain(r.CanonicalDomain)
+		}
+	}
+	return MailboxAddr{local: local, domain: domain}
+}
+
+// Canonical returns the canonical "local@domain" string of a mailbox.
+func (n *Normalizer) Canonical(ma MailboxAddr) string {
+	return n.Normalize(ma).Email()
+}
+
+// Equal reports whether a and b refer to the same mailbox. Groups are equal
+// when they contain the same set of mailboxes.
+func (n *Normalizer) Equal(a, b Address) bool {
+	sa, sb := NewAddressSet(n), NewAddressSet(n)
+	sa.Add(a)
+	sb.Add(b)
+	if sa.Len() != sb.Len() {
+		return false
+	}
+	if sa.Len() == 0 {
+		return a != nil && b != nil && a.Name() == b.Name()
+	}
+	for _, ma := range sb.Addresses() {
+		if !sa.Contains(ma) {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalDomain lower-cases a domain and converts internationalized labels
+// to their ASCII (punycode) form. Domain literals and domains that fail IDNA
+// processing are only lower-cased.
+func canonicalDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	if strings.HasPrefix(domain, "[") {
+		return strings.ToLower(domain)
+	}
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		return ascii
+	}
+	return strings.ToLower(domain)
+}
+
+// unquoteLocal removes the quoting from a quoted-string local part, so that
+// `"john"@example.com` and `john@example.com` compare equal.
+func unquoteLocal(local string) string {
+	if len(local) < 2 || local[0] != '"' || local[len(local)-1] != '"' {
+		return local
+	}
+	var b strings.Builder
+	in := local[1 : len(local)-1]
+	for i := 0; i < len(in); i++ {
+		if in[i] == '\\' && i+1 < len(in) {
+			i++
+		}
+		b.WriteByte(in[i])
+	}
+	return b.String()
+}
+
+// mailboxes expands an address into the mailboxes it designates.
+func mailboxes(a Address) []MailboxAddr {
+	switch addr := a.(type) {
+	case MailboxAddr:
+		return []MailboxAddr{addr}
+	case GroupAddr:
+		return addr.boxes
+	case nil:
+		return nil
+	default:
+		email := addr.Email()
+		i := strings.LastIndexByte(email, '@')
+		if i < 0 {
+			return nil
+		}
+		return []MailboxAddr{{name: addr.Name(), local: email[:i], domain: email[i+1:]}}
+	}
+}
+
+// Mailboxes returns the members of the group.
+func (ga GroupAddr) Mailboxes() []MailboxAddr {
+	return ga.boxes
+}
+
+// LocalPart returns the part of the address before the '@'.
+func (ma MailboxAddr) LocalPart() string {
+	return ma.local
+}
+
+// Domain returns the part of the address after the '@'.
+func (ma MailboxAddr) Domain() string {
+	return ma.domain
+}
+
+// Canonical returns the address in the canonical form of DefaultNormalizer.
+func (ma MailboxAddr) Canonical() string {
+	return DefaultNormalizer.Canonical(ma)
+}
+
+// Equal reports whether ma and other refer to the same mailbox according to
+// DefaultNormalizer.
+func (ma MailboxAddr) Equal(other Address) bool {
+	return DefaultNormalizer.Equal(ma, other)
+}
+
+// Equal reports whether ga and other contain the same mailboxes according to
+// DefaultNormalizer.
+func (ga GroupAddr) Equal(other Address) bool {
+	return DefaultNormalizer.Equal(ga, other)
+}
+
+// AddressSet is an ordered set of mailboxes, deduplicated by their canonical
+// form. Groups are expanded into their members when added.
+type AddressSet struct {
+	normalizer *Normalizer
+	index      map[string]int
+	addrs      []MailboxAddr
+}
+
+// NewAddressSet creates an empty set using n to compare addresses. A nil
+// normalizer behaves like the zero Normalizer.
+func NewAddressSet(n *Normalizer) *AddressSet {
+	return &AddressSet{normalizer: n, index: map[string]int{}}
+}
+
+// Add inserts the given addresses and returns how many of them were new. The
+// first occurrence of a mailbox is kept, including its display name.
+func (s *AddressSet) Add(addrs ...Address) int {
+	added := 0
+	for _, a := range addrs {
+		for _, ma := range mailboxes(a) {
+			key := s.normalizer.Canonical(ma)
+			if _, ok := s.index[key]; ok {
+				continue
+			}
+			s.index[key] = len(s.addrs)
+			s.addrs = append(s.addrs, ma)
+			added++
+		}
+	}
+	return added
+}
+
+// Remove deletes the mailboxes designated by a from the set.
+func (s *AddressSet) Remove(a Address) {
+	for _, ma := range mailboxes(a) {
+		key := s.normalizer.Canonical(ma)
+		i, ok := s.index[key]
+		if !ok {
+			continue
+		}
+		s.addrs = append(s.addrs[:i], s.addrs[i+1:]...)
+		delete(s.index, key)
+		for k, j := range s.index {
+			if j > i {
+				s.index[k] = j - 1
+			}
+		}
+	}
+}
+
+// Contains reports whether every mailbox designated by a is in the set.
+func (s *AddressSet) Contains(a Address) bool {
+	boxes := mailboxes(a)
+	if len(boxes) == 0 {
+		return false
+	}
+	for _, ma := range boxes {
+		if _, ok := s.index[s.normalizer.Canonical(ma)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of distinct mailboxes in the set.
+func (s *AddressSet) Len() int {
+	return len(s.addrs)
+}
+
+// Addresses returns the mailboxes in insertion order.
+func (s *AddressSet) Addresses() []MailboxAddr {
+	return append([]MailboxAddr(nil), s.addrs...)
+}
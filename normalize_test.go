@@ -0,0 +1,87 @@
+package eml
+
+import (
+	"reflect"
+	"testing"
+)
+
+var gmailLike = &Normalizer{
+	Rules: []ProviderRule{
+		{
+			Domains:         []string{"gmail.com", "googlemail.com"},
+			CanonicalDomain: "gmail.com",
+			IgnoreDots:      true,
+			TagSeparators:   "+",
+			CaseInsensitive: true,
+		},
+	},
+}
+
+type canonicalTest struct {
+	n    *Normalizer
+	addr MailboxAddr
+	out  string
+}
+
+var canonicalTests = []canonicalTest{
+	{DefaultNormalizer, MailboxAddr{`Mary`, `mary`, `X.Test`}, `mary@x.test`},
+	{DefaultNormalizer, MailboxAddr{``, `Mary`, `x.test`}, `Mary@x.test`},
+	{DefaultNormalizer, MailboxAddr{``, `"john"`, `example.com`}, `john@example.com`},
+	{DefaultNormalizer, MailboxAddr{``, `jürgen`, `Bücher.example`}, `jürgen@xn--bcher-kva.example`},
+	{DefaultNormalizer, MailboxAddr{``, `a.b+c`, `gmail.com`}, `a.b+c@gmail.com`},
+	{gmailLike, MailboxAddr{``, `A.B+news`, `GoogleMail.com`}, `ab@gmail.com`},
+	{gmailLike, MailboxAddr{``, `a.b+c`, `example.com`}, `a.b+c@example.com`},
+}
+
+func TestCanonical(t *testing.T) {
+	for _, ct := range canonicalTests {
+		if out := ct.n.Canonical(ct.addr); out != ct.out {
+			t.Errorf("Canonical(%#v) gave %q; expected %q", ct.addr, out, ct.out)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a, _ := ParseAddress([]byte(`Mary Smith <mary@X.TEST>`))
+	b, _ := ParseAddress([]byte(`mary@x.test`))
+	c, _ := ParseAddress([]byte(`MARY@x.test`))
+	if !a.(MailboxAddr).Equal(b) {
+		t.Errorf("expected %v to equal %v", a, b)
+	}
+	if a.(MailboxAddr).Equal(c) {
+		t.Errorf("expected %v to differ from %v", a, c)
+	}
+	if !a.(MailboxAddr).Equal(CreateDecodedAddress(b)) {
+		t.Errorf("expected %v to equal decoded %v", a, b)
+	}
+}
+
+func TestAddressSet(t *testing.T) {
+	l, err := parseAddressList([]byte(`a.b@gmail.com, Other <ab+x@googlemail.com>, c@example.com`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, _ := ParseAddress([]byte(`Team:c@Example.com,d@example.com;`))
+
+	s := NewAddressSet(gmailLike)
+	if n := s.Add(l...); n != 2 {
+		t.Errorf("Add returned %d; expected 2", n)
+	}
+	if n := s.Add(g); n != 1 {
+		t.Errorf("Add(group) returned %d; expected 1", n)
+	}
+	if !s.Contains(g) {
+		t.Errorf("expected set to contain %v", g)
+	}
+	s.Remove(l[2])
+	exp := []MailboxAddr{
+		{``, `a.b`, `gmail.com`},
+		{``, `d`, `example.com`},
+	}
+	if act := s.Addresses(); !reflect.DeepEqual(act, exp) {
+		t.Errorf("Addresses gave %#v; expected %#v", act, exp)
+	}
+	if s.Contains(g) {
+		t.Errorf("expected set not to contain %v after removal", g)
+	}
+}
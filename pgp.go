@@ -0,0 +1,269 @@
+// Detection and processing of PGP/MIME (RFC 3156) and inline OpenPGP.
+
+package eml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// maxPGPLayers bounds the nesting of signed and encrypted entities that
+// PGPOpener.Open unwraps.
+const maxPGPLayers = 8
+
+// PGPKind is the kind of a PGP/MIME entity.
+type PGPKind int
+
+const (
+	PGPSigned PGPKind = iota + 1
+	PGPEncrypted
+)
+
+func (k PGPKind) String() string {
+	switch k {
+	case PGPSigned:
+		return "signed"
+	case PGPEncrypted:
+		return "encrypted"
+	}
+	return "unknown"
+}
+
+// PGPMIME is the structure of a multipart/signed or multipart/encrypted
+// entity using OpenPGP.
+type PGPMIME struct {
+	Kind PGPKind
+	// MicAlg is the micalg parameter of a signed entity, such as
+	// "pgp-sha256".
+	MicAlg string
+	// SignedContent is the first part of a signed entity, headers
+	// included, exactly as covered by the signature: with CRLF line
+	// endings and without the line break before the boundary.
+	SignedContent []byte
+	// Signature is the detached signature, usually ASCII armored.
+	Signature []byte
+	// Encrypted is the OpenPGP message of an encrypted entity, usually
+	// ASCII armored.
+	Encrypted []byte
+}
+
+// ParsePGPMIME identifies PGP/MIME messages. It returns nil without error
+// for messages that are not PGP/MIME, and an error if the structure is
+// broken.
+func ParsePGPMIME(r RawMessage) (*PGPMIME, error) {
+	mt, ps, err := mime.ParseMediaType(rawHeader(r, "Content-Type"))
+	if err != nil {
+		return nil, nil
+	}
+	var kind PGPKind
+	switch {
+	case mt == "multipart/signed" && strings.EqualFold(ps["protocol"], "application/pgp-signature"):
+		kind = PGPSigned
+	case mt == "multipart/encrypted" && strings.EqualFold(ps["protocol"], "application/pgp-encrypted"):
+		kind = PGPEncrypted
+	default:
+		return nil, nil
+	}
+
+	parts := rawMultipartParts(r.Body, ps["boundary"])
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("pgp: %s with %d parts", mt, len(parts))
+	}
+	second, err := ParseRaw(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("pgp: second part: %v", err)
+	}
+	data, _, err := decodeByTransferEncoding(second.Body, rawHeader(second, "Content-Transfer-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PGPMIME{Kind: kind}
+	if kind == PGPSigned {
+		p.MicAlg = strings.ToLower(ps["micalg"])
+		p.SignedContent = crlfLines(parts[0])
+		p.Signature = data
+		return p, nil
+	}
+	// The first part only carries the version.
+	first, err := ParseRaw(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("pgp: first part: %v", err)
+	}
+	if ct, _, _ := mime.ParseMediaType(rawHeader(first, "Content-Type")); ct != "application/pgp-encrypted" {
+		return nil, fmt.Errorf("pgp: first part of multipart/encrypted is %q", ct)
+	}
+	p.Encrypted = data
+	return p, nil
+}
+
+// PGPBackend performs the OpenPGP operations for PGPOpener.
+type PGPBackend interface {
+	// Verify checks a detached signature over data and returns the ID of
+	// the signing key. It fails if the signature does not verify.
+	Verify(data, signature []byte) (keyID string, err error)
+	// Decrypt decrypts an OpenPGP message.
+	Decrypt(message []byte) ([]byte, error)
+}
+
+// PGPSignature is the result of verifying a PGP/MIME signature.
+type PGPSignature struct {
+	// Status is AuthPass if the backend verified the signature and
+	// AuthFail otherwise.
+	Status AuthStatus
+	KeyID  string
+	// Content and Signature are what was given to the backend.
+	Content   []byte
+	Signature []byte
+	Err       error
+}
+
+// PGPResult is the outcome of opening a PGP/MIME message.
+type PGPResult struct {
+	// Raw is the innermost entity with the header fields of the outer
+	// message that it does not replace.
+	Raw RawMessage
+	// Message is Raw processed as by Process.
+	Message Message
+	// Encrypted tells whether any layer was encrypted.
+	Encrypted bool
+	// Signatures lists the signatures of all signed layers, outermost
+	// first.
+	Signatures []PGPSignature
+}
+
+// PGPOpener verifies and decrypts PGP/MIME messages through a Backend.
+type PGPOpener struct {
+	// Backend performs verification and decryption. If nil, signatures
+	// are reported with status AuthNone and encrypted messages cannot be
+	// opened.
+	Backend PGPBackend
+}
+
+// Open unwraps the PGP/MIME layers of a message and processes the innermost
+// entity as a normal message. Messages without PGP/MIME are processed as
+// they are. Open fails if a layer is malformed or cannot be decrypted.
+func (o PGPOpener) Open(r RawMessage) (PGPResult, error) {
+	var res PGPResult
+	for layer := 0; ; layer++ {
+		if layer == maxPGPLayers {
+			return res, errors.New("pgp: too many nested layers")
+		}
+		p, err := ParsePGPMIME(r)
+		if err != nil {
+			return res, err
+		}
+		if p == nil {
+			break
+		}
+
+		var inner []byte
+		switch p.Kind {
+		case PGPSigned:
+			sig := PGPSignature{Status: AuthNone, Content: p.SignedContent, Signature: p.Signature}
+			if o.Backend != nil {
+				sig.Status = AuthPass
+				if sig.KeyID, sig.Err = o.Backend.Verify(p.SignedContent, p.Signature); sig.Err != nil {
+					sig.Status = AuthFail
+				}
+			}
+			res.Signatures = append(res.Signatures, sig)
+			inner = p.SignedContent
+		case PGPEncrypted:
+			if o.Backend == nil {
+				return res, errors.New("pgp: message is encrypted, but no backend was given")
+			}
+			res.Encrypted = true
+			if inner, err = o.Backend.Decrypt(p.Encrypted); err != nil {
+				return res, fmt.Errorf("pgp: %v", err)
+			}
+		}
+		entity, err := ParseRaw(inner)
+		if err != nil {
+			return res, fmt.Errorf("pgp: inner entity: %v", err)
+		}
+		r = mergeHeaders(r, entity)
+	}
+	res.Raw = r
+	var err error
+	res.Message, err = Process(r)
+	return res, err
+}
+
+// PGPBlock is an ASCII armored OpenPGP block found in the text of a part.
+type PGPBlock struct {
+	// Part is the index of the part in Message.Parts.
+	Part int
+	// Type is the armor label, such as "PGP MESSAGE", "PGP SIGNED
+	// MESSAGE", "PGP SIGNATURE" or "PGP PUBLIC KEY BLOCK".
+	Type string
+	// Offset is the position of the block in the data of the part.
+	Offset int
+	// Data is the block from its BEGIN line to the end of its END line.
+	// A cleartext signed message includes its signature.
+	Data []byte
+}
+
+var armorBeginR = regexp.MustCompile(`^-----BEGIN (PGP [A-Z ,/0-9]+)-----[ \t]*\r?\n?$`)
+
+// InlinePGP finds the OpenPGP blocks in the plain text and HTML parts of a
+// message. Their Data can be passed on to an OpenPGP implementation such as
+// a PGPBackend.
+func (m Message) InlinePGP() []PGPBlock {
+	var blocks []PGPBlock
+	for i, p := range m.Parts {
+		if p.bodyType() == "" {
+			continue
+		}
+		for _, b := range FindPGPBlocks(p.Data) {
+			b.Part = i
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// FindPGPBlocks finds ASCII armored OpenPGP blocks (RFC 4880 section 6.2) in
+// text. Blocks must start at the beginning of a line; blocks without END
+// line are ignored. Part is left zero.
+func FindPGPBlocks(text []byte) []PGPBlock {
+	var blocks []PGPBlock
+	lines := splitLines(text)
+	offset := 0
+	for i := 0; i < len(lines); i++ {
+		m := armorBeginR.FindSubmatch(lines[i])
+		if m == nil {
+			offset += len(lines[i])
+			continue
+		}
+		label := string(m[1])
+		end := "-----END " + label + "-----"
+		if label == "PGP SIGNED MESSAGE" {
+			// The cleartext is followed by its signature.
+			end = "-----END PGP SIGNATURE-----"
+		}
+		length := len(lines[i])
+		found := false
+		for j := i + 1; j < len(lines) && !found; j++ {
+			length += len(lines[j])
+			if strings.TrimRight(trimEOL(lines[j]), " \t") == end {
+				found = true
+				blocks = append(blocks, PGPBlock{
+					Type:   label,
+					Offset: offset,
+					Data:   bytes.TrimRight(text[offset:offset+length], "\r\n"),
+				})
+				i = j
+			}
+		}
+		if !found {
+			length = len(lines[i])
+		}
+		offset += length
+	}
+	return blocks
+}
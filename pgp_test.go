@@ -0,0 +1,219 @@
+package eml
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakePGP is a PGPBackend whose signatures are hex SHA-256 digests and
+// whose messages are base64 armored plaintext.
+type fakePGP struct{}
+
+func fakePGPSign(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return "-----BEGIN PGP SIGNATURE-----\r\n\r\n" + hex.EncodeToString(sum[:]) + "\r\n-----END PGP SIGNATURE-----\r\n"
+}
+
+func fakePGPEncrypt(data string) string {
+	return "-----BEGIN PGP MESSAGE-----\r\n\r\n" + base64.StdEncoding.EncodeToString([]byte(data)) + "\r\n-----END PGP MESSAGE-----\r\n"
+}
+
+func (fakePGP) Verify(data, signature []byte) (string, error) {
+	if string(signature) != fakePGPSign(string(data)) {
+		return "", errors.New("bad signature")
+	}
+	return "0123456789ABCDEF", nil
+}
+
+func (fakePGP) Decrypt(message []byte) ([]byte, error) {
+	lines := strings.Split(string(message), "\r\n")
+	if len(lines) < 3 || lines[0] != "-----BEGIN PGP MESSAGE-----" {
+		return nil, errors.New("not a message")
+	}
+	return base64.StdEncoding.DecodeString(lines[2])
+}
+
+const pgpInner = "Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"We lost the game. =20\r\n" +
+	"From here on, it can only get better.\r\n"
+
+func pgpSigned(inner, sig string) string {
+	return "Content-Type: multipart/signed; micalg=pgp-sha256;\r\n" +
+		" protocol=\"application/pgp-signature\"; boundary=\"sig\"\r\n" +
+		"\r\n" +
+		"This is an OpenPGP/MIME signed message (RFC 4880 and 3156)\r\n" +
+		"--sig\r\n" +
+		inner +
+		"\r\n--sig\r\n" +
+		"Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n" +
+		"Content-Description: OpenPGP digital signature\r\n" +
+		"\r\n" +
+		sig +
+		"\r\n--sig--\r\n"
+}
+
+func pgpEncrypted(message string) string {
+	return "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"enc\"\r\n" +
+		"\r\n" +
+		"--enc\r\n" +
+		"Content-Type: application/pgp-encrypted\r\n" +
+		"\r\n" +
+		"Version: 1\r\n" +
+		"\r\n--enc\r\n" +
+		"Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n" +
+		"\r\n" +
+		message +
+		"--enc--\r\n"
+}
+
+func TestParsePGPMIME(t *testing.T) {
+	sig := fakePGPSign(pgpInner)
+	r, err := ParseRaw([]byte(smimeOuter + pgpSigned(pgpInner, sig)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePGPMIME(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PGPMIME{Kind: PGPSigned, MicAlg: "pgp-sha256", SignedContent: []byte(pgpInner), Signature: []byte(sig)}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v; expected %+v", p, want)
+	}
+
+	// Signed content is canonicalized to CRLF.
+	r, _ = ParseRaw([]byte(strings.ReplaceAll(smimeOuter+pgpSigned(pgpInner, sig), "\r\n", "\n")))
+	if p, err := ParsePGPMIME(r); err != nil || string(p.SignedContent) != pgpInner {
+		t.Errorf("LF message: got %q, %v", p.SignedContent, err)
+	}
+
+	r, _ = ParseRaw([]byte(smimeOuter + pgpEncrypted(fakePGPEncrypt(pgpInner))))
+	if p, err := ParsePGPMIME(r); err != nil || p.Kind != PGPEncrypted || string(p.Encrypted)+"\r\n" != fakePGPEncrypt(pgpInner) {
+		t.Errorf("encrypted: got %+v, %v", p, err)
+	}
+
+	r, _ = ParseRaw([]byte(smimeOuter + smimeInner))
+	if p, err := ParsePGPMIME(r); p != nil || err != nil {
+		t.Errorf("plain message: got %+v, %v", p, err)
+	}
+	broken := strings.Replace(pgpEncrypted("x"), "application/pgp-encrypted\r\n\r\n", "text/plain\r\n\r\n", 1)
+	r, _ = ParseRaw([]byte(smimeOuter + broken))
+	if _, err := ParsePGPMIME(r); err == nil {
+		t.Errorf("broken multipart/encrypted did not fail")
+	}
+}
+
+func TestPGPOpen(t *testing.T) {
+	type test struct {
+		name      string
+		msg       string
+		backend   PGPBackend
+		status    []AuthStatus
+		encrypted bool
+	}
+	signed := pgpSigned(pgpInner, fakePGPSign(pgpInner))
+	tests := []test{
+		{"signed", signed, fakePGP{}, []AuthStatus{AuthPass}, false},
+		{"altered", strings.Replace(signed, "lost", "won", 1), fakePGP{}, []AuthStatus{AuthFail}, false},
+		{"no backend", signed, nil, []AuthStatus{AuthNone}, false},
+		{"encrypted", pgpEncrypted(fakePGPEncrypt(pgpInner)), fakePGP{}, nil, true},
+		{"signed and encrypted", pgpEncrypted(fakePGPEncrypt(signed)), fakePGP{}, []AuthStatus{AuthPass}, true},
+	}
+	for _, tt := range tests {
+		r, err := ParseRaw([]byte(smimeOuter + tt.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := PGPOpener{Backend: tt.backend}.Open(r)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var status []AuthStatus
+		for _, s := range res.Signatures {
+			status = append(status, s.Status)
+		}
+		if !reflect.DeepEqual(status, tt.status) || res.Encrypted != tt.encrypted {
+			t.Errorf("%s: got signatures %v, encrypted %v", tt.name, status, res.Encrypted)
+		}
+		if tt.name == "altered" {
+			continue
+		}
+		if res.Message.Text != "We lost the game.  \r\nFrom here on, it can only get better.\r\n" {
+			t.Errorf("%s: got text %q", tt.name, res.Message.Text)
+		}
+		if s := res.Message.FullHeaders["Subject"]; len(s) != 1 {
+			t.Errorf("%s: outer header fields lost", tt.name)
+		}
+	}
+
+	r, _ := ParseRaw([]byte(smimeOuter + pgpEncrypted(fakePGPEncrypt(pgpInner))))
+	if _, err := (PGPOpener{}).Open(r); err == nil {
+		t.Errorf("encrypted message without backend did not fail")
+	}
+}
+
+type pgpBlockTest struct {
+	text   string
+	blocks []PGPBlock
+}
+
+var pgpBlockTests = []pgpBlockTest{
+	{
+		"Hi,\r\n\r\n-----BEGIN PGP MESSAGE-----\r\n\r\nhQEMA...\r\n=abcd\r\n-----END PGP MESSAGE-----\r\nBye\r\n",
+		[]PGPBlock{{Type: "PGP MESSAGE", Offset: 7, Data: []byte("-----BEGIN PGP MESSAGE-----\r\n\r\nhQEMA...\r\n=abcd\r\n-----END PGP MESSAGE-----")}},
+	},
+	{
+		"-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n- -- dashed\n-----BEGIN PGP SIGNATURE-----\n\niQEz\n-----END PGP SIGNATURE-----\n" +
+			"-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQEN\n-----END PGP PUBLIC KEY BLOCK-----",
+		[]PGPBlock{
+			{Type: "PGP SIGNED MESSAGE", Data: []byte("-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n- -- dashed\n-----BEGIN PGP SIGNATURE-----\n\niQEz\n-----END PGP SIGNATURE-----")},
+			{Type: "PGP PUBLIC KEY BLOCK", Offset: 125, Data: []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQEN\n-----END PGP PUBLIC KEY BLOCK-----")},
+		},
+	},
+	{
+		// Quoted and unterminated blocks are not taken.
+		"> -----BEGIN PGP MESSAGE-----\n> abc\n> -----END PGP MESSAGE-----\n-----BEGIN PGP MESSAGE-----\nabc\n",
+		nil,
+	},
+}
+
+func TestFindPGPBlocks(t *testing.T) {
+	for _, tt := range pgpBlockTests {
+		blocks := FindPGPBlocks([]byte(tt.text))
+		if !reflect.DeepEqual(blocks, tt.blocks) {
+			t.Errorf("FindPGPBlocks(%q) = %+v; expected %+v", tt.text, blocks, tt.blocks)
+		}
+	}
+}
+
+func TestInlinePGP(t *testing.T) {
+	msg := smimeOuter +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		fakePGPEncrypt("secret") +
+		"--b\r\n" +
+		"Content-Type: application/pgp-keys\r\n" +
+		"Content-Disposition: attachment; filename=\"key.asc\"\r\n" +
+		"\r\n" +
+		"-----BEGIN PGP PUBLIC KEY BLOCK-----\r\n\r\nmQEN\r\n-----END PGP PUBLIC KEY BLOCK-----\r\n" +
+		"--b--\r\n"
+	m, err := Parse([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := m.InlinePGP()
+	if len(blocks) != 1 || blocks[0].Part != 0 || blocks[0].Type != "PGP MESSAGE" {
+		t.Errorf("got %+v", blocks)
+	}
+}
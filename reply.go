@@ -0,0 +1,350 @@
+// Separation of new content from quoted history and signatures in replies.
+
+package eml
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ReplyKind is the kind of a ReplySegment.
+type ReplyKind int
+
+const (
+	// ReplyContent is text written by the sender.
+	ReplyContent ReplyKind = iota
+	// ReplyAttribution introduces quoted text, as in "On ... wrote:" or
+	// the header block of an Outlook reply.
+	ReplyAttribution
+	// ReplyQuote is quoted history.
+	ReplyQuote
+	// ReplySignature is the signature of the sender.
+	ReplySignature
+)
+
+func (k ReplyKind) String() string {
+	switch k {
+	case ReplyContent:
+		return "content"
+	case ReplyAttribution:
+		return "attribution"
+	case ReplyQuote:
+		return "quote"
+	case ReplySignature:
+		return "signature"
+	}
+	return "unknown"
+}
+
+// ReplySegment is a run of text of a single kind.
+type ReplySegment struct {
+	Kind ReplyKind
+	Text string
+}
+
+// ReplyContentText returns the text of the content segments, without
+// leading and trailing white space.
+func ReplyContentText(segs []ReplySegment) string {
+	var b strings.Builder
+	for _, s := range segs {
+		if s.Kind == ReplyContent {
+			b.WriteString(s.Text)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+var (
+	// attributionR matches the lines mail clients put before quoted text,
+	// in several languages.
+	attributionR = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^on\b.+\bwrote:$`),
+		regexp.MustCompile(`(?i)^am\b.+\bschrieb\b.*:$`),
+		regexp.MustCompile(`(?i)^le\b.+\ba écrit ?:$`),
+		regexp.MustCompile(`(?i)^el\b.+\bescribió:$`),
+		regexp.MustCompile(`(?i)^il\b.+\bha scritto:$`),
+		regexp.MustCompile(`(?i)^op\b.+\bschreef\b.*:$`),
+		regexp.MustCompile(`(?i)^em\b.+\bescreveu:$`),
+		regexp.MustCompile(`(?i)^(den|på)\b.+\bskrev\b.*:$`),
+		regexp.MustCompile(`(?i)^w dniu\b.+\bnapisał(\(a\))?:$`),
+		regexp.MustCompile(`(?i)^.+ написал(\(а\))?:$`),
+	}
+	// originalMessageR matches the separators Outlook and others put
+	// before the header block of quoted or forwarded messages.
+	originalMessageR = regexp.MustCompile(`(?i)^-{2,} ?(original message|ursprüngliche nachricht|message d'origine|mensaje original|messaggio originale|oorspronkelijk bericht|originalmeddelande|original meddelelse|opprinnelig melding|wiadomość oryginalna|исходное сообщение|forwarded message|weitergeleitete nachricht|message transféré) ?-{2,}$`)
+	underscoreR      = regexp.MustCompile(`^_{20,}$`)
+	headerFromR      = regexp.MustCompile(`(?i)^\*?(from|von|de|da|van|från|fra|od|от):\*? `)
+	headerSentR      = regexp.MustCompile(`(?i)^\*?(sent|date|gesendet|datum|envoyé|enviado|fecha|inviato|data|verzonden|skickat|sendt|wysłano|отправлено):\*? `)
+	mobileR          = regexp.MustCompile(`(?i)^(sent from my |sent from outlook|get outlook for |sent via |von meinem .+ gesendet|envoyé de mon |enviado desde mi |inviato da |verzonden met |skickat från )`)
+	valedictionR     = regexp.MustCompile(`(?i)^((best|kind|warm|many thanks and)? ?regards|best wishes|thanks|thank you|many thanks|cheers|best|sincerely|yours( truly| sincerely)?|mit freundlichen grüßen|(viele|beste|liebe|freundliche) grüße|grüße|gruß|cordialement|bien à vous|(un )?saludos( cordiales)?|met vriendelijke groet(en)?|(cordiali )?saluti|atenciosamente|med vänliga hälsningar|med venlig hilsen|med vennlig hilsen|pozdrawiam|с уважением)[,.!]?$`)
+)
+
+// maxSignatureLines bounds the length of signatures found without a "-- "
+// delimiter.
+const maxSignatureLines = 6
+
+func isAttribution(line string) bool {
+	for _, r := range attributionR {
+		if r.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitReply separates a plain text reply into new content, quoted history
+// and signature. Quoted history is recognized by "> " prefixes, attribution
+// lines such as "On ... wrote:" in several languages, and Outlook style
+// "-----Original Message-----" separators and header blocks. Text following
+// an attribution or header block that is not quoted with "> " is taken to
+// be a top-posted quote and extends to the end. A signature is the end of
+// the last run of content, starting at a "-- " delimiter, a "Sent from my
+// ..." line or, within its last few lines, a closing such as "Best
+// regards,". Concatenating the texts of the segments gives back text.
+func SplitReply(text string) []ReplySegment {
+	lines := splitLines([]byte(text))
+	trimmed := make([]string, len(lines))
+	for i, l := range lines {
+		trimmed[i] = strings.TrimSpace(trimEOL(l))
+	}
+	kinds := make([]ReplyKind, len(lines))
+
+	nextNonBlank := func(i int) int {
+		for ; i < len(lines); i++ {
+			if trimmed[i] != "" {
+				return i
+			}
+		}
+		return -1
+	}
+	rest := func(from int, kind ReplyKind) {
+		for j := from; j < len(lines); j++ {
+			kinds[j] = kind
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := trimmed[i]
+		switch {
+		case strings.HasPrefix(trimEOL(lines[i]), ">"):
+			kinds[i] = ReplyQuote
+			continue
+		case line == "":
+			if i > 0 {
+				kinds[i] = kinds[i-1]
+			}
+			continue
+		}
+
+		// Attributions may be wrapped onto a second line.
+		n := 0
+		if isAttribution(line) {
+			n = 1
+		} else if i+1 < len(lines) && trimmed[i+1] != "" && isAttribution(line+" "+trimmed[i+1]) {
+			n = 2
+		}
+		if n > 0 {
+			for j := i; j < i+n; j++ {
+				kinds[j] = ReplyAttribution
+			}
+			next := nextNonBlank(i + n)
+			if next >= 0 && !strings.HasPrefix(trimEOL(lines[next]), ">") {
+				rest(i+n, ReplyQuote)
+				break
+			}
+			i += n - 1
+			continue
+		}
+
+		// Outlook header blocks, with or without separator.
+		start := i
+		if originalMessageR.MatchString(line) || underscoreR.MatchString(line) {
+			next := nextNonBlank(i + 1)
+			if next < 0 || (underscoreR.MatchString(line) && !headerFromR.MatchString(trimmed[next])) {
+				continue
+			}
+			i = next
+		}
+		if start < i || (headerFromR.MatchString(trimmed[i]) && i+1 < len(lines) && headerSentR.MatchString(trimmed[i+1])) {
+			end := i
+			for end < len(lines) && trimmed[end] != "" {
+				end++
+			}
+			for j := start; j < end; j++ {
+				kinds[j] = ReplyAttribution
+			}
+			rest(end, ReplyQuote)
+			break
+		}
+		kinds[i] = ReplyContent
+	}
+
+	markSignature(trimmed, kinds)
+
+	var segs []ReplySegment
+	for i, l := range lines {
+		if len(segs) > 0 && segs[len(segs)-1].Kind == kinds[i] {
+			segs[len(segs)-1].Text += string(l)
+			continue
+		}
+		segs = append(segs, ReplySegment{Kind: kinds[i], Text: string(l)})
+	}
+	return segs
+}
+
+// markSignature marks the signature at the end of the last run of content
+// lines.
+func markSignature(lines []string, kinds []ReplyKind) {
+	end := len(lines)
+	for end > 0 && (kinds[end-1] != ReplyContent || lines[end-1] == "") {
+		end--
+	}
+	start := end
+	for start > 0 && kinds[start-1] == ReplyContent {
+		start--
+	}
+	if start == end {
+		return
+	}
+
+	sig := -1
+	for i := end - 1; i >= start && sig < 0; i-- {
+		if lines[i] == "--" || lines[i] == "-- " || mobileR.MatchString(lines[i]) {
+			sig = i
+		}
+	}
+	if sig < 0 {
+		// The bottom-most valediction wins, and the first line is never
+		// taken: a reply may well start with "Thanks!".
+		n := 0
+		for i := end - 1; i > start && n < maxSignatureLines && sig < 0; i-- {
+			if lines[i] == "" {
+				continue
+			}
+			n++
+			if valedictionR.MatchString(lines[i]) {
+				sig = i
+			}
+		}
+	}
+	if sig < 0 {
+		return
+	}
+	for i := sig; i < len(kinds) && kinds[i] == ReplyContent; i++ {
+		kinds[i] = ReplySignature
+	}
+}
+
+// SplitReplyHTML separates an HTML reply like SplitReply. The quoted history
+// is found through the markup mail clients use for it: Gmail's gmail_quote
+// and Yahoo's yahoo_quoted containers, blockquote type="cite" of Apple Mail
+// and Thunderbird, and Outlook's divRplyFwdMsg header block. Everything
+// from the first such element on is quoted; the rest is rendered with
+// HTMLToText and split with SplitReply. Gmail signatures are recognized by
+// their gmail_signature container.
+func SplitReplyHTML(doc string) []ReplySegment {
+	root, err := html.ParseWithOptions(strings.NewReader(doc), html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return SplitReply(doc)
+	}
+
+	var quote *html.Node
+	if marker := findNode(root, isQuoteMarker); marker != nil {
+		quote = detachFrom(marker)
+	}
+	var sig string
+	if n := findNode(root, func(n *html.Node) bool { return hasClass(n, "gmail_signature") }); n != nil {
+		n.Parent.RemoveChild(n)
+		sig = nodeText(n)
+	}
+
+	segs := SplitReply(nodeText(root))
+	if sig != "" {
+		segs = append(segs, ReplySegment{Kind: ReplySignature, Text: sig})
+	}
+	if quote != nil {
+		if attr := findNode(quote, isAttributionNode); attr != nil {
+			attr.Parent.RemoveChild(attr)
+			if text := nodeText(attr); text != "" {
+				segs = append(segs, ReplySegment{Kind: ReplyAttribution, Text: text})
+			}
+		}
+		if text := nodeText(quote); text != "" {
+			segs = append(segs, ReplySegment{Kind: ReplyQuote, Text: text})
+		}
+	}
+	return segs
+}
+
+func hasClass(n *html.Node, class string) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func isQuoteMarker(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch {
+	case hasClass(n, "gmail_quote"), hasClass(n, "yahoo_quoted"), hasClass(n, "protonmail_quote"), hasClass(n, "moz-cite-prefix"):
+		return true
+	case n.DataAtom == atom.Blockquote && strings.EqualFold(attr(n, "type"), "cite"):
+		return true
+	case attr(n, "id") == "divRplyFwdMsg" || attr(n, "id") == "appendonsend":
+		return true
+	}
+	return false
+}
+
+func isAttributionNode(n *html.Node) bool {
+	return hasClass(n, "gmail_attr") || hasClass(n, "moz-cite-prefix") || attr(n, "id") == "divRplyFwdMsg"
+}
+
+// findNode returns the first node below n, in document order, for which f
+// reports true.
+func findNode(n *html.Node, f func(*html.Node) bool) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if f(c) {
+			return c
+		}
+		if found := findNode(c, f); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// detachFrom removes n and everything following it in document order from
+// the tree, and returns them in a new div element.
+func detachFrom(n *html.Node) *html.Node {
+	div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for n != nil {
+		parent := n.Parent
+		for c := n; c != nil; {
+			next := c.NextSibling
+			parent.RemoveChild(c)
+			div.AppendChild(c)
+			c = next
+		}
+		// Continue with whatever follows the closest ancestor that has
+		// a next sibling.
+		for parent != nil && parent.NextSibling == nil {
+			parent = parent.Parent
+		}
+		if parent == nil {
+			break
+		}
+		n = parent.NextSibling
+	}
+	return div
+}
@@ -0,0 +1,112 @@
+package eml
+
+import (
+	"reflect"
+	"testing"
+)
+
+type replyTest struct {
+	name string
+	text string
+	segs []ReplySegment
+}
+
+var replyTests = []replyTest{
+	{
+		"top-posted english",
+		"Sounds good.\n\nOn Mon, 1 Jan 2024 at 10:00, Jane <jane@example.com> wrote:\n> Shall we meet?\n",
+		[]ReplySegment{
+			{ReplyContent, "Sounds good.\n\n"},
+			{ReplyAttribution, "On Mon, 1 Jan 2024 at 10:00, Jane <jane@example.com> wrote:\n"},
+			{ReplyQuote, "> Shall we meet?\n"},
+		},
+	},
+	{
+		"wrapped german attribution",
+		"Passt.\n\nAm 01.01.2024 um 10:00 schrieb Jane Doe\n<jane@example.com>:\n> Treffen wir uns?\n",
+		[]ReplySegment{
+			{ReplyContent, "Passt.\n\n"},
+			{ReplyAttribution, "Am 01.01.2024 um 10:00 schrieb Jane Doe\n<jane@example.com>:\n"},
+			{ReplyQuote, "> Treffen wir uns?\n"},
+		},
+	},
+	{
+		"interleaved with signature",
+		"> First question?\nFirst answer.\n> Second question?\nSecond answer.\n\n-- \nJohn\n",
+		[]ReplySegment{
+			{ReplyQuote, "> First question?\n"},
+			{ReplyContent, "First answer.\n"},
+			{ReplyQuote, "> Second question?\n"},
+			{ReplyContent, "Second answer.\n\n"},
+			{ReplySignature, "-- \nJohn\n"},
+		},
+	},
+	{
+		"outlook",
+		"Approved.\r\n\r\nBest regards,\r\nJohn Smith\r\nACME Corp\r\n\r\n-----Original Message-----\r\nFrom: Jane <jane@example.com>\r\nSent: Monday, January 1, 2024 10:00 AM\r\nSubject: Budget\r\n\r\nPlease approve.\r\n",
+		[]ReplySegment{
+			{ReplyContent, "Approved.\r\n\r\n"},
+			{ReplySignature, "Best regards,\r\nJohn Smith\r\nACME Corp\r\n\r\n"},
+			{ReplyAttribution, "-----Original Message-----\r\nFrom: Jane <jane@example.com>\r\nSent: Monday, January 1, 2024 10:00 AM\r\nSubject: Budget\r\n"},
+			{ReplyQuote, "\r\nPlease approve.\r\n"},
+		},
+	},
+	{
+		"french top-post without quote marks",
+		"D'accord.\nEnvoyé de mon iPhone\n\nLe 1 janv. 2024 à 10:00, Jane a écrit :\n\nOn se voit ?\n",
+		[]ReplySegment{
+			{ReplyContent, "D'accord.\n"},
+			{ReplySignature, "Envoyé de mon iPhone\n\n"},
+			{ReplyAttribution, "Le 1 janv. 2024 à 10:00, Jane a écrit :\n"},
+			{ReplyQuote, "\nOn se voit ?\n"},
+		},
+	},
+	{
+		"valediction as first line",
+		"Thanks!\n",
+		[]ReplySegment{
+			{ReplyContent, "Thanks!\n"},
+		},
+	},
+	{
+		"valediction at start and end",
+		"Thanks!\nI'll check it tomorrow and get back to you.\n\nBest,\nBob\n",
+		[]ReplySegment{
+			{ReplyContent, "Thanks!\nI'll check it tomorrow and get back to you.\n\n"},
+			{ReplySignature, "Best,\nBob\n"},
+		},
+	},
+	{
+		"no quote",
+		"Just text.\nMore text.\n",
+		[]ReplySegment{
+			{ReplyContent, "Just text.\nMore text.\n"},
+		},
+	},
+}
+
+func TestSplitReply(t *testing.T) {
+	for _, rt := range replyTests {
+		if segs := SplitReply(rt.text); !reflect.DeepEqual(segs, rt.segs) {
+			t.Errorf("%s: got %#v; expected %#v", rt.name, segs, rt.segs)
+		}
+	}
+}
+
+func TestSplitReplyHTML(t *testing.T) {
+	doc := `<div dir="ltr">Thanks, that works.<div class="gmail_signature">Jane<br>ACME</div></div><br>` +
+		`<div class="gmail_quote"><div class="gmail_attr">On Mon, Jan 1, 2024 John wrote:<br></div>` +
+		`<blockquote class="gmail_quote">Does it work?</blockquote></div>`
+	expected := []ReplySegment{
+		{ReplyContent, "Thanks, that works."},
+		{ReplySignature, "Jane\nACME"},
+		{ReplyAttribution, "On Mon, Jan 1, 2024 John wrote:"},
+		{ReplyQuote, "> Does it work?"},
+	}
+	if segs := SplitReplyHTML(doc); !reflect.DeepEqual(segs, expected) {
+		t.Errorf("got %#v; expected %#v", segs, expected)
+	}
+	if text := ReplyContentText(SplitReplyHTML(doc)); text != "Thanks, that works." {
+		t.Errorf("ReplyContentText = %q", text)
+	}
+}
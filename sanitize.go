@@ -0,0 +1,353 @@
+// Sanitizing of HTML bodies for display.
+
+package eml
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Sanitizer removes everything from HTML bodies that could run code, load
+// remote resources or otherwise escape the part of a page the body is shown
+// in. Elements, attributes and CSS properties are kept only if they are on
+// an allowlist. The zero value is a strict sanitizer that keeps inline cid:
+// images and removes remote images.
+type Sanitizer struct {
+	// ImageProxy, if set, rewrites the URL of a remote (http or https)
+	// image, typically to route it through a proxy that hides the reader
+	// from trackers. An empty result removes the image. Without
+	// ImageProxy, remote images are removed.
+	ImageProxy func(url string) string
+	// InlineImage, if set, rewrites a "cid:" image URL, given the
+	// Content-ID it refers to, into one the client can load. An empty
+	// result removes the image. Without InlineImage, cid: URLs are kept.
+	InlineImage func(cid string) string
+}
+
+// Removal describes something a Sanitizer removed.
+type Removal struct {
+	// Element is the name of the element that was removed, or whose
+	// attribute was removed.
+	Element string
+	// Attribute is the name of the removed attribute, or empty if the
+	// element was removed.
+	Attribute string
+	// Value is the removed attribute value or CSS declaration.
+	Value string
+	// Reason explains why it was removed.
+	Reason string
+}
+
+// SanitizeReport lists what a Sanitizer changed in a document.
+type SanitizeReport struct {
+	Removed []Removal
+	// ProxiedImages are the original URLs of remote images that were
+	// rewritten by ImageProxy.
+	ProxiedImages []string
+	// InlineImages are the Content-IDs referenced by kept cid: images.
+	InlineImages []string
+}
+
+// Reasons given in a Removal.
+const (
+	ReasonNotAllowed   = "not allowed"
+	ReasonScript       = "script"
+	ReasonEventHandler = "event handler"
+	ReasonUnsafeURL    = "unsafe URL"
+	ReasonRemoteImage  = "remote image"
+	ReasonRemoteLoad   = "remote resource"
+	ReasonUnsafeCSS    = "unsafe CSS"
+	ReasonForm         = "form"
+	ReasonNoSource     = "no source"
+)
+
+// Elements kept with their contents.
+var sanitizeElements = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.Address: true, atom.B: true,
+	atom.Big: true, atom.Blockquote: true, atom.Br: true, atom.Caption: true,
+	atom.Center: true, atom.Cite: true, atom.Code: true, atom.Col: true,
+	atom.Colgroup: true, atom.Dd: true, atom.Del: true, atom.Div: true,
+	atom.Dl: true, atom.Dt: true, atom.Em: true, atom.Font: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true,
+	atom.H6: true, atom.Hr: true, atom.I: true, atom.Img: true, atom.Ins: true,
+	atom.Kbd: true, atom.Li: true, atom.Ol: true, atom.P: true, atom.Pre: true,
+	atom.Q: true, atom.S: true, atom.Small: true, atom.Span: true,
+	atom.Strike: true, atom.Strong: true, atom.Sub: true, atom.Sup: true,
+	atom.Table: true, atom.Tbody: true, atom.Td: true, atom.Tfoot: true,
+	atom.Th: true, atom.Thead: true, atom.Tr: true, atom.Tt: true, atom.U: true,
+	atom.Ul: true,
+}
+
+// Elements removed along with their contents, and why.
+var sanitizeDropped = map[atom.Atom]string{
+	atom.Script: ReasonScript, atom.Noscript: ReasonScript,
+	atom.Template: ReasonScript, atom.Applet: ReasonScript,
+	atom.Object: ReasonRemoteLoad, atom.Embed: ReasonRemoteLoad,
+	atom.Iframe: ReasonRemoteLoad, atom.Frame: ReasonRemoteLoad,
+	atom.Frameset: ReasonRemoteLoad, atom.Link: ReasonRemoteLoad,
+	atom.Audio: ReasonRemoteLoad, atom.Video: ReasonRemoteLoad,
+	atom.Source: ReasonRemoteLoad, atom.Track: ReasonRemoteLoad,
+	atom.Base: ReasonRemoteLoad, atom.Meta: ReasonNotAllowed,
+	atom.Style: ReasonUnsafeCSS, atom.Title: ReasonNotAllowed,
+	atom.Input: ReasonForm, atom.Button: ReasonForm, atom.Select: ReasonForm,
+	atom.Textarea: ReasonForm, atom.Option: ReasonForm,
+}
+
+// Attributes allowed on all kept elements. URL attributes are handled
+// separately.
+var sanitizeAttributes = map[string]bool{
+	"abbr": true, "align": true, "alt": true, "bgcolor": true, "border": true,
+	"cellpadding": true, "cellspacing": true, "class": true, "color": true,
+	"colspan": true, "dir": true, "face": true, "headers": true,
+	"height": true, "lang": true, "nowrap": true, "rowspan": true,
+	"scope": true, "size": true, "span": true, "start": true, "style": true,
+	"summary": true, "title": true, "type": true, "valign": true,
+	"width": true,
+}
+
+// CSS properties allowed in style attributes. Prefixes ending in '-' allow
+// all properties starting with them.
+var sanitizeCSS = []string{
+	"background-color", "border", "border-", "color", "direction", "display",
+	"font", "font-", "height", "letter-spacing", "line-height", "list-style",
+	"list-style-type", "margin", "margin-", "max-height", "max-width",
+	"min-height", "min-width", "padding", "padding-", "table-layout",
+	"text-align", "text-decoration", "text-indent", "text-transform",
+	"vertical-align", "white-space", "width", "word-break", "word-spacing",
+	"word-wrap",
+}
+
+var (
+	urlSchemeR = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+	dataImageR = regexp.MustCompile(`^data:image/(png|gif|jpeg|webp);base64,[A-Za-z0-9+/=\s]*$`)
+	unsafeCSSR = regexp.MustCompile(`(?i)url\s*\(|expression|javascript:|vbscript:|@import|behavior|-moz-binding|\\|/\*`)
+)
+
+// Sanitize returns the contents of the body of the HTML document s, cleaned
+// as described for Sanitizer, and a report of what was removed. Comments,
+// including conditional comments, are always removed and not reported.
+func (s Sanitizer) Sanitize(doc string) (string, SanitizeReport) {
+	var rep SanitizeReport
+	root, err := html.ParseWithOptions(strings.NewReader(doc), html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return "", rep
+	}
+
+	var body *html.Node
+	var find func(n *html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Head:
+				s.clean(c, &rep)
+			case atom.Body, atom.Frameset:
+				body = c
+			case atom.Html:
+				find(c)
+			}
+		}
+	}
+	find(root)
+	if body == nil {
+		return "", rep
+	}
+	if body.DataAtom == atom.Frameset {
+		rep.Removed = append(rep.Removed, Removal{Element: "frameset", Reason: ReasonRemoteLoad})
+		return "", rep
+	}
+	s.clean(body, &rep)
+
+	var b strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&b, c)
+	}
+	return b.String(), rep
+}
+
+// clean sanitizes the children of n.
+func (s Sanitizer) clean(n *html.Node, rep *SanitizeReport) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			if reason, ok := sanitizeDropped[c.DataAtom]; ok || c.Namespace != "" {
+				if !ok {
+					reason = ReasonNotAllowed
+				}
+				rep.Removed = append(rep.Removed, Removal{Element: c.Data, Reason: reason})
+				n.RemoveChild(c)
+			} else if !sanitizeElements[c.DataAtom] {
+				// Unknown elements, such as Outlook's o:p, are replaced by
+				// their contents.
+				reason := ReasonNotAllowed
+				if c.DataAtom == atom.Form {
+					reason = ReasonForm
+				}
+				rep.Removed = append(rep.Removed, Removal{Element: c.Data, Reason: reason})
+				s.clean(c, rep)
+				for gc := c.FirstChild; gc != nil; gc = c.FirstChild {
+					c.RemoveChild(gc)
+					n.InsertBefore(gc, c)
+				}
+				n.RemoveChild(c)
+			} else if s.cleanAttributes(c, rep) {
+				s.clean(c, rep)
+			} else {
+				n.RemoveChild(c)
+			}
+		default:
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+// cleanAttributes removes the attributes of n that are not allowed. It
+// reports false if n has to be removed entirely, as for images that may not
+// be loaded.
+func (s Sanitizer) cleanAttributes(n *html.Node, rep *SanitizeReport) bool {
+	attrs := n.Attr[:0]
+	remove := func(a html.Attribute, reason string) {
+		rep.Removed = append(rep.Removed, Removal{Element: n.Data, Attribute: a.Key, Value: a.Val, Reason: reason})
+	}
+	keep := true
+	hasSrc := false
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		switch {
+		case a.Namespace != "":
+			remove(a, ReasonNotAllowed)
+		case strings.HasPrefix(key, "on"):
+			remove(a, ReasonEventHandler)
+		case key == "href" && n.DataAtom == atom.A:
+			if !safeLink(a.Val) {
+				remove(a, ReasonUnsafeURL)
+				continue
+			}
+			attrs = append(attrs, a)
+		case key == "src" && n.DataAtom == atom.Img:
+			hasSrc = true
+			src, reason := s.imageSource(a.Val, rep)
+			if reason != "" {
+				remove(a, reason)
+				keep = false
+				continue
+			}
+			a.Val = src
+			attrs = append(attrs, a)
+		case key == "background", key == "srcset", key == "lowsrc", key == "dynsrc", key == "poster":
+			remove(a, ReasonRemoteLoad)
+		case key == "style":
+			a.Val = cleanStyle(n.Data, a.Val, rep)
+			if a.Val != "" {
+				attrs = append(attrs, a)
+			}
+		case sanitizeAttributes[key]:
+			attrs = append(attrs, a)
+		default:
+			remove(a, ReasonNotAllowed)
+		}
+	}
+	n.Attr = attrs
+	if n.DataAtom == atom.Img && !hasSrc {
+		rep.Removed = append(rep.Removed, Removal{Element: n.Data, Reason: ReasonNoSource})
+		return false
+	}
+	return keep
+}
+
+// urlScheme returns the lower-cased scheme of a URL, ignoring the control
+// characters and white space browsers skip, or "" for relative URLs.
+func urlScheme(u string) string {
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, u)
+	m := urlSchemeR.FindStringSubmatch(u)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// safeLink reports whether a link target may be kept.
+func safeLink(u string) bool {
+	switch urlScheme(u) {
+	case "http", "https", "mailto", "tel", "cid", "mid":
+		return true
+	case "":
+		// Relative links lead nowhere in a message, but are harmless.
+		return !strings.Contains(u, ":")
+	}
+	return false
+}
+
+// imageSource returns the URL an image may be loaded from, or the reason
+// the image has to be removed.
+func (s Sanitizer) imageSource(src string, rep *SanitizeReport) (string, string) {
+	src = strings.TrimSpace(src)
+	switch urlScheme(src) {
+	case "cid":
+		cid := strings.Trim(src[len("cid:"):], "<>")
+		if s.InlineImage != nil {
+			if src = s.InlineImage(cid); src == "" {
+				return "", ReasonNotAllowed
+			}
+		}
+		rep.InlineImages = append(rep.InlineImages, cid)
+		return src, ""
+	case "http", "https":
+		if s.ImageProxy == nil {
+			return "", ReasonRemoteImage
+		}
+		proxied := s.ImageProxy(src)
+		if proxied == "" {
+			return "", ReasonRemoteImage
+		}
+		rep.ProxiedImages = append(rep.ProxiedImages, src)
+		return proxied, ""
+	case "data":
+		if dataImageR.MatchString(src) {
+			return src, ""
+		}
+	}
+	return "", ReasonUnsafeURL
+}
+
+// cleanStyle removes the declarations of a style attribute whose property is
+// not allowed or whose value could load resources or run code.
+func cleanStyle(element, style string, rep *SanitizeReport) string {
+	var kept []string
+	for _, decl := range strings.Split(style, ";") {
+		decl = strings.TrimSpace(decl)
+		if decl == "" {
+			continue
+		}
+		prop, value, ok := strings.Cut(decl, ":")
+		prop = strings.ToLower(strings.TrimSpace(prop))
+		if !ok || !allowedCSS(prop) || unsafeCSSR.MatchString(value) {
+			rep.Removed = append(rep.Removed, Removal{Element: element, Attribute: "style", Value: decl, Reason: ReasonUnsafeCSS})
+			continue
+		}
+		kept = append(kept, prop+": "+strings.TrimSpace(value))
+	}
+	return strings.Join(kept, "; ")
+}
+
+func allowedCSS(prop string) bool {
+	for _, p := range sanitizeCSS {
+		if prop == p || (strings.HasSuffix(p, "-") && strings.HasPrefix(prop, p)) {
+			return true
+		}
+	}
+	return false
+}
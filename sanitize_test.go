@@ -0,0 +1,100 @@
+package eml
+
+import (
+	"reflect"
+	"testing"
+)
+
+type sanitizeTest struct {
+	html    string
+	out     string
+	removed []Removal
+}
+
+var sanitizeTests = []sanitizeTest{
+	{
+		`<p onclick="steal()">Hi <b>there</b></p><script>alert(1)</script>`,
+		`<p>Hi <b>there</b></p>`,
+		[]Removal{
+			{Element: "p", Attribute: "onclick", Value: "steal()", Reason: ReasonEventHandler},
+			{Element: "script", Reason: ReasonScript},
+		},
+	},
+	{
+		`<a href=" java&#x09;script:alert(1)">x</a><a href="https://example.com/">y</a>`,
+		`<a>x</a><a href="https://example.com/">y</a>`,
+		[]Removal{
+			{Element: "a", Attribute: "href", Value: " java\tscript:alert(1)", Reason: ReasonUnsafeURL},
+		},
+	},
+	{
+		`<form action="https://evil.test/"><input name="pw">Login</form>`,
+		`Login`,
+		[]Removal{
+			{Element: "form", Reason: ReasonForm},
+			{Element: "input", Reason: ReasonForm},
+		},
+	},
+	{
+		`<div style="color: red; background: url(https://t.test/p.gif); position: fixed">x</div>`,
+		`<div style="color: red">x</div>`,
+		[]Removal{
+			{Element: "div", Attribute: "style", Value: "background: url(https://t.test/p.gif)", Reason: ReasonUnsafeCSS},
+			{Element: "div", Attribute: "style", Value: "position: fixed", Reason: ReasonUnsafeCSS},
+		},
+	},
+	{
+		`<img src="https://t.test/pixel.gif" width="1"><img src="cid:logo@x" alt="Logo"><!--[if mso]>x<![endif]--><o:p>text</o:p>`,
+		`<img src="cid:logo@x" alt="Logo"/>text`,
+		[]Removal{
+			{Element: "img", Attribute: "src", Value: "https://t.test/pixel.gif", Reason: ReasonRemoteImage},
+			{Element: "o:p", Reason: ReasonNotAllowed},
+		},
+	},
+	{
+		`<p>a<img alt="missing" width="1">b</p>`,
+		`<p>ab</p>`,
+		[]Removal{
+			{Element: "img", Reason: ReasonNoSource},
+		},
+	},
+	{
+		`<html><head><style>body{}</style><link rel="stylesheet" href="https://x.test/a.css"></head><body><table background="https://x.test/bg.png"><tr><td>x</td></tr></table></body></html>`,
+		`<table><tbody><tr><td>x</td></tr></tbody></table>`,
+		[]Removal{
+			{Element: "style", Reason: ReasonUnsafeCSS},
+			{Element: "link", Reason: ReasonRemoteLoad},
+			{Element: "table", Attribute: "background", Value: "https://x.test/bg.png", Reason: ReasonRemoteLoad},
+		},
+	},
+}
+
+func TestSanitize(t *testing.T) {
+	for _, st := range sanitizeTests {
+		out, rep := Sanitizer{}.Sanitize(st.html)
+		if out != st.out {
+			t.Errorf("Sanitize(%q) = %q; expected %q", st.html, out, st.out)
+		}
+		if !reflect.DeepEqual(rep.Removed, st.removed) {
+			t.Errorf("Sanitize(%q) removed %#v; expected %#v", st.html, rep.Removed, st.removed)
+		}
+	}
+}
+
+func TestSanitizeImages(t *testing.T) {
+	s := Sanitizer{
+		ImageProxy: func(url string) string {
+			return "https://proxy.test/?u=" + url
+		},
+		InlineImage: func(cid string) string {
+			return "/attachments/" + cid
+		},
+	}
+	out, rep := s.Sanitize(`<img src="http://t.test/a.png"><img src="cid:<img1>">`)
+	if expected := `<img src="https://proxy.test/?u=http://t.test/a.png"/><img src="/attachments/img1"/>`; out != expected {
+		t.Errorf("got %q; expected %q", out, expected)
+	}
+	if !reflect.DeepEqual(rep.ProxiedImages, []string{"http://t.test/a.png"}) || !reflect.DeepEqual(rep.InlineImages, []string{"img1"}) {
+		t.Errorf("unexpected report %#v", rep)
+	}
+}
@@ -0,0 +1,341 @@
+// Verification and decryption of S/MIME messages (RFC 8551).
+
+package eml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+)
+
+// maxSMIMELayers bounds the nesting of signed and enveloped entities that
+// Open unwraps.
+const maxSMIMELayers = 8
+
+// SMIMEOpener verifies and decrypts S/MIME messages.
+type SMIMEOpener struct {
+	// Roots are the trusted certificate authorities. If nil, the system
+	// pool is used.
+	Roots *x509.CertPool
+	// Intermediates are used to build chains in addition to the
+	// certificates included in the signature.
+	Intermediates *x509.CertPool
+	// Certificate and Key decrypt enveloped messages. Certificate selects
+	// the recipient; if it is nil, Key is tried for every recipient.
+	Certificate *x509.Certificate
+	Key         crypto.Decrypter
+	// Now returns the time certificates are checked at. If nil, time.Now
+	// is used.
+	Now func() time.Time
+}
+
+// SMIMESignature is the result of verifying one signer of a message.
+type SMIMESignature struct {
+	// Status is AuthPass if the signature is valid, the signer
+	// certificate chains up to a trusted root and names the From or
+	// Sender address of the message (RFC 8550 section 3), AuthFail
+	// otherwise.
+	Status AuthStatus
+	// Signer is the certificate of the signer, if it was included.
+	Signer *x509.Certificate
+	// Chains are the verified chains from Signer to a root.
+	Chains [][]*x509.Certificate
+	// Certificates are all certificates included with the signature.
+	Certificates []*x509.Certificate
+	SigningTime  time.Time
+	// Detached is true for multipart/signed messages and false for
+	// signed-data in application/pkcs7-mime.
+	Detached bool
+	Err      error
+}
+
+// SMIMEResult is the outcome of opening an S/MIME message.
+type SMIMEResult struct {
+	// Raw is the innermost entity with the header fields of the outer
+	// message that it does not replace.
+	Raw RawMessage
+	// Message is Raw processed as by Process.
+	Message Message
+	// Encrypted tells whether any layer was enveloped data.
+	Encrypted bool
+	// Signatures lists the signers of all signed layers, outermost first.
+	Signatures []SMIMESignature
+	// Unprotected lists the names of the header fields of Raw that come
+	// from outside the outermost S/MIME layer, so that anyone on the way
+	// may have changed or added them. Most clients leave From and Subject
+	// there.
+	Unprotected []string
+}
+
+// Open unwraps the S/MIME layers of a message: multipart/signed with
+// protocol application/pkcs7-signature, and application/pkcs7-mime holding
+// signed or enveloped data. Signatures are verified and reported in the
+// result; enveloped data is decrypted with Key. The innermost entity is then
+// processed as a normal message. Messages without S/MIME are processed as
+// they are. Open fails if a layer cannot be parsed or decrypted.
+func (o SMIMEOpener) Open(r RawMessage) (SMIMEResult, error) {
+	var res SMIMEResult
+	unprotected := map[string]bool{}
+	for _, h := range r.RawHeaders {
+		unprotected[strings.ToLower(string(h.Key))] = true
+	}
+	for layer := 0; ; layer++ {
+		if layer == maxSMIMELayers {
+			return res, errors.New("smime: too many nested layers")
+		}
+		inner, err := o.unwrap(r, &res)
+		if err != nil {
+			return res, err
+		}
+		if inner == nil {
+			break
+		}
+		entity, err := ParseRaw(inner)
+		if err != nil {
+			return res, fmt.Errorf("smime: inner entity: %v", err)
+		}
+		r = mergeHeaders(r, entity)
+		for name := range unprotected {
+			if rawHeader(entity, name) != "" {
+				delete(unprotected, name)
+			}
+		}
+	}
+	res.Raw = r
+	for _, h := range r.RawHeaders {
+		name := strings.ToLower(string(h.Key))
+		if unprotected[name] {
+			res.Unprotected = append(res.Unprotected, string(h.Key))
+			delete(unprotected, name)
+		}
+	}
+	checkSender(r, res.Signatures)
+	var err error
+	res.Message, err = Process(r)
+	return res, err
+}
+
+// checkSender fails the passing signatures whose certificate names neither
+// the From nor the Sender address of r.
+func checkSender(r RawMessage, sigs []SMIMESignature) {
+	from, _ := ParseAddressListLenient([]byte(rawHeader(r, "From")))
+	sender, _ := ParseAddressListLenient([]byte(rawHeader(r, "Sender")))
+	var addrs []string
+	for _, a := range append(from, sender...) {
+		if a.Email() != "" {
+			addrs = append(addrs, a.Email())
+		}
+	}
+	for i := range sigs {
+		if sigs[i].Status != AuthPass || certificateNames(sigs[i].Signer, addrs) {
+			continue
+		}
+		sigs[i].Status = AuthFail
+		if len(addrs) == 0 {
+			sigs[i].Err = errors.New("smime: message has no From address to match the signer")
+		} else {
+			sigs[i].Err = fmt.Errorf("smime: signer certificate is not for %s", strings.Join(addrs, ", "))
+		}
+	}
+}
+
+// oidEmailAddress is the emailAddress attribute older certificates carry in
+// their subject instead of a subject alternative name.
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// certificateNames reports whether cert is issued for any of addrs,
+// compared case-insensitively.
+func certificateNames(cert *x509.Certificate, addrs []string) bool {
+	names := cert.EmailAddresses
+	for _, n := range cert.Subject.Names {
+		if v, ok := n.Value.(string); ok && n.Type.Equal(oidEmailAddress) {
+			names = append(names, v)
+		}
+	}
+	for _, name := range names {
+		for _, a := range addrs {
+			if strings.EqualFold(name, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unwrap removes one S/MIME layer from r and returns the entity it holds,
+// or nil if r is not S/MIME.
+func (o SMIMEOpener) unwrap(r RawMessage, res *SMIMEResult) ([]byte, error) {
+	mt, ps, err := mime.ParseMediaType(rawHeader(r, "Content-Type"))
+	if err != nil {
+		return nil, nil
+	}
+	switch mt {
+	case "multipart/signed":
+		switch strings.ToLower(ps["protocol"]) {
+		case "application/pkcs7-signature", "application/x-pkcs7-signature":
+		default:
+			return nil, nil
+		}
+		parts := rawMultipartParts(r.Body, ps["boundary"])
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("smime: multipart/signed with %d parts", len(parts))
+		}
+		sigPart, err := ParseRaw(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("smime: signature part: %v", err)
+		}
+		der, _, err := decodeByTransferEncoding(sigPart.Body, rawHeader(sigPart, "Content-Transfer-Encoding"))
+		if err != nil {
+			return nil, err
+		}
+		content := crlfLines(parts[0])
+		if _, err := o.verify(der, content, res); err != nil {
+			return nil, err
+		}
+		return content, nil
+	case "application/pkcs7-mime", "application/x-pkcs7-mime":
+		der, _, err := decodeByTransferEncoding(r.Body, rawHeader(r, "Content-Transfer-Encoding"))
+		if err != nil {
+			return nil, err
+		}
+		ci, err := parseContentInfo(der)
+		if err != nil {
+			return nil, fmt.Errorf("smime: %v", err)
+		}
+		switch {
+		case ci.ContentType.Equal(oidSignedData):
+			return o.verify(der, nil, res)
+		case ci.ContentType.Equal(oidEnvelopedData):
+			if o.Key == nil {
+				return nil, errors.New("smime: message is encrypted, but no key was given")
+			}
+			res.Encrypted = true
+			inner, err := decryptEnvelopedData(ci.Content, o.Certificate, o.Key)
+			if err != nil {
+				return nil, fmt.Errorf("smime: %v", err)
+			}
+			return inner, nil
+		}
+		return nil, fmt.Errorf("smime: unsupported content type %s", ci.ContentType)
+	}
+	return nil, nil
+}
+
+// verify checks a SignedData, over detached if it is not nil, adds its
+// signers to res and returns the encapsulated content.
+func (o SMIMEOpener) verify(der, detached []byte, res *SMIMEResult) ([]byte, error) {
+	ci, err := parseContentInfo(der)
+	if err != nil {
+		return nil, fmt.Errorf("smime: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("smime: signature of type %s", ci.ContentType)
+	}
+	content, certs, signers, err := verifySignedData(ci.Content, detached)
+	if err != nil {
+		return nil, fmt.Errorf("smime: %v", err)
+	}
+
+	now := time.Now
+	if o.Now != nil {
+		now = o.Now
+	}
+	intermediates := x509.NewCertPool()
+	if o.Intermediates != nil {
+		intermediates = o.Intermediates.Clone()
+	}
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+	for _, s := range signers {
+		sig := SMIMESignature{
+			Status:       AuthFail,
+			Signer:       s.signer,
+			Certificates: certs,
+			SigningTime:  s.signingTime,
+			Detached:     detached != nil,
+			Err:          s.err,
+		}
+		if sig.Err == nil {
+			sig.Chains, sig.Err = s.signer.Verify(x509.VerifyOptions{
+				Roots:         o.Roots,
+				Intermediates: intermediates,
+				CurrentTime:   now(),
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+			})
+		}
+		if sig.Err == nil {
+			sig.Status = AuthPass
+		}
+		res.Signatures = append(res.Signatures, sig)
+	}
+	return content, nil
+}
+
+// rawHeader returns the value of the first header field of r with the given
+// name, matched case-insensitively.
+func rawHeader(r RawMessage, key string) string {
+	for _, h := range r.RawHeaders {
+		if strings.EqualFold(string(h.Key), key) {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// mergeHeaders returns the entity inner with the header fields of outer
+// whose names it does not use, such as From and Subject, placed before its
+// own.
+func mergeHeaders(outer, inner RawMessage) RawMessage {
+	merged := RawMessage{Body: inner.Body}
+	for _, h := range outer.RawHeaders {
+		if rawHeader(inner, string(h.Key)) == "" && !strings.HasPrefix(strings.ToLower(string(h.Key)), "content-") {
+			merged.RawHeaders = append(merged.RawHeaders, h)
+		}
+	}
+	merged.RawHeaders = append(merged.RawHeaders, inner.RawHeaders...)
+	return merged
+}
+
+// rawMultipartParts splits a multipart body at its boundaries and returns
+// the parts exactly as they appear, headers included. The line break
+// before each delimiter belongs to the delimiter (RFC 2046 section 5.1.1).
+func rawMultipartParts(body []byte, boundary string) [][]byte {
+	if boundary == "" {
+		return nil
+	}
+	delim := []byte("--" + boundary)
+	var parts [][]byte
+	start := -1
+	offset := 0
+	for _, line := range splitLines(body) {
+		trimmed := bytes.TrimRight(line, " \t\r\n")
+		if bytes.HasPrefix(trimmed, delim) {
+			suffix := trimmed[len(delim):]
+			if len(suffix) == 0 || bytes.Equal(suffix, []byte("--")) {
+				if start >= 0 {
+					end := offset
+					if end > start && body[end-1] == '\n' {
+						end--
+						if end > start && body[end-1] == '\r' {
+							end--
+						}
+					}
+					parts = append(parts, body[start:end])
+				}
+				if len(suffix) > 0 {
+					return parts
+				}
+				start = offset + len(line)
+			}
+		}
+		offset += len(line)
+	}
+	return parts
+}
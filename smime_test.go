@@ -0,0 +1,331 @@
+package eml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	oidTestData   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidTestSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidTestRSA    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// smimeTestPKI is a certificate authority with one S/MIME certificate.
+type smimeTestPKI struct {
+	roots *x509.CertPool
+	cert  *x509.Certificate
+	key   *rsa.PrivateKey
+}
+
+func newSMIMETestPKI(t *testing.T) smimeTestPKI {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Unix(1600000000, 0),
+		NotAfter:              time.Unix(1900000000, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ = x509.ParseCertificate(caDER)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "Joe SixPack"},
+		EmailAddresses: []string{"joe@football.example.com"},
+		NotBefore:      time.Unix(1600000000, 0),
+		NotAfter:       time.Unix(1900000000, 0),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ = x509.ParseCertificate(leafDER)
+
+	pki := smimeTestPKI{roots: x509.NewCertPool(), cert: leaf, key: key}
+	pki.roots.AddCert(ca)
+	return pki
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (p smimeTestPKI) issuerAndSerial(t *testing.T) asn1.RawValue {
+	return asn1.RawValue{FullBytes: mustMarshal(t, cmsIssuerAndSerial{Issuer: asn1.RawValue{FullBytes: p.cert.RawIssuer}, Serial: p.cert.SerialNumber})}
+}
+
+// sign returns a SignedData ContentInfo over content, which is left out
+// if detached is set.
+func (p smimeTestPKI) sign(t *testing.T, content []byte, detached bool) []byte {
+	attr := func(oid asn1.ObjectIdentifier, value interface{}) []byte {
+		return derEncode([]byte{0x30}, append(mustMarshal(t, oid), derEncode([]byte{0x31}, mustMarshal(t, value))...))
+	}
+	digest := sha256.Sum256(content)
+	attrs := append(attr(oidAttrContentType, oidTestData), attr(oidAttrMessageDigest, digest[:])...)
+	attrs = append(attrs, attr(oidAttrSigningTime, time.Unix(1700000000, 0).UTC())...)
+	signed := sha256.Sum256(derEncode([]byte{0x31}, attrs))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, signed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd := cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidTestSHA256}},
+		EncapContentInfo: cmsEncapContentInfo{EContentType: oidTestData},
+		Certificates:     asn1.RawValue{FullBytes: derEncode([]byte{0xa0}, p.cert.Raw)},
+		SignerInfos: []cmsSignerInfo{{
+			Version:            1,
+			SID:                p.issuerAndSerial(t),
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidTestSHA256},
+			SignedAttrs:        asn1.RawValue{FullBytes: derEncode([]byte{0xa0}, attrs)},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidTestRSA},
+			Signature:          sig,
+		}},
+	}
+	if !detached {
+		sd.EncapContentInfo.EContent = asn1.RawValue{FullBytes: derEncode([]byte{0xa0}, mustMarshal(t, content))}
+	}
+	return mustMarshal(t, cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{FullBytes: derEncode([]byte{0xa0}, mustMarshal(t, sd))},
+	})
+}
+
+// encrypt returns an EnvelopedData ContentInfo of content for the
+// certificate, using AES-256-CBC.
+func (p smimeTestPKI) encrypt(t *testing.T, content []byte) []byte {
+	cek := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	rand.Read(cek)
+	rand.Read(iv)
+	pad := aes.BlockSize - len(content)%aes.BlockSize
+	plain := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	block, _ := aes.NewCipher(cek)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(plain, plain)
+	encKey, err := rsa.EncryptPKCS1v15(rand.Reader, &p.key.PublicKey, cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed := cmsEnvelopedData{
+		RecipientInfos: []asn1.RawValue{{FullBytes: mustMarshal(t, cmsKeyTransRecipientInfo{
+			RID:                    p.issuerAndSerial(t),
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidTestRSA},
+			EncryptedKey:           encKey,
+		})}},
+		EncryptedContentInfo: cmsEncryptedContentInfo{
+			ContentType:                oidTestData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: mustMarshal(t, iv)}},
+			EncryptedContent:           asn1.RawValue{FullBytes: derEncode([]byte{0x80}, plain)},
+		},
+	}
+	return mustMarshal(t, cmsContentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{FullBytes: derEncode([]byte{0xa0}, mustMarshal(t, ed))},
+	})
+}
+
+func wrapBase64(b []byte) string {
+	s := base64.StdEncoding.EncodeToString(b)
+	var out strings.Builder
+	for len(s) > 76 {
+		out.WriteString(s[:76] + "\r\n")
+		s = s[76:]
+	}
+	return out.String() + s + "\r\n"
+}
+
+const smimeInner = "Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"We lost the game.\r\n"
+
+const smimeOuter = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"MIME-Version: 1.0\r\n"
+
+func (p smimeTestPKI) detached(t *testing.T, inner string) string {
+	return "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"sig\"\r\n" +
+		"\r\n" +
+		"--sig\r\n" +
+		inner +
+		"\r\n--sig\r\n" +
+		"Content-Type: application/pkcs7-signature; name=smime.p7s\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		wrapBase64(p.sign(t, []byte(inner), true)) +
+		"--sig--\r\n"
+}
+
+func pkcs7MIME(smimeType string, der []byte) string {
+	return "Content-Type: application/pkcs7-mime; smime-type=" + smimeType + "; name=smime.p7m\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		wrapBase64(der)
+}
+
+func TestSMIMEOpen(t *testing.T) {
+	pki := newSMIMETestPKI(t)
+	other := newSMIMETestPKI(t)
+	now := func() time.Time { return time.Unix(1700000000, 0) }
+	opener := SMIMEOpener{Roots: pki.roots, Certificate: pki.cert, Key: pki.key, Now: now}
+
+	type test struct {
+		name      string
+		msg       string
+		opener    SMIMEOpener
+		status    []AuthStatus
+		encrypted bool
+	}
+	tests := []test{
+		{"detached", smimeOuter + pki.detached(t, smimeInner), opener, []AuthStatus{AuthPass}, false},
+		{"opaque", smimeOuter + pkcs7MIME("signed-data", pki.sign(t, []byte(smimeInner), false)), opener, []AuthStatus{AuthPass}, false},
+		{"encrypted", smimeOuter + pkcs7MIME("enveloped-data", pki.encrypt(t, []byte(smimeInner))), opener, nil, true},
+		{"signed, then encrypted", smimeOuter + pkcs7MIME("enveloped-data", pki.encrypt(t, []byte(pki.detached(t, smimeInner)))), opener, []AuthStatus{AuthPass}, true},
+		{"untrusted", smimeOuter + pki.detached(t, smimeInner), SMIMEOpener{Roots: other.roots, Now: now}, []AuthStatus{AuthFail}, false},
+		{"expired", smimeOuter + pki.detached(t, smimeInner), SMIMEOpener{Roots: pki.roots, Now: func() time.Time { return time.Unix(2000000000, 0) }}, []AuthStatus{AuthFail}, false},
+		{"altered", smimeOuter + strings.Replace(pki.detached(t, smimeInner), "lost", "won", 1), opener, []AuthStatus{AuthFail}, false},
+		{"LF line endings", strings.ReplaceAll(smimeOuter+pki.detached(t, smimeInner), "\r\n", "\n"), opener, []AuthStatus{AuthPass}, false},
+		{"not S/MIME", smimeOuter + smimeInner, opener, nil, false},
+		// The certificate must name the sender.
+		{"other sender", strings.Replace(smimeOuter, "joe@", "ceo@", 1) + pki.detached(t, smimeInner), opener, []AuthStatus{AuthFail}, false},
+		{"sender field", strings.Replace(smimeOuter, "joe@", "ceo@", 1) + "Sender: <JOE@football.example.com>\r\n" + pki.detached(t, smimeInner), opener, []AuthStatus{AuthPass}, false},
+		{"protected other sender", smimeOuter + pki.detached(t, "From: ceo@football.example.com\r\n"+smimeInner), opener, []AuthStatus{AuthFail}, false},
+	}
+	for _, tt := range tests {
+		r, err := ParseRaw([]byte(tt.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := tt.opener.Open(r)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var status []AuthStatus
+		for _, s := range res.Signatures {
+			status = append(status, s.Status)
+			if s.Signer == nil || s.Signer.Subject.CommonName != "Joe SixPack" {
+				t.Errorf("%s: signer not reported", tt.name)
+			}
+			if (s.Status == AuthPass) != (s.Err == nil) {
+				t.Errorf("%s: status %s with error %v", tt.name, s.Status, s.Err)
+			}
+		}
+		if len(status) != len(tt.status) || (len(status) > 0 && status[0] != tt.status[0]) {
+			t.Errorf("%s: got signatures %v; expected %v", tt.name, status, tt.status)
+		}
+		if res.Encrypted != tt.encrypted {
+			t.Errorf("%s: got encrypted %v", tt.name, res.Encrypted)
+		}
+		if tt.name == "altered" {
+			continue
+		}
+		if res.Message.Text != "We lost the game.\r\n" && res.Message.Text != "We lost the game.\n" {
+			t.Errorf("%s: got text %q", tt.name, res.Message.Text)
+		}
+		if s := res.Message.FullHeaders["Subject"]; len(s) != 1 || s[0] != "Is dinner ready?" {
+			t.Errorf("%s: outer header fields lost: %v", tt.name, res.Message.FullHeaders)
+		}
+	}
+}
+
+func TestSMIMEUnprotected(t *testing.T) {
+	pki := newSMIMETestPKI(t)
+	opener := SMIMEOpener{Roots: pki.roots, Now: func() time.Time { return time.Unix(1700000000, 0) }}
+	tests := []struct {
+		msg         string
+		unprotected []string
+	}{
+		{smimeOuter + pki.detached(t, smimeInner), []string{"From", "To", "Subject", "MIME-Version"}},
+		{smimeOuter + pki.detached(t, "Subject: Is dinner ready?\r\n"+smimeInner), []string{"From", "To", "MIME-Version"}},
+		{smimeOuter + smimeInner, []string{"From", "To", "Subject", "MIME-Version", "Content-Type"}},
+	}
+	for _, tt := range tests {
+		r, err := ParseRaw([]byte(tt.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := opener.Open(r)
+		if err != nil || !reflect.DeepEqual(res.Unprotected, tt.unprotected) {
+			t.Errorf("got unprotected %v, %v; expected %v", res.Unprotected, err, tt.unprotected)
+		}
+	}
+}
+
+func TestSMIMEOpenErrors(t *testing.T) {
+	pki := newSMIMETestPKI(t)
+	other := newSMIMETestPKI(t)
+	encrypted := smimeOuter + pkcs7MIME("enveloped-data", pki.encrypt(t, []byte(smimeInner)))
+	tests := map[string]SMIMEOpener{
+		"no key":            {},
+		"other recipient":   {Certificate: other.cert, Key: other.key},
+		"wrong key":         {Key: other.key},
+		"garbled signature": {},
+	}
+	for name, o := range tests {
+		msg := encrypted
+		if name == "garbled signature" {
+			msg = smimeOuter + pkcs7MIME("signed-data", []byte("not a signature"))
+		}
+		r, err := ParseRaw([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := o.Open(r); err == nil {
+			t.Errorf("%s: Open did not fail", name)
+		}
+	}
+}
+
+func TestBERToDER(t *testing.T) {
+	// An indefinite length SEQUENCE holding a constructed OCTET STRING.
+	ber := []byte{0x30, 0x80, 0x24, 0x80, 0x04, 0x02, 'a', 'b', 0x04, 0x01, 'c', 0x00, 0x00, 0x02, 0x01, 0x05, 0x00, 0x00}
+	want := []byte{0x30, 0x08, 0x04, 0x03, 'a', 'b', 'c', 0x02, 0x01, 0x05}
+	der, err := berToDER(ber)
+	if err != nil || !bytes.Equal(der, want) {
+		t.Errorf("berToDER = % x, %v; expected % x", der, err, want)
+	}
+	for _, bad := range [][]byte{{0x30, 0x80, 0x04, 0x01, 'a'}, {0x04, 0x80, 0x00, 0x00}, {0x30, 0x05, 0x04}} {
+		if _, err := berToDER(bad); err == nil {
+			t.Errorf("berToDER(% x) did not fail", bad)
+		}
+	}
+}
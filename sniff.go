@@ -0,0 +1,413 @@
+// Content sniffing of attachments and detection of disguised file names.
+
+package eml
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// fileType is a file format recognized by its content.
+type fileType struct {
+	mediaType string
+	// aliases are other media types senders declare for the format.
+	aliases []string
+	// exts are the file name extensions of the format, in lower case and
+	// without dot.
+	exts []string
+	// executable formats are run rather than opened by a viewer.
+	executable bool
+	match      func(data []byte) bool
+}
+
+// prefix returns a match function for data starting with any of magic.
+func prefix(magic ...string) func([]byte) bool {
+	return func(data []byte) bool {
+		for _, m := range magic {
+			if bytes.HasPrefix(data, []byte(m)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// zipEntries reports whether data is a ZIP archive whose central directory
+// lists all of names.
+func zipEntries(data []byte, names ...string) bool {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return false
+	}
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false
+	}
+	found := map[string]bool{}
+	for _, f := range z.File {
+		found[f.Name] = true
+	}
+	for _, name := range names {
+		if !found[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// isPE reports whether data starts with the DOS header of a Portable
+// Executable, whose e_lfanew field points to the PE signature.
+func isPE(data []byte) bool {
+	if len(data) < 0x40 || !bytes.HasPrefix(data, []byte("MZ")) {
+		return false
+	}
+	offset := binary.LittleEndian.Uint32(data[0x3c:])
+	return uint64(offset)+4 <= uint64(len(data)) && string(data[offset:offset+4]) == "PE\x00\x00"
+}
+
+// fileTypes lists the formats SniffType recognizes. More specific formats
+// come before the ones they are built on.
+var fileTypes = []fileType{
+	{
+		mediaType:  "application/x-msdownload",
+		aliases:    []string{"application/x-msdos-program", "application/x-dosexec", "application/vnd.microsoft.portable-executable"},
+		exts:       []string{"exe", "dll", "scr", "com", "pif", "cpl", "sys", "ocx", "drv"},
+		executable: true,
+		match:      isPE,
+	},
+	{
+		mediaType:  "application/x-executable",
+		aliases:    []string{"application/x-elf", "application/x-sharedlib"},
+		exts:       []string{"", "bin", "elf", "so", "o", "run"},
+		executable: true,
+		match:      prefix("\x7fELF"),
+	},
+	{
+		mediaType:  "application/x-mach-binary",
+		exts:       []string{"", "dylib", "bundle"},
+		executable: true,
+		match:      prefix("\xfe\xed\xfa\xce", "\xfe\xed\xfa\xcf", "\xce\xfa\xed\xfe", "\xcf\xfa\xed\xfe"),
+	},
+	{
+		mediaType:  "application/x-ms-shortcut",
+		exts:       []string{"lnk"},
+		executable: true,
+		match:      prefix("L\x00\x00\x00\x01\x14\x02\x00"),
+	},
+	{
+		mediaType:  "text/x-shellscript",
+		aliases:    []string{"application/x-sh", "application/x-shellscript", "text/x-python", "text/x-perl"},
+		exts:       []string{"", "sh", "bash", "py", "pl", "rb", "command"},
+		executable: true,
+		match:      prefix("#!"),
+	},
+	{
+		mediaType: "application/pdf",
+		aliases:   []string{"application/x-pdf"},
+		exts:      []string{"pdf"},
+		// Readers accept the signature within the first kilobyte.
+		match: func(data []byte) bool {
+			if len(data) > 1024 {
+				data = data[:1024]
+			}
+			return bytes.Contains(data, []byte("%PDF-"))
+		},
+	},
+	{
+		mediaType: "application/rtf",
+		aliases:   []string{"text/rtf"},
+		exts:      []string{"rtf", "doc"},
+		match:     prefix(`{\rtf`),
+	},
+	{
+		mediaType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		exts:      []string{"docx", "docm", "dotx", "dotm"},
+		aliases:   []string{"application/vnd.ms-word.document.macroenabled.12"},
+		match:     func(data []byte) bool { return zipEntries(data, "[Content_Types].xml", "word/document.xml") },
+	},
+	{
+		mediaType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		exts:      []string{"xlsx", "xlsm", "xltx", "xltm"},
+		aliases:   []string{"application/vnd.ms-excel.sheet.macroenabled.12"},
+		match:     func(data []byte) bool { return zipEntries(data, "[Content_Types].xml", "xl/workbook.xml") },
+	},
+	{
+		mediaType: "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		exts:      []string{"pptx", "pptm", "potx", "ppsx"},
+		aliases:   []string{"application/vnd.ms-powerpoint.presentation.macroenabled.12"},
+		match:     func(data []byte) bool { return zipEntries(data, "[Content_Types].xml", "ppt/presentation.xml") },
+	},
+	{
+		mediaType:  "application/java-archive",
+		aliases:    []string{"application/x-java-archive"},
+		exts:       []string{"jar"},
+		executable: true,
+		match:      func(data []byte) bool { return zipEntries(data, "META-INF/MANIFEST.MF") },
+	},
+	{
+		mediaType: "application/zip",
+		aliases:   []string{"application/x-zip-compressed", "application/x-zip"},
+		// Formats built on ZIP that are not recognized above.
+		exts:  []string{"zip", "odt", "ods", "odp", "epub", "apk", "xpi", "kmz"},
+		match: prefix("PK\x03\x04", "PK\x05\x06"),
+	},
+	{
+		mediaType: "application/x-ole-storage",
+		aliases:   []string{"application/msword", "application/vnd.ms-excel", "application/vnd.ms-powerpoint", "application/vnd.ms-outlook", "application/x-msi", "application/x-ms-installer"},
+		exts:      []string{"doc", "dot", "xls", "xlt", "ppt", "pot", "pps", "msg", "msi", "pub", "vsd"},
+		match:     prefix("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"),
+	},
+	{
+		mediaType: "application/vnd.rar",
+		aliases:   []string{"application/x-rar-compressed", "application/x-rar"},
+		exts:      []string{"rar"},
+		match:     prefix("Rar!\x1a\x07"),
+	},
+	{
+		mediaType: "application/x-7z-compressed",
+		exts:      []string{"7z"},
+		match:     prefix("7z\xbc\xaf\x27\x1c"),
+	},
+	{
+		mediaType: "application/gzip",
+		aliases:   []string{"application/x-gzip"},
+		exts:      []string{"gz", "tgz"},
+		match:     prefix("\x1f\x8b"),
+	},
+	{
+		mediaType: "application/vnd.ms-cab-compressed",
+		exts:      []string{"cab"},
+		match:     prefix("MSCF\x00\x00\x00\x00"),
+	},
+	{
+		mediaType: "application/x-iso9660-image",
+		exts:      []string{"iso", "img"},
+		match: func(data []byte) bool {
+			return len(data) > 0x8006 && string(data[0x8001:0x8006]) == "CD001"
+		},
+	},
+	{
+		mediaType: "image/png",
+		exts:      []string{"png"},
+		match:     prefix("\x89PNG\r\n\x1a\n"),
+	},
+	{
+		mediaType: "image/jpeg",
+		aliases:   []string{"image/jpg", "image/pjpeg"},
+		exts:      []string{"jpg", "jpeg", "jpe", "jfif"},
+		match:     prefix("\xff\xd8\xff"),
+	},
+	{
+		mediaType: "image/gif",
+		exts:      []string{"gif"},
+		match:     prefix("GIF87a", "GIF89a"),
+	},
+	{
+		mediaType: "image/tiff",
+		exts:      []string{"tif", "tiff"},
+		match:     prefix("II*\x00", "MM\x00*"),
+	},
+	{
+		mediaType: "image/webp",
+		exts:      []string{"webp"},
+		match: func(data []byte) bool {
+			return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+		},
+	},
+}
+
+// textType stands for content that is not recognized as a binary format but
+// is valid UTF-8 without control characters other than white space.
+var textType = fileType{mediaType: "text/plain"}
+
+// executableExts are extensions that Windows runs when the file is opened,
+// including script and installer formats without magic number.
+var executableExts = map[string]bool{
+	"exe": true, "scr": true, "com": true, "pif": true, "cpl": true, "dll": true,
+	"bat": true, "cmd": true, "vbs": true, "vbe": true, "js": true, "jse": true,
+	"wsf": true, "wsh": true, "hta": true, "msi": true, "msp": true, "lnk": true,
+	"ps1": true, "psm1": true, "jar": true, "reg": true, "scf": true, "url": true,
+	"iso": true, "img": true, "vhd": true, "vhdx": true, "appx": true, "application": true,
+}
+
+// genericTypes are declared types that say nothing about the format.
+var genericTypes = map[string]bool{
+	"":                           true,
+	"application/octet-stream":   true,
+	"application/binary":         true,
+	"application/unknown":        true,
+	"application/force-download": true,
+}
+
+// SniffType determines the media type of data from its magic number and,
+// for formats built on ZIP, the names of the archive entries. It
+// recognizes executables, documents, archives and images, and returns
+// "text/plain" for UTF-8 text and the empty string for anything else.
+func SniffType(data []byte) string {
+	if t := sniff(data); t != nil {
+		return t.mediaType
+	}
+	return ""
+}
+
+func sniff(data []byte) *fileType {
+	for i := range fileTypes {
+		if fileTypes[i].match(data) {
+			return &fileTypes[i]
+		}
+	}
+	if len(data) > 0 && isText(data) {
+		return &textType
+	}
+	return nil
+}
+
+// isText reports whether the first kilobyte of data looks like text.
+func isText(data []byte) bool {
+	if len(data) > 1024 {
+		data = data[:1024]
+		// The limit may split a character.
+		for i := 0; i < utf8.UTFMax && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, c := range data {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// typeByName returns the format a file name extension stands for, or nil
+// for extensions that are unknown or shared by several formats.
+func typeByName(ext string) *fileType {
+	var found *fileType
+	for i := range fileTypes {
+		for _, e := range fileTypes[i].exts {
+			if e != "" && e == ext {
+				if found != nil {
+					return nil
+				}
+				found = &fileTypes[i]
+			}
+		}
+	}
+	return found
+}
+
+// typeByMediaType returns the format a declared media type stands for, or
+// nil if it is not one of the recognized formats.
+func typeByMediaType(mt string) *fileType {
+	for i := range fileTypes {
+		if fileTypes[i].declares(mt) {
+			return &fileTypes[i]
+		}
+	}
+	return nil
+}
+
+func (t *fileType) declares(mt string) bool {
+	if mt == t.mediaType {
+		return true
+	}
+	for _, a := range t.aliases {
+		if mt == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fileType) hasExt(ext string) bool {
+	for _, e := range t.exts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// fileExt returns the lower case extension of name without dot.
+func fileExt(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(strings.TrimRight(name, ". ")), "."))
+}
+
+// hasBidiControl reports whether s contains characters that change the
+// display order of text, such as U+202E RIGHT-TO-LEFT OVERRIDE, which
+// makes "invoice\u202efdp.exe" display as "invoiceexe.pdf".
+func hasBidiControl(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069', r == '\u200e', r == '\u200f', r == '\u061c':
+			return true
+		}
+	}
+	return false
+}
+
+// isDoubleExtension reports whether name has an executable extension after
+// another extension, as in "invoice.pdf.exe" or "photo.jpg   .scr".
+func isDoubleExtension(name string) bool {
+	name = strings.TrimRight(name, ". ")
+	ext := fileExt(name)
+	if !executableExts[ext] {
+		return false
+	}
+	stem := strings.TrimRight(strings.TrimSuffix(name, path.Ext(name)), " ")
+	inner := fileExt(stem)
+	return inner != "" && inner != ext && (typeByName(inner) != nil || executableExts[inner] || innerExts[inner])
+}
+
+// innerExts are further extensions used to make a file look harmless that
+// have no entry in fileTypes.
+var innerExts = map[string]bool{
+	"txt": true, "csv": true, "htm": true, "html": true, "xml": true, "eml": true,
+	"mp3": true, "mp4": true, "wav": true, "avi": true, "mov": true, "bmp": true,
+}
+
+// inspect fills in the fields of a that describe its type and flags
+// suspicious combinations of name, declared type and content.
+func (a *Attachment) inspect() {
+	sniffed := sniff(a.Data)
+	if sniffed != nil {
+		a.SniffedType = sniffed.mediaType
+	}
+	a.RTLO = hasBidiControl(a.Filename)
+	a.DoubleExtension = isDoubleExtension(a.Filename)
+
+	ext := fileExt(a.Filename)
+	byName := typeByName(ext)
+	declared := a.ContentType
+	if genericTypes[declared] {
+		declared = ""
+	}
+
+	switch {
+	case sniffed == &textType:
+		// Text is only at odds with names and types of binary formats.
+		a.TypeMismatch = byName != nil || typeByMediaType(declared) != nil
+	case sniffed != nil:
+		// Declared types outside the table only count against executables.
+		a.TypeMismatch = !sniffed.hasExt(ext) && (byName != nil || sniffed.executable) ||
+			declared != "" && !sniffed.declares(declared) &&
+				(sniffed.executable || typeByMediaType(declared) != nil || strings.HasPrefix(declared, "text/"))
+	default:
+		// Without a recognized content the declaration and the name can
+		// still contradict each other.
+		a.TypeMismatch = byName != nil && declared != "" && !byName.declares(declared) && typeByMediaType(declared) != nil
+	}
+}
+
+// Suspicious reports whether the attachment's name or type looks disguised:
+// its content, declared type and extension disagree, its name has a double
+// extension or it contains bidirectional control characters.
+func (a Attachment) Suspicious() bool {
+	return a.TypeMismatch || a.DoubleExtension || a.RTLO
+}
@@ -0,0 +1,216 @@
+package eml
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+const (
+	pdfData = "%PDF-1.7\n%\xe2\xe3\xcf\xd3\n1 0 obj\n"
+	pngData = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	oleData = "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1\x00\x00"
+)
+
+var (
+	// peData is a DOS header whose e_lfanew points right behind it, to
+	// the PE signature.
+	peData   = "MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff\x00\x00\xb8" + strings.Repeat("\x00", 43) + "\x40\x00\x00\x00PE\x00\x00\x4c\x01"
+	docxData = zipData("[Content_Types].xml", "_rels/.rels", "word/document.xml")
+	jarData  = zipData("META-INF/MANIFEST.MF", "Main.class")
+	// Entry names of other formats in the content of a plain archive.
+	photosData = zipData("photos/a.jpg", "photos/xl/word/document.xml")
+	// An entry in a directory named like those of Office documents.
+	wordDirData = zipData("word/readme.txt")
+)
+
+// zipData returns a ZIP archive with the given entries, stored uncompressed
+// with content that mentions the entry names of Office documents.
+func zipData(names ...string) string {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, name := range names {
+		f, _ := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		f.Write([]byte(name + "\n[Content_Types].xml xl/workbook.xml word/document.xml\n"))
+	}
+	w.Close()
+	return b.String()
+}
+
+type sniffTypeTest struct {
+	data string
+	ret  string
+}
+
+var sniffTypeTests = []sniffTypeTest{
+	{peData, "application/x-msdownload"},
+	{"MZ Corp quarterly notes\n", "text/plain"},
+	{"MZ\x90\x00" + strings.Repeat("\x00", 60), ""},
+	{"\x7fELF\x02\x01\x01", "application/x-executable"},
+	{"#!/bin/sh\nrm -rf ~\n", "text/x-shellscript"},
+	{pdfData, "application/pdf"},
+	{"junk\r\n%PDF-1.4", "application/pdf"},
+	{pngData, "image/png"},
+	{"\xff\xd8\xff\xe0\x00\x10JFIF", "image/jpeg"},
+	{"GIF89a\x01\x00", "image/gif"},
+	{docxData, "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{jarData, "application/java-archive"},
+	{photosData, "application/zip"},
+	{wordDirData, "application/zip"},
+	{"PK\x03\x04\x14\x00word/document.xml", "application/zip"},
+	{oleData, "application/x-ole-storage"},
+	{"Rar!\x1a\x07\x01\x00", "application/vnd.rar"},
+	{"\x1f\x8b\x08\x00", "application/gzip"},
+	{"Hello,\r\n\tthis is text. Grüße\n", "text/plain"},
+	{"binary\x00\x01\x02", ""},
+	{"", ""},
+}
+
+func TestSniffType(t *testing.T) {
+	for _, tt := range sniffTypeTests {
+		if ret := SniffType([]byte(tt.data)); ret != tt.ret {
+			t.Errorf("SniffType(%q) = %q; expected %q", tt.data, ret, tt.ret)
+		}
+	}
+}
+
+type inspectTest struct {
+	filename, contentType, data string
+	mismatch, double, rtlo      bool
+}
+
+var inspectTests = []inspectTest{
+	{"invoice.pdf", "application/pdf", pdfData, false, false, false},
+	{"invoice.pdf", "application/octet-stream", pdfData, false, false, false},
+	{"invoice.PDF", "", pdfData, false, false, false},
+	{"setup.exe", "application/x-msdownload", peData, false, false, false},
+	{"photo.jpeg", "image/jpg", "\xff\xd8\xff\xe0", false, false, false},
+	{"report.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", docxData, false, false, false},
+	{"old.doc", "application/msword", oleData, false, false, false},
+	{"photos.zip", "application/zip", photosData, false, false, false},
+	{"docs.zip", "application/zip", wordDirData, false, false, false},
+	{"notes.txt", "text/plain", "just text\n", false, false, false},
+	{"notes.txt", "text/plain", "MZ Corp quarterly notes\n", false, false, false},
+	{"data.csv", "text/csv", "a,b\n1,2\n", false, false, false},
+	{"unknown.xyz", "application/x-whatever", "\x00\x01\x02", false, false, false},
+
+	// Executable content behind another name or type.
+	{"invoice.pdf", "application/pdf", peData, true, false, false},
+	{"invoice.pdf", "application/octet-stream", peData, true, false, false},
+	{"invoice", "application/pdf", peData, true, false, false},
+	{"readme.txt", "text/plain", "#!/bin/sh\n", true, false, false},
+	{"setup.exe", "application/pdf", peData, true, false, false},
+	// Content of another format.
+	{"photo.png", "image/png", pdfData, true, false, false},
+	{"photo.jpg", "image/jpeg", pngData, true, false, false},
+	{"report.pdf", "application/pdf", "Not a PDF at all\n", true, false, false},
+	// Only the name and the declared type are at odds.
+	{"photo.png", "application/pdf", "\x00\x01\x02", true, false, false},
+
+	{"invoice.pdf.exe", "application/octet-stream", peData, false, true, false},
+	{"photo.jpg      .scr", "", peData, false, true, false},
+	{"Invoice.PDF.js", "text/plain", "var x = 1;\n", false, true, false},
+	{"archive.tar.gz", "application/gzip", "\x1f\x8b\x08", false, false, false},
+	{"jquery.min.js", "text/javascript", "var x = 1;\n", false, false, false},
+	{"invoice\u202efdp.exe", "application/pdf", peData, true, false, true},
+	{"invoice\u202efdp.exe", "application/x-msdownload", peData, false, false, true},
+	{"\u2067report\u2069.txt", "text/plain", "text\n", false, false, true},
+}
+
+func TestInspectAttachment(t *testing.T) {
+	for _, tt := range inspectTests {
+		a := Attachment{Filename: tt.filename, ContentType: tt.contentType, Data: []byte(tt.data)}
+		a.inspect()
+		if a.TypeMismatch != tt.mismatch || a.DoubleExtension != tt.double || a.RTLO != tt.rtlo {
+			t.Errorf("%q (%s, sniffed %q): got mismatch %v, double extension %v, RTLO %v; expected %v, %v, %v",
+				tt.filename, tt.contentType, a.SniffedType, a.TypeMismatch, a.DoubleExtension, a.RTLO, tt.mismatch, tt.double, tt.rtlo)
+		}
+		if a.Suspicious() != (tt.mismatch || tt.double || tt.rtlo) {
+			t.Errorf("%q: Suspicious() = %v", tt.filename, a.Suspicious())
+		}
+	}
+}
+
+func TestAttachmentSniffing(t *testing.T) {
+	msg := "From: a@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Please pay the attached invoice.\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf; name=\"invoice.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"invoice.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte(peData)) + "\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"begin 644 notes.txt\r\n" +
+		"#0V%T\r\n" +
+		"`\r\n" +
+		"end\r\n" +
+		"--b--\r\n"
+	m, err := ParseWithOptions([]byte(msg), Options{ExtractEmbedded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Attachments) != 2 {
+		t.Fatalf("got %d attachments", len(m.Attachments))
+	}
+	a := m.Attachments[0]
+	if a.Filename != "invoice.pdf" || a.ContentType != "application/pdf" || a.SniffedType != "application/x-msdownload" || !a.TypeMismatch {
+		t.Errorf("got %+v", a)
+	}
+	a = m.Attachments[1]
+	if a.Filename != "notes.txt" || a.SniffedType != "text/plain" || a.Suspicious() {
+		t.Errorf("got %+v", a)
+	}
+}
+
+type attachmentNameTest struct {
+	headers  string
+	filename string
+}
+
+var attachmentNameTests = []attachmentNameTest{
+	{"Content-Type: application/pdf\r\nContent-Disposition: attachment; filename=invoice.pdf\r\n", "invoice.pdf"},
+	{"Content-Type: application/pdf\r\nContent-Disposition: inline; filename=\"invoice.pdf\"\r\n", "invoice.pdf"},
+	{"Content-Type: application/pdf\r\nContent-Disposition: attachment; filename*=UTF-8''Rechnung%20M%C3%A4rz.pdf\r\n", "Rechnung März.pdf"},
+	{"Content-Type: application/pdf; name=\"=?UTF-8?Q?Rechnung_M=C3=A4rz.pdf?=\"\r\n", "Rechnung März.pdf"},
+	{"Content-Type: application/pdf\r\nContent-Disposition: attachment; filename=\"invoice.pdf\"; broken\r\n", "invoice.pdf"},
+	{"Content-Type: application/pdf\r\n", ""},
+}
+
+func TestAttachmentNames(t *testing.T) {
+	for _, tt := range attachmentNameTests {
+		msg := "From: a@example.com\r\n" +
+			"Content-Type: multipart/mixed; boundary=b\r\n" +
+			"\r\n" +
+			"--b\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"\r\n" +
+			"Please pay the attached invoice.\r\n" +
+			"--b\r\n" +
+			tt.headers +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte(peData)) + "\r\n" +
+			"--b--\r\n"
+		m, err := Parse([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Attachments) != 1 {
+			t.Errorf("%q: got %d attachments", tt.headers, len(m.Attachments))
+			continue
+		}
+		if a := m.Attachments[0]; a.Filename != tt.filename || a.SniffedType != "application/x-msdownload" || !a.TypeMismatch {
+			t.Errorf("%q: got %+v", tt.headers, a)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+// Evaluation of SPF records (RFC 7208).
+
+package eml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DNSResolver performs the DNS lookups of SPF and DMARC evaluation.
+// *net.Resolver implements it; tests and offline environments can use a
+// stand-in.
+type DNSResolver interface {
+	TXTResolver
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// AuthSoftFail is the SPF result for senders that are probably not
+// authorized.
+const AuthSoftFail AuthStatus = "softfail"
+
+// SPFResult is the outcome of an SPF check.
+type SPFResult struct {
+	// Status is one of AuthNone, AuthNeutral, AuthPass, AuthFail,
+	// AuthSoftFail, AuthTempError and AuthPermError.
+	Status AuthStatus
+	// Domain is the domain whose policy was checked: that of the envelope
+	// sender, or the HELO name for an empty sender.
+	Domain string
+	// Mechanism is the directive that matched, if any.
+	Mechanism string
+	Err       error
+}
+
+const (
+	// spfMaxLookups limits the mechanisms and modifiers that cause DNS
+	// lookups (RFC 7208 section 4.6.4).
+	spfMaxLookups = 10
+	// spfMaxVoidLookups limits the lookups that return no records.
+	spfMaxVoidLookups = 2
+	// spfMaxNames limits the MX and PTR names looked at.
+	spfMaxNames = 10
+)
+
+var (
+	errSPFNotFound = errors.New("spf: no record")
+	errSPFLookups  = errors.New("spf: too many DNS lookups")
+	errSPFVoids    = errors.New("spf: too many void DNS lookups")
+	errSPFMultiple = errors.New("spf: more than one record")
+	errSPFRedirect = errors.New("spf: redirect to domain without record")
+)
+
+// spfError carries the result an error causes.
+type spfError struct {
+	status AuthStatus
+	err    error
+}
+
+func (e *spfError) Error() string { return e.err.Error() }
+func (e *spfError) Unwrap() error { return e.err }
+
+func spfPermError(format string, args ...interface{}) error {
+	return &spfError{AuthPermError, fmt.Errorf("spf: "+format, args...)}
+}
+
+// spfCheck holds the state of one SPF evaluation.
+type spfCheck struct {
+	resolver DNSResolver
+	ip       net.IP
+	sender   string
+	helo     string
+	lookups  int
+	voids    int
+}
+
+// CheckSPF evaluates the SPF policy for a message from ip with the given
+// HELO name and envelope sender (MAIL FROM). An empty sender, as for
+// bounces, is checked as postmaster at the HELO name.
+func (c AuthChecker) CheckSPF(ctx context.Context, ip net.IP, helo, mailFrom string) SPFResult {
+	sender := strings.Trim(mailFrom, "<>")
+	if sender == "" {
+		sender = "postmaster@" + helo
+	}
+	at := strings.LastIndexByte(sender, '@')
+	if at < 0 {
+		sender = "postmaster@" + sender
+		at = len("postmaster")
+	}
+	domain := strings.TrimSuffix(sender[at+1:], ".")
+	res := SPFResult{Domain: strings.ToLower(domain)}
+	if ip == nil {
+		res.Status, res.Err = AuthPermError, errors.New("spf: no IP address")
+		return res
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	s := &spfCheck{resolver: c.resolver(), ip: ip, sender: sender, helo: helo}
+	res.Status, res.Mechanism, res.Err = s.checkHost(ctx, domain)
+	return res
+}
+
+// checkHost is the check_host() function of RFC 7208 section 4.
+func (s *spfCheck) checkHost(ctx context.Context, domain string) (AuthStatus, string, error) {
+	if !validDomain(domain) {
+		return AuthNone, "", fmt.Errorf("spf: invalid domain %q", domain)
+	}
+	record, err := s.record(ctx, domain)
+	if err != nil {
+		var se *spfError
+		if errors.As(err, &se) {
+			return se.status, "", err
+		}
+		return AuthNone, "", err
+	}
+
+	terms := strings.Fields(record)[1:]
+	var redirect string
+	for _, term := range terms {
+		name, value, isModifier := strings.Cut(term, "=")
+		if isModifier && !strings.ContainsAny(name, ":/") {
+			switch strings.ToLower(name) {
+			case "redirect":
+				if redirect != "" {
+					return AuthPermError, "", spfPermError("more than one redirect")
+				}
+				redirect = value
+			default:
+				// Explanations (exp=) are not fetched, and unknown
+				// modifiers are ignored.
+			}
+			continue
+		}
+
+		qualifier := AuthPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			qualifier, term = AuthFail, term[1:]
+		case '~':
+			qualifier, term = AuthSoftFail, term[1:]
+		case '?':
+			qualifier, term = AuthNeutral, term[1:]
+		}
+		match, err := s.mechanism(ctx, domain, term)
+		if err != nil {
+			var se *spfError
+			if errors.As(err, &se) {
+				return se.status, term, err
+			}
+			return AuthPermError, term, err
+		}
+		if match {
+			return qualifier, term, nil
+		}
+	}
+
+	if redirect != "" {
+		if err := s.count(); err != nil {
+			return AuthPermError, "", err
+		}
+		target, err := s.expand(ctx, redirect, domain)
+		if err != nil {
+			return AuthPermError, "", err
+		}
+		status, mech, err := s.checkHost(ctx, target)
+		if status == AuthNone {
+			return AuthPermError, "", errSPFRedirect
+		}
+		return status, mech, err
+	}
+	return AuthNeutral, "", nil
+}
+
+// record fetches the SPF record of a domain.
+func (s *spfCheck) record(ctx context.Context, domain string) (string, error) {
+	txts, err := s.resolver.LookupTXT(ctx, domain)
+	if err != nil {
+		if isNotFound(err) {
+			return "", errSPFNotFound
+		}
+		return "", &spfError{AuthTempError, err}
+	}
+	var records []string
+	for _, t := range txts {
+		if strings.EqualFold(t, "v=spf1") || (len(t) > 7 && strings.EqualFold(t[:7], "v=spf1 ")) {
+			records = append(records, t)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return "", errSPFNotFound
+	case 1:
+		return records[0], nil
+	}
+	return "", &spfError{AuthPermError, errSPFMultiple}
+}
+
+// count counts a DNS lookup against the limit.
+func (s *spfCheck) count() error {
+	s.lookups++
+	if s.lookups > spfMaxLookups {
+		return &spfError{AuthPermError, errSPFLookups}
+	}
+	return nil
+}
+
+// void counts lookups that returned nothing against their limit.
+func (s *spfCheck) void(n int, err error) error {
+	if err != nil && !isNotFound(err) {
+		return &spfError{AuthTempError, err}
+	}
+	if n == 0 {
+		s.voids++
+		if s.voids > spfMaxVoidLookups {
+			return &spfError{AuthPermError, errSPFVoids}
+		}
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// validDomain reports whether a domain is usable for SPF: at least two
+// labels, none of them empty or longer than 63 octets.
+func validDomain(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 || len(domain) > 253 {
+		return false
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > 63 {
+			return false
+		}
+	}
+	return true
+}
+
+// mechanism evaluates one mechanism, without its qualifier.
+func (s *spfCheck) mechanism(ctx context.Context, domain, term string) (bool, error) {
+	name, arg, hasArg := strings.Cut(term, ":")
+	if !hasArg {
+		// a/24 and mx//64 take a CIDR length without domain.
+		if i := strings.IndexByte(term, '/'); i >= 0 {
+			name, arg = term[:i], term[i:]
+		}
+	}
+	name = strings.ToLower(name)
+
+	switch name {
+	case "all":
+		return true, nil
+	case "ip4", "ip6":
+		if !hasArg {
+			return false, spfPermError("%s without address", name)
+		}
+		return s.matchIP(name, arg)
+	case "include":
+		if err := s.count(); err != nil {
+			return false, err
+		}
+		if !hasArg {
+			return false, spfPermError("include without domain")
+		}
+		target, err := s.expand(ctx, arg, domain)
+		if err != nil {
+			return false, err
+		}
+		status, _, err := s.checkHost(ctx, target)
+		switch status {
+		case AuthPass:
+			return true, nil
+		case AuthFail, AuthSoftFail, AuthNeutral:
+			return false, nil
+		case AuthTempError:
+			return false, err
+		}
+		return false, spfPermError("include of %s: %v", target, err)
+	case "a", "mx":
+		if err := s.count(); err != nil {
+			return false, err
+		}
+		target, cidr4, cidr6, err := s.domainSpec(ctx, arg, domain)
+		if err != nil {
+			return false, err
+		}
+		hosts := []string{target}
+		if name == "mx" {
+			mxs, err := s.resolver.LookupMX(ctx, target)
+			if err := s.void(len(mxs), err); err != nil {
+				return false, err
+			}
+			if len(mxs) > spfMaxNames {
+				return false, spfPermError("more than %d MX records for %s", spfMaxNames, target)
+			}
+			hosts = hosts[:0]
+			for _, mx := range mxs {
+				hosts = append(hosts, mx.Host)
+			}
+		}
+		for _, h := range hosts {
+			addrs, err := s.resolver.LookupIPAddr(ctx, h)
+			if err := s.void(len(addrs), err); err != nil {
+				return false, err
+			}
+			for _, a := range addrs {
+				if inCIDR(s.ip, a.IP, cidr4, cidr6) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case "ptr":
+		if err := s.count(); err != nil {
+			return false, err
+		}
+		target := domain
+		if hasArg {
+			var err error
+			if target, err = s.expand(ctx, arg, domain); err != nil {
+				return false, err
+			}
+		}
+		for _, name := range s.validatedNames(ctx) {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			t := strings.ToLower(strings.TrimSuffix(target, "."))
+			if name == t || strings.HasSuffix(name, "."+t) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "exists":
+		if err := s.count(); err != nil {
+			return false, err
+		}
+		if !hasArg {
+			return false, spfPermError("exists without domain")
+		}
+		target, err := s.expand(ctx, arg, domain)
+		if err != nil {
+			return false, err
+		}
+		addrs, err := s.resolver.LookupIPAddr(ctx, target)
+		if err := s.void(len(addrs), err); err != nil {
+			return false, err
+		}
+		for _, a := range addrs {
+			if a.IP.To4() != nil {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, spfPermError("unknown mechanism %q", term)
+}
+
+// matchIP evaluates ip4 and ip6 mechanisms.
+func (s *spfCheck) matchIP(name, arg string) (bool, error) {
+	addr, bits, hasBits := strings.Cut(arg, "/")
+	ip := net.ParseIP(addr)
+	if ip == nil || (name == "ip4") != (ip.To4() != nil) {
+		return false, spfPermError("invalid address %q", arg)
+	}
+	max := 128
+	if name == "ip4" {
+		ip, max = ip.To4(), 32
+	}
+	n := max
+	if hasBits {
+		var err error
+		if n, err = strconv.Atoi(bits); err != nil || n < 0 || n > max || (bits[0] == '0' && len(bits) > 1) {
+			return false, spfPermError("invalid prefix length in %q", arg)
+		}
+	}
+	if len(ip) != len(s.ip) {
+		return false, nil
+	}
+	return (&net.IPNet{IP: ip, Mask: net.CIDRMask(n, max)}).Contains(s.ip), nil
+}
+
+// inCIDR reports whether ip is in the network of addr with the prefix
+// length for its address family.
+func inCIDR(ip, addr net.IP, cidr4, cidr6 int) bool {
+	if a4 := addr.To4(); a4 != nil {
+		return len(ip) == net.IPv4len && (&net.IPNet{IP: a4, Mask: net.CIDRMask(cidr4, 32)}).Contains(ip)
+	}
+	return len(ip) == net.IPv6len && (&net.IPNet{IP: addr, Mask: net.CIDRMask(cidr6, 128)}).Contains(ip)
+}
+
+// domainSpec parses the optional domain and CIDR lengths of the a and mx
+// mechanisms, as in "a:example.com/24//64".
+func (s *spfCheck) domainSpec(ctx context.Context, arg, domain string) (string, int, int, error) {
+	cidr4, cidr6 := 32, 128
+	if i := strings.Index(arg, "//"); i >= 0 {
+		n, err := strconv.Atoi(arg[i+2:])
+		if err != nil || n < 0 || n > 128 {
+			return "", 0, 0, spfPermError("invalid IPv6 prefix length in %q", arg)
+		}
+		cidr6, arg = n, arg[:i]
+	}
+	if i := strings.LastIndexByte(arg, '/'); i >= 0 {
+		n, err := strconv.Atoi(arg[i+1:])
+		if err != nil || n < 0 || n > 32 {
+			return "", 0, 0, spfPermError("invalid IPv4 prefix length in %q", arg)
+		}
+		cidr4, arg = n, arg[:i]
+	}
+	if arg == "" {
+		return domain, cidr4, cidr6, nil
+	}
+	target, err := s.expand(ctx, arg, domain)
+	return target, cidr4, cidr6, err
+}
+
+// validatedNames returns the names of the client IP whose addresses
+// include the IP again (RFC 7208 section 5.5).
+func (s *spfCheck) validatedNames(ctx context.Context) []string {
+	names, err := s.resolver.LookupAddr(ctx, s.ip.String())
+	if err != nil {
+		return nil
+	}
+	if len(names) > spfMaxNames {
+		names = names[:spfMaxNames]
+	}
+	var valid []string
+	for _, name := range names {
+		addrs, err := s.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if a.IP.Equal(s.ip) {
+				valid = append(valid, name)
+				break
+			}
+		}
+	}
+	return valid
+}
+
+// expand expands the macros of RFC 7208 section 7 in a domain-spec.
+func (s *spfCheck) expand(ctx context.Context, spec, domain string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(spec); i++ {
+		c := spec[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", spfPermError("incomplete macro in %q", spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+			continue
+		case '_':
+			b.WriteByte(' ')
+			continue
+		case '-':
+			b.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", spfPermError("invalid macro in %q", spec)
+		}
+		end := strings.IndexByte(spec[i:], '}')
+		if end < 2 {
+			return "", spfPermError("invalid macro in %q", spec)
+		}
+		macro := spec[i+1 : i+end]
+		i += end
+
+		letter := macro[0]
+		value, err := s.macroValue(ctx, letter|0x20, domain)
+		if err != nil {
+			return "", err
+		}
+		rest := macro[1:]
+		digits := 0
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		keep := 0
+		if digits > 0 {
+			if keep, err = strconv.Atoi(rest[:digits]); err != nil || keep == 0 {
+				return "", spfPermError("invalid macro in %q", spec)
+			}
+		}
+		rest = rest[digits:]
+		reverse := false
+		if len(rest) > 0 && (rest[0] == 'r' || rest[0] == 'R') {
+			reverse, rest = true, rest[1:]
+		}
+		delims := "."
+		if rest != "" {
+			if strings.Trim(rest, ".-+,/_=") != "" {
+				return "", spfPermError("invalid macro in %q", spec)
+			}
+			delims = rest
+		}
+
+		parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delims, r) })
+		if reverse {
+			for l, r := 0, len(parts)-1; l < r; l, r = l+1, r-1 {
+				parts[l], parts[r] = parts[r], parts[l]
+			}
+		}
+		if keep > 0 && keep < len(parts) {
+			parts = parts[len(parts)-keep:]
+		}
+		value = strings.Join(parts, ".")
+		if letter >= 'A' && letter <= 'Z' {
+			value = url.QueryEscape(value)
+		}
+		b.WriteString(value)
+	}
+
+	// Over-long domain names are shortened from the left.
+	result := b.String()
+	for len(result) > 253 {
+		i := strings.IndexByte(result, '.')
+		if i < 0 {
+			break
+		}
+		result = result[i+1:]
+	}
+	return result, nil
+}
+
+func (s *spfCheck) macroValue(ctx context.Context, letter byte, domain string) (string, error) {
+	at := strings.LastIndexByte(s.sender, '@')
+	switch letter {
+	case 's':
+		return s.sender, nil
+	case 'l':
+		return s.sender[:at], nil
+	case 'o':
+		return s.sender[at+1:], nil
+	case 'd':
+		return domain, nil
+	case 'i':
+		if s.ip.To4() != nil {
+			return s.ip.String(), nil
+		}
+		// IPv6 addresses are written as dot-separated nibbles.
+		var nibbles []string
+		for _, b := range s.ip.To16() {
+			nibbles = append(nibbles, strconv.FormatUint(uint64(b>>4), 16), strconv.FormatUint(uint64(b&15), 16))
+		}
+		return strings.Join(nibbles, "."), nil
+	case 'p':
+		if err := s.count(); err != nil {
+			return "", err
+		}
+		names := s.validatedNames(ctx)
+		for _, name := range names {
+			n := strings.ToLower(strings.TrimSuffix(name, "."))
+			if n == strings.ToLower(domain) || strings.HasSuffix(n, "."+strings.ToLower(domain)) {
+				return n, nil
+			}
+		}
+		if len(names) > 0 {
+			return strings.TrimSuffix(names[0], "."), nil
+		}
+		return "unknown", nil
+	case 'v':
+		if s.ip.To4() != nil {
+			return "in-addr", nil
+		}
+		return "ip6", nil
+	case 'h':
+		return s.helo, nil
+	}
+	return "", spfPermError("invalid macro letter %q", letter)
+}
@@ -0,0 +1,199 @@
+package eml
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// dnsZone is a DNSResolver serving records from memory. Names without
+// records are reported as not found; names mapped to an error in fail
+// return that error.
+type dnsZone struct {
+	txt  map[string][]string
+	ip   map[string][]string
+	mx   map[string][]string
+	ptr  map[string][]string
+	fail map[string]error
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (z dnsZone) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if err := z.fail[name]; err != nil {
+		return nil, err
+	}
+	if txts, ok := z.txt[name]; ok {
+		return txts, nil
+	}
+	return nil, notFound(name)
+}
+
+func (z dnsZone) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if err := z.fail[host]; err != nil {
+		return nil, err
+	}
+	addrs, ok := z.ip[strings.TrimSuffix(host, ".")]
+	if !ok {
+		return nil, notFound(host)
+	}
+	var ips []net.IPAddr
+	for _, a := range addrs {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(a)})
+	}
+	return ips, nil
+}
+
+func (z dnsZone) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	hosts, ok := z.mx[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	var mxs []*net.MX
+	for i, h := range hosts {
+		mxs = append(mxs, &net.MX{Host: h, Pref: uint16(10 * (i + 1))})
+	}
+	return mxs, nil
+}
+
+func (z dnsZone) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if names, ok := z.ptr[addr]; ok {
+		return names, nil
+	}
+	return nil, notFound(addr)
+}
+
+var spfZone = dnsZone{
+	txt: map[string][]string{
+		"example.com":          {"v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 include:_spf.example.net mx a:relay.example.com -all"},
+		"_spf.example.net":     {"some unrelated text", "v=spf1 ip4:198.51.100.7 ~all"},
+		"redirect.example.com": {"v=spf1 redirect=example.com"},
+		"soft.example.com":     {"v=spf1 ~all"},
+		"macro.example.com":    {"v=spf1 exists:%{ir}.%{l1r-}.allow.example.com -all"},
+		"ptr.example.com":      {"v=spf1 ptr -all"},
+		"twice.example.com":    {"v=spf1 -all", "v=spf1 +all"},
+		"broken.example.com":   {"v=spf1 include:nothing.example.com -all"},
+		"syntax.example.com":   {"v=spf1 ip4:300.1.1.1 -all"},
+		"loop.example.com":     {"v=spf1 include:loop.example.com -all"},
+		"voids.example.com":    {"v=spf1 a:v1.example.com a:v2.example.com a:v3.example.com -all"},
+		"dead.example.com":     {"v=spf1 include:down.example.com -all"},
+		"neutral.example.com":  {"v=spf1 ?all"},
+	},
+	ip: map[string][]string{
+		"mx1.example.com":                    {"203.0.113.10"},
+		"relay.example.com":                  {"203.0.113.20", "2001:db8:ffff::1"},
+		"mail.ptr.example.com":               {"203.0.113.30"},
+		"30.113.0.203.bob.allow.example.com": {"127.0.0.2"},
+	},
+	mx: map[string][]string{
+		"example.com": {"mx1.example.com"},
+	},
+	ptr: map[string][]string{
+		"203.0.113.30": {"mail.ptr.example.com."},
+	},
+	fail: map[string]error{
+		"down.example.com": &net.DNSError{Err: "server misbehaving", Name: "down.example.com", IsTemporary: true},
+	},
+}
+
+type spfTest struct {
+	ip       string
+	helo     string
+	mailFrom string
+	status   AuthStatus
+}
+
+var spfTests = []spfTest{
+	{"192.0.2.55", "mail.example.com", "joe@example.com", AuthPass},
+	{"2001:db8:1::25", "mail.example.com", "joe@example.com", AuthPass},
+	{"198.51.100.7", "mail.example.com", "joe@example.com", AuthPass},
+	{"203.0.113.10", "mail.example.com", "joe@example.com", AuthPass},
+	{"203.0.113.20", "mail.example.com", "joe@example.com", AuthPass},
+	{"2001:db8:ffff::1", "mail.example.com", "joe@example.com", AuthPass},
+	{"198.51.100.8", "mail.example.com", "joe@example.com", AuthFail},
+	{"198.51.100.8", "mail.example.com", "<joe@redirect.example.com>", AuthFail},
+	{"192.0.2.1", "mail.example.com", "joe@redirect.example.com", AuthPass},
+	{"192.0.2.1", "mail.example.com", "joe@soft.example.com", AuthSoftFail},
+	{"192.0.2.1", "mail.example.com", "joe@neutral.example.com", AuthNeutral},
+	{"192.0.2.1", "mail.example.com", "joe@nospf.example.com", AuthNone},
+	{"203.0.113.30", "mail.example.com", "bob@macro.example.com", AuthPass},
+	{"203.0.113.30", "mail.example.com", "alice@macro.example.com", AuthFail},
+	{"203.0.113.30", "mail.example.com", "joe@ptr.example.com", AuthPass},
+	{"203.0.113.31", "mail.example.com", "joe@ptr.example.com", AuthFail},
+	{"192.0.2.1", "mail.example.com", "joe@twice.example.com", AuthPermError},
+	{"192.0.2.1", "mail.example.com", "joe@broken.example.com", AuthPermError},
+	{"192.0.2.1", "mail.example.com", "joe@syntax.example.com", AuthPermError},
+	{"192.0.2.1", "mail.example.com", "joe@loop.example.com", AuthPermError},
+	{"192.0.2.1", "mail.example.com", "joe@voids.example.com", AuthPermError},
+	{"192.0.2.1", "mail.example.com", "joe@dead.example.com", AuthTempError},
+	// Bounces are checked against the HELO name.
+	{"192.0.2.1", "example.com", "<>", AuthPass},
+	{"198.51.100.8", "soft.example.com", "", AuthSoftFail},
+}
+
+func TestCheckSPF(t *testing.T) {
+	c := AuthChecker{Resolver: spfZone}
+	for _, tt := range spfTests {
+		res := c.CheckSPF(context.Background(), net.ParseIP(tt.ip), tt.helo, tt.mailFrom)
+		if res.Status != tt.status {
+			t.Errorf("CheckSPF(%s, %s, %q) = %s (%v); expected %s", tt.ip, tt.helo, tt.mailFrom, res.Status, res.Err, tt.status)
+		}
+	}
+}
+
+func TestSPFMacros(t *testing.T) {
+	// The examples of RFC 7208 section 7.4.
+	s := &spfCheck{resolver: dnsZone{}, ip: net.ParseIP("192.0.2.3").To4(), sender: "strong-bad@email.example.com", helo: "mx.example.org"}
+	tests := map[string]string{
+		"%{s}":                              "strong-bad@email.example.com",
+		"%{o}":                              "email.example.com",
+		"%{d}":                              "email.example.com",
+		"%{d4}":                             "email.example.com",
+		"%{d3}":                             "email.example.com",
+		"%{d2}":                             "example.com",
+		"%{d1}":                             "com",
+		"%{dr}":                             "com.example.email",
+		"%{d2r}":                            "example.email",
+		"%{l}":                              "strong-bad",
+		"%{l-}":                             "strong.bad",
+		"%{lr}":                             "strong-bad",
+		"%{lr-}":                            "bad.strong",
+		"%{l1r-}":                           "strong",
+		"%{ir}.%{v}._spf.%{d2}":             "3.2.0.192.in-addr._spf.example.com",
+		"%{lr-}.lp._spf.%{d2}":              "bad.strong.lp._spf.example.com",
+		"%{d2}.trusted-domains.example.net": "example.com.trusted-domains.example.net",
+		"%%%_%-":                            "% %20",
+	}
+	for spec, want := range tests {
+		got, err := s.expand(context.Background(), spec, "email.example.com")
+		if err != nil || got != want {
+			t.Errorf("expand(%q) = %q, %v; expected %q", spec, got, err, want)
+		}
+	}
+
+	s.ip = net.ParseIP("2001:db8::cb01")
+	got, err := s.expand(context.Background(), "%{ir}.%{v}._spf.%{d2}", "email.example.com")
+	want := "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"
+	if err != nil || got != want {
+		t.Errorf("expand for IPv6 = %q, %v; expected %q", got, err, want)
+	}
+}
+
+func TestSPFLookupLimit(t *testing.T) {
+	zone := dnsZone{txt: map[string][]string{}, ip: map[string][]string{}}
+	record := "v=spf1"
+	for i := 0; i < 11; i++ {
+		name := string(rune('a'+i)) + ".example.com"
+		zone.ip[name] = []string{"203.0.113.1"}
+		record += " a:" + name
+	}
+	zone.txt["many.example.com"] = []string{record + " -all"}
+	res := AuthChecker{Resolver: zone}.CheckSPF(context.Background(), net.ParseIP("192.0.2.1"), "", "joe@many.example.com")
+	if res.Status != AuthPermError || !errors.Is(res.Err, errSPFLookups) {
+		t.Errorf("got %s (%v); expected permerror for too many lookups", res.Status, res.Err)
+	}
+}
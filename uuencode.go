@@ -0,0 +1,101 @@
+// Decoding of uuencoded data.
+
+package eml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// uuBlock is a decoded uuencoded file.
+type uuBlock struct {
+	Filename string
+	Mode     string
+	Data     []byte
+	Warnings []string
+}
+
+// uuDecodeLine decodes one line of uuencoded data. The first character
+// gives the number of decoded bytes, the rest are groups of four characters
+// encoding three bytes each, with '`' standing for a zero as well as ' '.
+func uuDecodeLine(line []byte) ([]byte, error) {
+	if len(line) == 0 {
+		return nil, nil
+	}
+	n := int((line[0] - ' ') & 63)
+	if n == 0 {
+		return nil, nil
+	}
+	need := (n + 2) / 3 * 4
+	chars := line[1:]
+	if len(chars) < need {
+		// Some encoders strip trailing spaces, which encode zero bits.
+		chars = append(append([]byte{}, chars...), bytes.Repeat([]byte{' '}, need-len(chars))...)
+	}
+	out := make([]byte, 0, need/4*3)
+	for i := 0; i < need; i += 4 {
+		var v [4]byte
+		for j := 0; j < 4; j++ {
+			c := chars[i+j]
+			if c < ' ' || c > '`' {
+				return nil, fmt.Errorf("invalid character %q", c)
+			}
+			v[j] = (c - ' ') & 63
+		}
+		out = append(out, v[0]<<2|v[1]>>4, v[1]<<4|v[2]>>2, v[2]<<6|v[3])
+	}
+	return out[:n], nil
+}
+
+// decodeUU decodes a uuencoded body. The "begin" line is optional, so that
+// bodies that only contain the encoded lines are accepted too. Lines that
+// cannot be decoded are skipped and reported as warnings.
+func decodeUU(body []byte) (uuBlock, error) {
+	var b uuBlock
+	lines := bytes.Split(body, []byte{'\n'})
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimRight(string(lines[i]), "\r")
+		if strings.HasPrefix(line, "begin ") {
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) == 3 {
+				b.Mode, b.Filename = fields[1], fields[2]
+			}
+			i++
+			break
+		}
+		if strings.TrimSpace(line) != "" {
+			// No begin line; the data starts right away.
+			break
+		}
+	}
+
+	var data bytes.Buffer
+	ended := false
+	for ; i < len(lines); i++ {
+		line := bytes.TrimRight(lines[i], "\r")
+		if string(bytes.TrimSpace(line)) == "end" {
+			ended = true
+			break
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		dec, err := uuDecodeLine(line)
+		if err != nil {
+			b.Warnings = append(b.Warnings, fmt.Sprintf("uuencode: skipped line %d: %s", i+1, err))
+			continue
+		}
+		data.Write(dec)
+	}
+	if !ended {
+		b.Warnings = append(b.Warnings, "uuencode: missing end line")
+	}
+	if data.Len() == 0 && len(b.Warnings) > 0 {
+		return b, errors.New("uuencode: no data could be decoded")
+	}
+	b.Data = data.Bytes()
+	return b, nil
+}
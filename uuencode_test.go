@@ -0,0 +1,51 @@
+package eml
+
+import (
+	"reflect"
+	"testing"
+)
+
+type decodeUUTest struct {
+	body     string
+	block    uuBlock
+	hasError bool
+}
+
+var decodeUUTests = []decodeUUTest{
+	{
+		"begin 644 cat.txt\n#0V%T\n`\nend\n",
+		uuBlock{Filename: "cat.txt", Mode: "644", Data: []byte("Cat")},
+		false,
+	},
+	{
+		"begin 600 hello.txt\r\n.2&5L;&\\L('=O<FQD(0H`\r\n`\r\nend\r\n",
+		uuBlock{Filename: "hello.txt", Mode: "600", Data: []byte("Hello, world!\n")},
+		false,
+	},
+	{
+		"\n#0V%T\n#0V%T\n",
+		uuBlock{Data: []byte("CatCat"), Warnings: []string{"uuencode: missing end line"}},
+		false,
+	},
+	{
+		"begin 644 x\n#0V%T\n#0V\x01T\nend\n",
+		uuBlock{Filename: "x", Mode: "644", Data: []byte("Cat"), Warnings: []string{"uuencode: skipped line 3: invalid character '\\x01'"}},
+		false,
+	},
+	{
+		"begin 644 x\n#\x01\x01\x01\n",
+		uuBlock{Filename: "x", Mode: "644", Warnings: []string{"uuencode: skipped line 2: invalid character '\\x01'", "uuencode: missing end line"}},
+		true,
+	},
+}
+
+func TestDecodeUU(t *testing.T) {
+	for _, ut := range decodeUUTests {
+		b, err := decodeUU([]byte(ut.body))
+		if (err != nil) != ut.hasError {
+			t.Errorf("decodeUU(%q) returned error %v", ut.body, err)
+		} else if !reflect.DeepEqual(b, ut.block) {
+			t.Errorf("decodeUU(%q) gave %#v; expected %#v", ut.body, b, ut.block)
+		}
+	}
+}
@@ -0,0 +1,624 @@
+// Address syntax validation.
+
+package eml
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// AddressValidity classifies the syntax of an address string.
+type AddressValidity int
+
+const (
+	// AddressInvalid means the string is not an address under any of the
+	// supported grammars.
+	AddressInvalid AddressValidity = iota
+	// AddressValid means the string matches the RFC 5322 address grammar.
+	AddressValid
+	// AddressInternationalized means the string contains UTF-8 and is only
+	// valid under the extended grammar of RFC 6532.
+	AddressInternationalized
+	// AddressObsolete means the string is only valid using the obsolete
+	// syntax of RFC 5322 section 4, which must be accepted but not generated.
+	AddressObsolete
+)
+
+func (v AddressValidity) String() string {
+	switch v {
+	case AddressValid:
+		return "valid"
+	case AddressInternationalized:
+		return "internationalized"
+	case AddressObsolete:
+		return "obsolete"
+	default:
+		return "invalid"
+	}
+}
+
+// AddressError describes why and where an address failed validation.
+type AddressError struct {
+	Pos    int
+	Reason string
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("invalid address at offset %d: %s", e.Pos, e.Reason)
+}
+
+// AddressValidation is the result of ValidateAddress. For anything other than
+// a plainly valid address, Reason and Pos explain the classification; Pos is a
+// byte offset into the validated string and is -1 for valid addresses.
+type AddressValidation struct {
+	Validity AddressValidity
+	Reason   string
+	Pos      int
+}
+
+// Valid reports whether the address is acceptable under any grammar.
+func (v AddressValidation) Valid() bool {
+	return v.Validity != AddressInvalid
+}
+
+// Err returns an *AddressError for invalid addresses and nil otherwise.
+func (v AddressValidation) Err() error {
+	if v.Validity != AddressInvalid {
+		return nil
+	}
+	return &AddressError{v.Pos, v.Reason}
+}
+
+// ValidateAddress checks s against the address grammar of RFC 5322 section
+// 3.4 (a mailbox or a group), including the obsolete syntax of section 4.4
+// and the UTF-8 extensions of RFC 6532. When an address is both obsolete and
+// internationalized, it is reported as obsolete.
+func ValidateAddress(s string) AddressValidation {
+	v := &addrValidator{s: s, obsPos: -1, utf8Pos: -1}
+	if err := v.address(); err != nil {
+		return AddressValidation{AddressInvalid, err.Reason, err.Pos}
+	}
+	switch {
+	case v.obsPos >= 0:
+		return AddressValidation{AddressObsolete, v.obsReason, v.obsPos}
+	case v.utf8Pos >= 0:
+		return AddressValidation{AddressInternationalized, "non-ASCII character", v.utf8Pos}
+	}
+	return AddressValidation{AddressValid, "", -1}
+}
+
+type addrValidator struct {
+	s   string
+	pos int
+
+	obsPos    int
+	obsReason string
+	utf8Pos   int
+}
+
+// addrItem is one lexical element of a phrase or local part.
+type addrItem struct {
+	kind byte // 'a'tom, 'q'uoted-string, '.' or ' ' for CFWS
+	pos  int
+}
+
+func (v *addrValidator) fail(pos int, format string, args ...interface{}) *AddressError {
+	return &AddressError{pos, fmt.Sprintf(format, args...)}
+}
+
+func (v *addrValidator) obsolete(pos int, reason string) {
+	if v.obsPos < 0 {
+		v.obsPos, v.obsReason = pos, reason
+	}
+}
+
+func (v *addrValidator) eof() bool {
+	return v.pos >= len(v.s)
+}
+
+func (v *addrValidator) peek() byte {
+	if v.eof() {
+		return 0
+	}
+	return v.s[v.pos]
+}
+
+// utf8Char consumes a non-ASCII UTF-8 sequence at the current position.
+func (v *addrValidator) utf8Char() *AddressError {
+	r, n := utf8.DecodeRuneInString(v.s[v.pos:])
+	if r == utf8.RuneError && n <= 1 {
+		return v.fail(v.pos, "invalid UTF-8 sequence")
+	}
+	if v.utf8Pos < 0 {
+		v.utf8Pos = v.pos
+	}
+	v.pos += n
+	return nil
+}
+
+func isAtext(b byte) bool {
+	switch {
+	case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		return true
+	}
+	switch b {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '/', '=', '?', '^', '_', '`', '{', '|', '}', '~':
+		return true
+	}
+	return false
+}
+
+// isObsNoWSCtl matches obs-NO-WS-CTL: US-ASCII control characters that do not
+// include carriage return, line feed, and white space characters.
+func isObsNoWSCtl(b byte) bool {
+	return (b >= 1 && b <= 8) || b == 11 || b == 12 || (b >= 14 && b <= 31) || b == 127
+}
+
+// fws consumes folding white space and reports whether any was present.
+func (v *addrValidator) fws() (bool, *AddressError) {
+	start := v.pos
+	for !v.eof() {
+		switch b := v.s[v.pos]; {
+		case isWSP(b):
+			v.pos++
+		case b == '\r' || b == '\n':
+			p := v.pos
+			if b == '\r' {
+				p++
+				if p >= len(v.s) || v.s[p] != '\n' {
+					return false, v.fail(v.pos, "bare CR")
+				}
+			}
+			p++
+			if p >= len(v.s) || !isWSP(v.s[p]) {
+				return false, v.fail(v.pos, "line break not followed by white space")
+			}
+			if b == '\n' {
+				v.obsolete(v.pos, "bare LF in folding white space")
+			}
+			v.pos = p
+		default:
+			return v.pos > start, nil
+		}
+	}
+	return v.pos > start, nil
+}
+
+// quotedPair consumes a backslash and the character it escapes.
+func (v *addrValidator) quotedPair() *AddressError {
+	start := v.pos
+	v.pos++
+	if v.eof() {
+		return v.fail(start, "backslash at end of input")
+	}
+	b := v.s[v.pos]
+	switch {
+	case b >= 0x80:
+		return v.utf8Char()
+	case b > 32 && b < 127, isWSP(b):
+	case b == 0, isObsNoWSCtl(b), b == '\r', b == '\n':
+		v.obsolete(start, "quoted control character")
+	}
+	v.pos++
+	return nil
+}
+
+// comment consumes a (possibly nested) comment starting at '('.
+func (v *addrValidator) comment() *AddressError {
+	start := v.pos
+	v.pos++
+	for {
+		if _, err := v.fws(); err != nil {
+			return err
+		}
+		if v.eof() {
+			return v.fail(start, "unterminated comment")
+		}
+		switch b := v.s[v.pos]; {
+		case b == ')':
+			v.pos++
+			return nil
+		case b == '(':
+			if err := v.comment(); err != nil {
+				return err
+			}
+		case b == '\\':
+			if err := v.quotedPair(); err != nil {
+				return err
+			}
+		case b >= 0x80:
+			if err := v.utf8Char(); err != nil {
+				return err
+			}
+		case b > 32 && b < 127:
+			v.pos++
+		case isObsNoWSCtl(b):
+			v.obsolete(v.pos, "control character in comment")
+			v.pos++
+		default:
+			return v.fail(v.pos, "invalid character %q in comment", b)
+		}
+	}
+}
+
+// cfws consumes any comments and folding white space and reports whether
+// anything was consumed.
+func (v *addrValidator) cfws() (bool, *AddressError) {
+	start := v.pos
+	for {
+		if _, err := v.fws(); err != nil {
+			return false, err
+		}
+		if v.peek() != '(' {
+			return v.pos > start, nil
+		}
+		if err := v.comment(); err != nil {
+			return false, err
+		}
+	}
+}
+
+func (v *addrValidator) atom() {
+	for !v.eof() {
+		b := v.s[v.pos]
+		if isAtext(b) {
+			v.pos++
+		} else if b >= 0x80 && v.utf8Char() == nil {
+			continue
+		} else {
+			return
+		}
+	}
+}
+
+func (v *addrValidator) quotedString() *AddressError {
+	start := v.pos
+	v.pos++
+	for {
+		if _, err := v.fws(); err != nil {
+			return err
+		}
+		if v.eof() {
+			return v.fail(start, "unterminated quoted string")
+		}
+		switch b := v.s[v.pos]; {
+		case b == '"':
+			v.pos++
+			return nil
+		case b == '\\':
+			if err := v.quotedPair(); err != nil {
+				return err
+			}
+		case b >= 0x80:
+			if err := v.utf8Char(); err != nil {
+				return err
+			}
+		case b > 32 && b < 127:
+			v.pos++
+		case isObsNoWSCtl(b):
+			v.obsolete(v.pos, "control character in quoted string")
+			v.pos++
+		default:
+			return v.fail(v.pos, "invalid character %q in quoted string", b)
+		}
+	}
+}
+
+// words consumes a run of atoms, quoted strings, dots and CFWS, which is the
+// common prefix of a display name and a local part.
+func (v *addrValidator) words() ([]addrItem, *AddressError) {
+	var items []addrItem
+	for {
+		start := v.pos
+		ws, err := v.cfws()
+		if err != nil {
+			return nil, err
+		}
+		if ws {
+			items = append(items, addrItem{' ', start})
+		}
+		start = v.pos
+		switch b := v.peek(); {
+		case b == '"':
+			if err := v.quotedString(); err != nil {
+				return nil, err
+			}
+			items = append(items, addrItem{'q', start})
+		case b == '.':
+			v.pos++
+			items = append(items, addrItem{'.', start})
+		case isAtext(b) || b >= 0x80:
+			if b >= 0x80 {
+				if err := v.utf8Char(); err != nil {
+					return nil, err
+				}
+			}
+			v.atom()
+			items = append(items, addrItem{'a', start})
+		default:
+			return items, nil
+		}
+	}
+}
+
+func trimCFWS(items []addrItem) []addrItem {
+	for len(items) > 0 && items[0].kind == ' ' {
+		items = items[1:]
+	}
+	for len(items) > 0 && items[len(items)-1].kind == ' ' {
+		items = items[:len(items)-1]
+	}
+	return items
+}
+
+// phrase checks that items form a display name.
+func (v *addrValidator) phrase(items []addrItem) *AddressError {
+	items = trimCFWS(items)
+	if len(items) == 0 {
+		return v.fail(v.pos, "empty display name")
+	}
+	if items[0].kind == '.' {
+		return v.fail(items[0].pos, "display name starts with '.'")
+	}
+	for _, it := range items {
+		if it.kind == '.' {
+			v.obsolete(it.pos, "unquoted '.' in display name")
+			break
+		}
+	}
+	return nil
+}
+
+// localPart checks that items form the local part of an addr-spec.
+func (v *addrValidator) localPart(items []addrItem) *AddressError {
+	items = trimCFWS(items)
+	if len(items) == 0 {
+		return v.fail(v.pos, "empty local part")
+	}
+	words, quoted, prev := 0, false, byte('.')
+	for _, it := range items {
+		switch it.kind {
+		case ' ':
+			v.obsolete(it.pos, "comment or white space in local part")
+			continue
+		case '.':
+			if prev == '.' {
+				if words == 0 {
+					return v.fail(it.pos, "local part starts with '.'")
+				}
+				return v.fail(it.pos, "consecutive '.' in local part")
+			}
+		default:
+			if prev != '.' {
+				return v.fail(it.pos, "missing '.' between words of local part")
+			}
+			words++
+			quoted = quoted || it.kind == 'q'
+		}
+		prev = it.kind
+	}
+	if prev == '.' {
+		return v.fail(items[len(items)-1].pos, "local part ends with '.'")
+	}
+	if quoted && words > 1 {
+		v.obsolete(items[0].pos, "local part mixes quoted strings and atoms")
+	}
+	return nil
+}
+
+// domain consumes the domain of an addr-spec, including surrounding CFWS.
+func (v *addrValidator) domain() *AddressError {
+	if _, err := v.cfws(); err != nil {
+		return err
+	}
+	if v.peek() == '[' {
+		if err := v.domainLiteral(); err != nil {
+			return err
+		}
+		_, err := v.cfws()
+		return err
+	}
+	for {
+		start := v.pos
+		v.atom()
+		if v.pos == start {
+			if v.eof() {
+				return v.fail(v.pos, "missing domain")
+			}
+			return v.fail(v.pos, "invalid character %q in domain", v.s[v.pos])
+		}
+		ws, err := v.cfws()
+		if err != nil {
+			return err
+		}
+		if v.peek() != '.' {
+			return nil
+		}
+		if ws {
+			v.obsolete(start, "comment or white space in domain")
+		}
+		v.pos++
+		if ws, err = v.cfws(); err != nil {
+			return err
+		} else if ws {
+			v.obsolete(v.pos, "comment or white space in domain")
+		}
+	}
+}
+
+func (v *addrValidator) domainLiteral() *AddressError {
+	start := v.pos
+	v.pos++
+	for {
+		if _, err := v.fws(); err != nil {
+			return err
+		}
+		if v.eof() {
+			return v.fail(start, "unterminated domain literal")
+		}
+		switch b := v.s[v.pos]; {
+		case b == ']':
+			v.pos++
+			return nil
+		case b == '[':
+			return v.fail(v.pos, "'[' inside domain literal")
+		case b == '\\':
+			v.obsolete(v.pos, "quoted pair in domain literal")
+			if err := v.quotedPair(); err != nil {
+				return err
+			}
+		case b >= 0x80:
+			if err := v.utf8Char(); err != nil {
+				return err
+			}
+		case b > 32 && b < 127:
+			v.pos++
+		case isObsNoWSCtl(b):
+			v.obsolete(v.pos, "control character in domain literal")
+			v.pos++
+		default:
+			return v.fail(v.pos, "invalid character %q in domain literal", b)
+		}
+	}
+}
+
+// addrSpec finishes an addr-spec whose local part has already been scanned.
+func (v *addrValidator) addrSpec(local []addrItem) *AddressError {
+	if v.peek() != '@' {
+		if v.eof() {
+			return v.fail(v.pos, "missing '@'")
+		}
+		return v.fail(v.pos, "unexpected character %q, expected '@'", v.s[v.pos])
+	}
+	if err := v.localPart(local); err != nil {
+		return err
+	}
+	v.pos++
+	return v.domain()
+}
+
+// route consumes an obs-route ("@a,@b:") inside an angle address.
+func (v *addrValidator) route() *AddressError {
+	v.obsolete(v.pos, "source route in angle address")
+	for {
+		if _, err := v.cfws(); err != nil {
+			return err
+		}
+		switch v.peek() {
+		case ',':
+			v.pos++
+		case '@':
+			v.pos++
+			if err := v.domain(); err != nil {
+				return err
+			}
+		case ':':
+			v.pos++
+			return nil
+		default:
+			return v.fail(v.pos, "malformed source route")
+		}
+	}
+}
+
+func (v *addrValidator) angleAddr() *AddressError {
+	start := v.pos
+	v.pos++
+	if _, err := v.cfws(); err != nil {
+		return err
+	}
+	if b := v.peek(); b == '@' || b == ',' {
+		if err := v.route(); err != nil {
+			return err
+		}
+	}
+	if v.peek() == '>' {
+		return v.fail(v.pos, "empty angle address")
+	}
+	local, err := v.words()
+	if err != nil {
+		return err
+	}
+	if err := v.addrSpec(local); err != nil {
+		return err
+	}
+	if v.peek() != '>' {
+		if v.eof() {
+			return v.fail(start, "unterminated angle address")
+		}
+		return v.fail(v.pos, "unexpected character %q, expected '>'", v.s[v.pos])
+	}
+	v.pos++
+	_, err = v.cfws()
+	return err
+}
+
+// mailbox finishes a mailbox whose leading words have already been scanned.
+func (v *addrValidator) mailbox(items []addrItem) *AddressError {
+	if v.peek() == '<' {
+		if len(trimCFWS(items)) > 0 {
+			if err := v.phrase(items); err != nil {
+				return err
+			}
+		}
+		return v.angleAddr()
+	}
+	return v.addrSpec(items)
+}
+
+func (v *addrValidator) group(name []addrItem) *AddressError {
+	if err := v.phrase(name); err != nil {
+		return err
+	}
+	v.pos++
+	for {
+		items, err := v.words()
+		if err != nil {
+			return err
+		}
+		switch b := v.peek(); {
+		case b == ';' && len(trimCFWS(items)) == 0:
+		case b == ',' && len(trimCFWS(items)) == 0:
+			v.obsolete(v.pos, "empty entry in group list")
+		default:
+			if err := v.mailbox(items); err != nil {
+				return err
+			}
+		}
+		switch v.peek() {
+		case ',':
+			v.pos++
+		case ';':
+			v.pos++
+			_, err := v.cfws()
+			return err
+		default:
+			if v.eof() {
+				return v.fail(v.pos, "unterminated group, expected ';'")
+			}
+			return v.fail(v.pos, "unexpected character %q in group", v.s[v.pos])
+		}
+	}
+}
+
+func (v *addrValidator) address() *AddressError {
+	items, err := v.words()
+	if err != nil {
+		return err
+	}
+	if v.eof() && len(trimCFWS(items)) == 0 {
+		return v.fail(0, "empty address")
+	}
+	if v.peek() == ':' {
+		err = v.group(items)
+	} else {
+		err = v.mailbox(items)
+	}
+	if err != nil {
+		return err
+	}
+	if !v.eof() {
+		return v.fail(v.pos, "unexpected character %q after address", v.s[v.pos])
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package eml
+
+import "testing"
+
+type validateAddressTest struct {
+	addr     string
+	validity AddressValidity
+	pos      int
+}
+
+var validateAddressTests = []validateAddressTest{
+	{`jdoe@example.org`, AddressValid, -1},
+	{`"Joe Q. Public" <john.q.public@example.com>`, AddressValid, -1},
+	{`Pete(A nice \) chap) <pete(his account)@silly.test(his host)>`, AddressValid, -1},
+	{`"Giant; \"Big\" Box" <sysservices@example.net>`, AddressValid, -1},
+	{`A Group:Ed Jones <c@a.test>,joe@where.test,John <jdoe@one.test>;`, AddressValid, -1},
+	{`Undisclosed recipients:;`, AddressValid, -1},
+	{`<boss@[192.0.2.1]>`, AddressValid, -1},
+	{`"john doe"@example.com`, AddressValid, -1},
+
+	{`Joe Q. Public <john.q.public@example.com>`, AddressObsolete, 5},
+	{`john . q . public@example.com`, AddressObsolete, 4},
+	{`"john".q@example.com`, AddressObsolete, 0},
+	{`<@route.test,@other.test:joe@example.com>`, AddressObsolete, 1},
+	{`joe@example . com`, AddressObsolete, 4},
+	{`Group:,joe@example.com;`, AddressObsolete, 6},
+
+	{`jürgen@bücher.example`, AddressInternationalized, 1},
+	{`"Ünal" <u@example.com>`, AddressInternationalized, 1},
+
+	{``, AddressInvalid, 0},
+	{`joe`, AddressInvalid, 3},
+	{`joe@`, AddressInvalid, 4},
+	{`.joe@example.com`, AddressInvalid, 0},
+	{`joe.@example.com`, AddressInvalid, 3},
+	{`jo..e@example.com`, AddressInvalid, 3},
+	{`joe smith@example.com`, AddressInvalid, 4},
+	{`Bob <bob@example.com`, AddressInvalid, 4},
+	{`"Bob <bob@example.com>`, AddressInvalid, 0},
+	{`bob@exa mple.com`, AddressInvalid, 8},
+	{`Group:joe@example.com`, AddressInvalid, 21},
+	{`joe@example.com>`, AddressInvalid, 15},
+	{"j\xffoe@example.com", AddressInvalid, 1},
+	{"joe@example.com\r\n", AddressInvalid, 15},
+}
+
+func TestValidateAddress(t *testing.T) {
+	for _, vt := range validateAddressTests {
+		res := ValidateAddress(vt.addr)
+		if res.Validity != vt.validity || res.Pos != vt.pos {
+			t.Errorf("ValidateAddress(%#v) gave %v at %d (%s); expected %v at %d",
+				vt.addr, res.Validity, res.Pos, res.Reason, vt.validity, vt.pos)
+		}
+		if (res.Err() == nil) != res.Valid() {
+			t.Errorf("ValidateAddress(%#v): Err and Valid disagree", vt.addr)
+		}
+	}
+}